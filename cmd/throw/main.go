@@ -0,0 +1,21 @@
+// Command throw is the command line client of throw, it needs no display. The GUI is built from the root of the module.
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+
+	"github.com/BitlyTwiser/throw/src/cli"
+)
+
+func main() {
+	// Interrupting cancels in-flight transfers and ends watch.
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	code := cli.Run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+
+	cancel()
+	os.Exit(code)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"math/rand"
@@ -11,22 +12,37 @@ import (
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
+	"github.com/BitlyTwiser/throw/src/dav"
+	"github.com/BitlyTwiser/throw/src/fileview"
+	"github.com/BitlyTwiser/throw/src/gateway"
 	"github.com/BitlyTwiser/throw/src/notifications"
 	"github.com/BitlyTwiser/throw/src/pufs_client"
 	"github.com/BitlyTwiser/throw/src/settings"
+	"github.com/BitlyTwiser/throw/src/sniff"
 	"github.com/BitlyTwiser/throw/src/toolbar"
-
-	pufs_pb "github.com/BitlyTwiser/pufs-server/proto"
-
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"github.com/BitlyTwiser/throw/src/transfers"
+	"github.com/BitlyTwiser/throw/src/watcher"
 )
 
-func initializeUI(w fyne.Window, client pufs_client.IpfsClient) {
+// folderWatcher is nil when no watch folder is set.
+func initializeUI(ctx context.Context, w fyne.Window, client *pufs_client.IpfsClient, manager *transfers.TransferManager, folderWatcher *watcher.Watcher) {
 	toolbar := widget.NewToolbar(
-		widget.NewToolbarAction(theme.DocumentCreateIcon(), func() { toolbar.UploadFile(w, client) }),
+		widget.NewToolbarAction(theme.DocumentCreateIcon(), func() { toolbar.UploadFile(ctx, w, client, manager) }),
+		widget.NewToolbarAction(theme.MailSendIcon(), func() { toolbar.UploadToRecipients(ctx, w, client.Settings, manager) }),
+		widget.NewToolbarAction(theme.FolderOpenIcon(), func() { toolbar.UploadFolder(w, manager) }),
+		widget.NewToolbarAction(theme.DownloadIcon(), func() { toolbar.DownloadFolder(ctx, w, client, manager) }),
+		widget.NewToolbarAction(theme.StorageIcon(), func() { toolbar.BrowseArchive(ctx, w, client) }),
 		widget.NewToolbarSeparator(),
-		widget.NewToolbarAction(theme.SettingsIcon(), func() { toolbar.Settings(client.Settings) }),
+		widget.NewToolbarAction(theme.SettingsIcon(), func() { toolbar.Settings(client.Settings, manager) }),
+		widget.NewToolbarAction(theme.WarningIcon(), func() {
+			if folderWatcher == nil || folderWatcher.Mode() != watcher.ModeTwoWay {
+				notifications.SendErrorNotification("Sync conflicts only occur with a watch folder in two-way sync mode")
+
+				return
+			}
+
+			toolbar.ConflictsWindow(folderWatcher)
+		}),
 		widget.NewToolbarSpacer(),
 		widget.NewToolbarAction(theme.HelpIcon(), func() { toolbar.HelpWindow() }),
 	)
@@ -45,9 +61,11 @@ func initializeUI(w fyne.Window, client pufs_client.IpfsClient) {
 			fileNameLabel := widget.NewLabel("")
 			fileNameLabel.Wrapping = 1
 
+			fileIcon := widget.NewIcon(theme.FileIcon())
+
 			return container.NewGridWithColumns(
 				5,
-				container.NewPadded(fileNameLabel),
+				container.NewPadded(container.NewBorder(nil, nil, fileIcon, nil, fileNameLabel)),
 				container.NewPadded(fileMetadata),
 				container.NewPadded(editButton),
 				container.NewPadded(downloadButton),
@@ -55,16 +73,35 @@ func initializeUI(w fyne.Window, client pufs_client.IpfsClient) {
 			)
 		},
 		func(i widget.ListItemID, o fyne.CanvasObject) {
-			o.(*fyne.Container).Objects[0].(*fyne.Container).Objects[0].(*widget.Label).SetText(client.Files[i])
+			// Encrypted names are shown decrypted, or as a placeholder without the key.
+			label := client.DisplayName(client.Files[i])
+
+			if m := client.GetFileMetadata(client.Files[i]); m != nil && m.LinkTarget != "" {
+				label = fmt.Sprintf("%v (duplicate of %v)", label, client.DisplayName(m.LinkTarget))
+			}
+
+			name := o.(*fyne.Container).Objects[0].(*fyne.Container).Objects[0].(*fyne.Container)
+			name.Objects[0].(*widget.Label).SetText(label)
+			name.Objects[1].(*widget.Icon).SetResource(fileview.FileIcon(client.MimeType(client.Files[i])))
+
+			// Only files sniffed as text can be edited. Files this client has never seen are left to the editor to check.
+			editButton := o.(*fyne.Container).Objects[2].(*fyne.Container).Objects[0].(*widget.Button)
+
+			if m := client.GetFileMetadata(client.Files[i]); m != nil && m.MimeType != "" && !sniff.IsText(m.MimeType) {
+				editButton.Disable()
+			} else {
+				editButton.Enable()
+			}
+
 			o.(*fyne.Container).Objects[1].(*fyne.Container).Objects[0].(*widget.Button).OnTapped = func() {
-				pufs_client.FileMetadata(*client.GetFileMetadata(client.Files[i]))
+				fileview.FileMetadata(*client.GetFileMetadata(client.Files[i]), client.DisplayName(client.Files[i]))
 			}
 			o.(*fyne.Container).Objects[2].(*fyne.Container).Objects[0].(*widget.Button).OnTapped = func() {
 				fileName := client.Files[i]
-				w := fyne.CurrentApp().NewWindow(fmt.Sprintf("Edit %v", fileName))
+				w := fyne.CurrentApp().NewWindow(fmt.Sprintf("Edit %v", client.DisplayName(fileName)))
 				w.Resize(fyne.NewSize(300, 400))
 
-				err := client.Download(fileName)
+				err := client.Download(ctx, fileName)
 
 				if err != nil {
 					notifications.SendErrorNotification("Error opening file for editing.")
@@ -81,17 +118,17 @@ func initializeUI(w fyne.Window, client pufs_client.IpfsClient) {
 				}
 
 				// Open File editor
-				w.SetContent(pufs_client.FileEditor(*data, client, fileName, w))
+				w.SetContent(fileview.FileEditor(ctx, *data, client, fileName, w))
 
 				w.Show()
 			}
 			o.(*fyne.Container).Objects[3].(*fyne.Container).Objects[0].(*widget.Button).OnTapped = func() {
-				client.Download(client.Files[i])
+				manager.Download(client.Files[i])
 			}
 			o.(*fyne.Container).Objects[4].(*fyne.Container).Objects[0].(*widget.Button).OnTapped = func() {
 				var message *fyne.Notification
 				fileName := client.Files[i]
-				err := client.DeleteFile(fileName, true)
+				err := client.DeleteFile(ctx, fileName, true)
 
 				if err != nil {
 					message = fyne.NewNotification("Error", fmt.Sprintf("Error deleting file: %v", fileName))
@@ -104,34 +141,66 @@ func initializeUI(w fyne.Window, client pufs_client.IpfsClient) {
 		},
 	)
 
-	go func() {
-		for {
-			select {
-			case file := <-client.FileUpload:
-				client.Files = append(client.Files, file)
-				log.Println("Refreshing.")
-				fileList.Refresh()
-			case file := <-client.DeletedFile:
-				var refresh []string
-				for _, v := range client.Files {
-					if v != file {
-						refresh = append(refresh, v)
-					}
-				}
-				client.Files = refresh
-				log.Println("Refreshing after deleting file.")
-				fileList.Refresh()
-			}
-		}
-	}()
+	// The client keeps its file list current, events arrive from transfers and the server stream alike.
+	client.OnEvent(func(pufs_client.Event) {
+		fileList.Refresh()
+	})
+
+	split := container.NewVSplit(fileList, transfers.Panel(manager))
+	split.Offset = 0.75
 
-	content := container.NewBorder(toolbar, nil, nil, nil, fileList)
+	content := container.NewBorder(toolbar, nil, nil, nil, split)
 
 	w.SetContent(content)
 }
 
 var id int64
 
+// Returns the watcher of the watch folder, nil when none is set or it cannot be watched.
+func newWatcher(client *pufs_client.IpfsClient, folder string) *watcher.Watcher {
+	if folder == "" {
+		return nil
+	}
+
+	folderWatcher, err := watcher.New(client, folder, watcher.DefaultDebounce)
+
+	if err != nil {
+		watchError(folder, err)
+
+		return nil
+	}
+
+	return folderWatcher
+}
+
+// Syncs the watch folder until the application closes.
+func watchFolder(ctx context.Context, folderWatcher *watcher.Watcher, folder string) {
+	if err := folderWatcher.Run(ctx); err != nil {
+		watchError(folder, err)
+	}
+}
+
+// Serves the store over WebDAV until the application closes.
+func serveWebDAV(ctx context.Context, client *pufs_client.IpfsClient, address string) {
+	if err := dav.Serve(ctx, client, address, client.Settings.LocalToken); err != nil {
+		log.Printf("Error serving WebDAV on %v. Error: %v", address, err)
+		notifications.SendErrorNotification(fmt.Sprintf("Error serving WebDAV on %v. Error: %v", address, err))
+	}
+}
+
+// Streams files over the HTTP gateway until the application closes.
+func serveGateway(ctx context.Context, client *pufs_client.IpfsClient, address string) {
+	if err := gateway.Serve(ctx, client, address, client.Settings.LocalToken); err != nil {
+		log.Printf("Error serving the gateway on %v. Error: %v", address, err)
+		notifications.SendErrorNotification(fmt.Sprintf("Error serving the gateway on %v. Error: %v", address, err))
+	}
+}
+
+func watchError(folder string, err error) {
+	log.Printf("Error watching %v. Error: %v", folder, err)
+	notifications.SendErrorNotification(fmt.Sprintf("Error watching %v. Error: %v", folder, err))
+}
+
 func main() {
 	a := app.New()
 	w := a.NewWindow("Throw")
@@ -144,7 +213,13 @@ func main() {
 
 	s := settings.LoadSettings()
 
-	conn, err := grpc.Dial(fmt.Sprintf("%v:%v", s.Host, s.Port), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	// Host credentials come from the vault, they are read on every request once it is unlocked.
+	client, err := pufs_client.New(
+		fmt.Sprintf("%v:%v", s.Host, s.Port),
+		pufs_client.WithId(id),
+		pufs_client.WithSettings(s),
+		pufs_client.WithNotifier(notifications.Desktop{}),
+	)
 
 	if err != nil {
 		log.Fatalf("Error connection to server: %v", err)
@@ -152,32 +227,52 @@ func main() {
 		log.Println("Connected to gRPC server")
 	}
 
-	defer conn.Close()
-
-	c := pufs_pb.NewIpfsFileSystemClient(conn)
-
-	client := pufs_client.IpfsClient{
-		Id:                id,
-		Client:            c,
-		Files:             []string{},
-		FileUpload:        make(chan string, 1),
-		DeletedFile:       make(chan string, 1),
-		FileDeleted:       make(chan bool, 2),
-		FileUploadedInApp: make(chan bool, 2),
-		Settings:          s,
-		InvalidFileTypes:  []string{"ELF", "EXE"},
-		FileMetadata:      make(map[string]pufs_client.FileData),
-	}
+	defer client.Close()
+
 	// Remove  client after connection ends
-	defer client.UnsubscribeClient()
+	// The application context is cancelled by then, so unsubscribing gets a fresh context.
+	defer client.UnsubscribeClient(context.Background())
+
+	// Cancelled once the window closes, stopping every in-flight request and transfer.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Nothing talks to the server until the vault is unlocked, requests need the host credentials and transfers need the keys.
+	toolbar.UnlockVault(w, s, func() {
+		// Load existing files from server on application start
+		client.LoadFiles(ctx)
+
+		// Pick up any transfers that were interrupted by a crash or disconnect.
+		go client.ResumeTransfers(ctx)
+
+		manager := transfers.NewTransferManager(ctx, client, s.ConcurrentTransfers)
+
+		// A key rotation that was interrupted continues within the transfers panel.
+		if rotation, err := pufs_client.PendingRotation(); err != nil {
+			log.Printf("Error loading key rotation. Error: %v", err)
+		} else if rotation != nil {
+			manager.Rotate(rotation.KeyId, client.Settings.Keyring.Name(rotation.KeyId), rotation.IncludePlaintext)
+		}
 
-	// Load existing files from server on application start
-	client.LoadFiles()
+		folderWatcher := newWatcher(client, s.WatchFolder)
 
-	// Initialize the UI elements.
-	initializeUI(w, client)
+		// Initialize the UI elements.
+		initializeUI(ctx, w, client, manager, folderWatcher)
+
+		if folderWatcher != nil {
+			go watchFolder(ctx, folderWatcher, s.WatchFolder)
+		}
+
+		if s.WebDAVAddress != "" {
+			go serveWebDAV(ctx, client, s.WebDAVAddress)
+		}
+
+		if s.GatewayAddress != "" {
+			go serveGateway(ctx, client, s.GatewayAddress)
+		}
 
-	go client.SubscribeFileStream()
+		go client.SubscribeFileStream(ctx)
+	})
 
 	w.ShowAndRun()
 }
@@ -0,0 +1,224 @@
+// Package archive packs the files of a folder into a single tar stream and indexes where the data of every file sits within it.
+// Thousands of small files upload far faster as one object, the index is stored next to the archive so its files can be listed and read back without downloading all of it.
+package archive
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BitlyTwiser/throw/src/tree"
+)
+
+// The index of an archive is stored under the name of the archive followed by IndexSuffix.
+const IndexSuffix = ".index"
+
+// Bumped whenever the index format changes in a way older clients cannot read.
+const indexVersion = 1
+
+// Index describes every file within an archive.
+type Index struct {
+	Version int `json:"version"`
+	// Size and checksum of the whole tar stream.
+	Size      int64     `json:"size"`
+	Checksum  string    `json:"checksum"`
+	CreatedAt time.Time `json:"created_at"`
+	// Ordered by name.
+	Entries []Entry `json:"entries"`
+}
+
+// Entry is a single file within an archive.
+type Entry struct {
+	// Path relative to the packed folder, slash separated.
+	Name string `json:"name"`
+	// Offset of the file data within the tar stream, past its header.
+	Offset   int64       `json:"offset"`
+	Size     int64       `json:"size"`
+	Mode     os.FileMode `json:"mode"`
+	ModTime  time.Time   `json:"mod_time"`
+	Checksum string      `json:"checksum"`
+}
+
+// IndexName returns the name the index of the archive shown as name is stored under.
+func IndexName(name string) string {
+	return name + IndexSuffix
+}
+
+// IsIndex reports if a name is that of an index.
+func IsIndex(name string) bool {
+	return strings.HasSuffix(name, IndexSuffix)
+}
+
+// Pack writes a tar of the files to w and returns the index of its entries. The files are written in the order given.
+func Pack(w io.Writer, files []tree.File) (*Index, error) {
+	counter := &countingWriter{w: w, h: sha256.New()}
+	tw := tar.NewWriter(counter)
+	index := &Index{Version: indexVersion, CreatedAt: time.Now().UTC()}
+
+	for _, f := range files {
+		entry, err := pack(tw, counter, f)
+
+		if err != nil {
+			return nil, fmt.Errorf("error packing %v. Error: %w", f.Path, err)
+		}
+
+		index.Entries = append(index.Entries, entry)
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	index.Size = counter.n
+	index.Checksum = hex.EncodeToString(counter.h.Sum(nil))
+
+	sort.Slice(index.Entries, func(i, j int) bool { return index.Entries[i].Name < index.Entries[j].Name })
+
+	return index, nil
+}
+
+func pack(tw *tar.Writer, counter *countingWriter, f tree.File) (Entry, error) {
+	file, err := os.Open(f.Path)
+
+	if err != nil {
+		return Entry{}, err
+	}
+
+	defer file.Close()
+
+	// The size within the header has to match the data, a file changing while packed would corrupt the tar.
+	info, err := file.Stat()
+
+	if err != nil {
+		return Entry{}, err
+	}
+
+	header, err := tar.FileInfoHeader(info, "")
+
+	if err != nil {
+		return Entry{}, err
+	}
+
+	header.Name = f.Name
+	header.Format = tar.FormatPAX
+
+	if err := tw.WriteHeader(header); err != nil {
+		return Entry{}, err
+	}
+
+	entry := Entry{
+		Name:    f.Name,
+		Offset:  counter.n,
+		Size:    info.Size(),
+		Mode:    info.Mode(),
+		ModTime: info.ModTime().UTC(),
+	}
+
+	h := sha256.New()
+
+	n, err := io.Copy(io.MultiWriter(tw, h), io.LimitReader(file, info.Size()))
+
+	if err != nil {
+		return Entry{}, err
+	}
+
+	if n != info.Size() {
+		return Entry{}, errors.New("the file shrank while it was packed")
+	}
+
+	entry.Checksum = hex.EncodeToString(h.Sum(nil))
+
+	return entry, nil
+}
+
+// Marshal encodes the index as stored next to the archive.
+func (i *Index) Marshal() ([]byte, error) {
+	return json.Marshal(i)
+}
+
+// ParseIndex decodes a stored index.
+func ParseIndex(data []byte) (*Index, error) {
+	var index Index
+
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("error reading archive index. Error: %w", err)
+	}
+
+	if index.Version > indexVersion {
+		return nil, fmt.Errorf("the archive index was written by a newer version of throw, version: %v", index.Version)
+	}
+
+	return &index, nil
+}
+
+// Entry returns the entry with the given name.
+func (i *Index) Entry(name string) (Entry, bool) {
+	n := sort.Search(len(i.Entries), func(j int) bool { return i.Entries[j].Name >= name })
+
+	if n < len(i.Entries) && i.Entries[n].Name == name {
+		return i.Entries[n], true
+	}
+
+	return Entry{}, false
+}
+
+// Section returns the data of an entry from a tar stream read from its start. The bytes before the entry are skipped, nothing past its end is read.
+// The checksum of the entry is verified once all of it has been read, a mismatch is returned by Read in place of io.EOF.
+func Section(r io.Reader, e Entry) (io.Reader, error) {
+	if _, err := io.CopyN(io.Discard, r, e.Offset); err != nil {
+		return nil, fmt.Errorf("error seeking to %v. Error: %w", e.Name, err)
+	}
+
+	return &sectionReader{r: io.LimitReader(r, e.Size), entry: e, h: sha256.New()}, nil
+}
+
+type sectionReader struct {
+	r     io.Reader
+	entry Entry
+	h     hash.Hash
+	read  int64
+}
+
+func (s *sectionReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.h.Write(p[:n])
+	s.read += int64(n)
+
+	if err != io.EOF {
+		return n, err
+	}
+
+	if s.read != s.entry.Size {
+		return n, fmt.Errorf("%v ended after %v of %v bytes: %w", s.entry.Name, s.read, s.entry.Size, io.ErrUnexpectedEOF)
+	}
+
+	if sum := hex.EncodeToString(s.h.Sum(nil)); sum != s.entry.Checksum {
+		return n, fmt.Errorf("checksum of %v does not match the archive index", s.entry.Name)
+	}
+
+	return n, io.EOF
+}
+
+// Counts and hashes the bytes of the tar stream, so every entry knows its offset.
+type countingWriter struct {
+	w io.Writer
+	h hash.Hash
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.h.Write(p[:n])
+	c.n += int64(n)
+
+	return n, err
+}
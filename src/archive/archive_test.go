@@ -0,0 +1,209 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/BitlyTwiser/throw/src/tree"
+)
+
+// Sizes around the 512 byte tar block, names long enough to need a PAX header.
+var testFiles = []struct {
+	name string
+	size int
+}{
+	{"empty.txt", 0},
+	{"one.bin", 1},
+	{"docs/block-1.bin", 511},
+	{"docs/block.bin", 512},
+	{"docs/block+1.bin", 513},
+	{"docs/" + strings.Repeat("nested/", 20) + "deep.txt", 3000},
+	{"naïve café.txt", 100},
+	{"b.txt", 70000},
+}
+
+func content(name string, size int) []byte {
+	data := make([]byte, size)
+
+	for i := range data {
+		data[i] = name[i%len(name)] ^ byte(i)
+	}
+
+	return data
+}
+
+func packTestFiles(t *testing.T) ([]byte, *Index) {
+	dir := t.TempDir()
+	var files []tree.File
+
+	for _, f := range testFiles {
+		path := filepath.Join(dir, filepath.FromSlash(f.name))
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, content(f.name, f.size), 0o640); err != nil {
+			t.Fatal(err)
+		}
+
+		files = append(files, tree.File{Path: path, Name: f.name, Size: int64(f.size)})
+	}
+
+	var buf bytes.Buffer
+
+	index, err := Pack(&buf, files)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes(), index
+}
+
+// Offsets in the index must point at the data of every file, whatever the size of the entries before it.
+func TestPackOffsets(t *testing.T) {
+	data, index := packTestFiles(t)
+	sum := sha256.Sum256(data)
+
+	if index.Version != indexVersion || index.Size != int64(len(data)) || index.Checksum != hex.EncodeToString(sum[:]) {
+		t.Errorf("index describes %v bytes with checksum %v, the tar is %v bytes", index.Size, index.Checksum, len(data))
+	}
+
+	if len(index.Entries) != len(testFiles) {
+		t.Fatalf("index has %v entries, want %v", len(index.Entries), len(testFiles))
+	}
+
+	for i := 1; i < len(index.Entries); i++ {
+		if index.Entries[i-1].Name >= index.Entries[i].Name {
+			t.Errorf("entries are not ordered by name, %v before %v", index.Entries[i-1].Name, index.Entries[i].Name)
+		}
+	}
+
+	for _, f := range testFiles {
+		want := content(f.name, f.size)
+		e, ok := index.Entry(f.name)
+
+		if !ok {
+			t.Fatalf("index lacks %v", f.name)
+		}
+
+		if e.Size != int64(f.size) || e.Mode.Perm() != 0o640 {
+			t.Errorf("%v: entry has size %v and mode %v", f.name, e.Size, e.Mode)
+		}
+
+		if got := data[e.Offset : e.Offset+e.Size]; !bytes.Equal(got, want) {
+			t.Errorf("%v: the bytes at offset %v are not the file", f.name, e.Offset)
+		}
+
+		r, err := Section(bytes.NewReader(data), e)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%v: Section did not return the file, err %v", f.name, err)
+		}
+	}
+
+	// The archive stays a plain tar.
+	tr := tar.NewReader(bytes.NewReader(data))
+	read := 0
+
+	for {
+		header, err := tr.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, _ := io.ReadAll(tr)
+		e, _ := index.Entry(header.Name)
+
+		if !bytes.Equal(got, data[e.Offset:e.Offset+e.Size]) {
+			t.Errorf("tar reader and index disagree on %v", header.Name)
+		}
+
+		read++
+	}
+
+	if read != len(testFiles) {
+		t.Errorf("tar reader found %v files, want %v", read, len(testFiles))
+	}
+}
+
+func TestSectionRejects(t *testing.T) {
+	data, index := packTestFiles(t)
+	e, _ := index.Entry("docs/block.bin")
+
+	changed := append([]byte{}, data...)
+	changed[e.Offset+10] ^= 1
+
+	if _, err := io.ReadAll(mustSection(t, changed, e)); err == nil {
+		t.Error("Section accepted changed data")
+	}
+
+	if _, err := io.ReadAll(mustSection(t, data[:e.Offset+e.Size-1], e)); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Section of a cut archive = %v, want io.ErrUnexpectedEOF", err)
+	}
+
+	if _, err := Section(bytes.NewReader(data[:e.Offset-1]), e); err == nil {
+		t.Error("Section seeked past the end of the archive")
+	}
+}
+
+func mustSection(t *testing.T, data []byte, e Entry) io.Reader {
+	r, err := Section(bytes.NewReader(data), e)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func TestIndexRoundTrip(t *testing.T) {
+	_, index := packTestFiles(t)
+
+	data, err := index.Marshal()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseIndex(data)
+
+	if err != nil || !reflect.DeepEqual(parsed, index) {
+		t.Fatalf("ParseIndex did not return the index, err %v", err)
+	}
+
+	if _, ok := parsed.Entry("missing.txt"); ok {
+		t.Error("Entry found a file that was not packed")
+	}
+
+	if _, err := ParseIndex([]byte(`{"version": 2}`)); err == nil {
+		t.Error("ParseIndex accepted an index of a newer version")
+	}
+
+	if _, err := ParseIndex([]byte("not json")); err == nil {
+		t.Error("ParseIndex accepted invalid data")
+	}
+
+	if name := IndexName("photos.tar"); name != "photos.tar.index" || !IsIndex(name) || IsIndex("photos.tar") {
+		t.Errorf("IndexName of photos.tar = %v", name)
+	}
+}
@@ -0,0 +1,181 @@
+// Package cid computes IPFS content identifiers locally, matching what `ipfs add` produces with its default importer settings.
+// Data is split into 256KiB chunks, wrapped in UnixFS DAG-PB nodes and linked into a balanced DAG of up to 174 links per node.
+package cid
+
+import (
+	"crypto/sha256"
+	"io"
+	"strings"
+)
+
+const (
+	// CIDv0: DAG-PB leaves, base58btc encoded "Qm..." identifiers. This is the `ipfs add` default.
+	V0 = 0
+	// CIDv1: raw leaves, base32 encoded "b..." identifiers. Matches `ipfs add --cid-version=1`.
+	V1 = 1
+
+	// Default chunker of go-ipfs, size-262144.
+	ChunkSize = 256 << 10
+	// Default link count per node within the balanced layout.
+	MaxLinks = 174
+)
+
+// Multicodec and multihash identifiers.
+const (
+	codecRaw    = 0x55
+	codecDagPb  = 0x70
+	sha2_256    = 0x12
+	unixfsFile  = 2
+	cidVersion1 = 1
+)
+
+type link struct {
+	cid []byte
+	// Serialized size of the linked node and everything below it.
+	tsize uint64
+	// Amount of file data held below the link.
+	fileSize uint64
+}
+
+// Builder computes a CID from data written to it. Only the current chunk and a link per chunk are held in memory.
+type Builder struct {
+	version int
+	chunk   []byte
+	leaves  []link
+}
+
+func NewBuilder(version int) *Builder {
+	return &Builder{version: version, chunk: make([]byte, 0, ChunkSize)}
+}
+
+func (b *Builder) Write(p []byte) (int, error) {
+	n := len(p)
+
+	for len(p) > 0 {
+		space := ChunkSize - len(b.chunk)
+
+		if space > len(p) {
+			space = len(p)
+		}
+
+		b.chunk = append(b.chunk, p[:space]...)
+		p = p[space:]
+
+		if len(b.chunk) == ChunkSize {
+			b.flush()
+		}
+	}
+
+	return n, nil
+}
+
+// Sum returns the CID of everything written so far.
+func (b *Builder) Sum() string {
+	leaves := b.leaves
+
+	// A trailing partial chunk, or an empty file, makes up the final leaf.
+	if len(b.chunk) > 0 || len(leaves) == 0 {
+		leaves = append(append([]link{}, leaves...), b.leaf(b.chunk))
+	}
+
+	return encode(b.version, b.root(leaves).cid)
+}
+
+func (b *Builder) flush() {
+	b.leaves = append(b.leaves, b.leaf(b.chunk))
+	b.chunk = b.chunk[:0]
+}
+
+func (b *Builder) leaf(data []byte) link {
+	if b.version == V1 {
+		return link{cid: cidBytes(b.version, codecRaw, data), tsize: uint64(len(data)), fileSize: uint64(len(data))}
+	}
+
+	node := dagNode(nil, unixfsData(data, uint64(len(data)), nil))
+
+	return link{cid: cidBytes(b.version, codecDagPb, node), tsize: uint64(len(node)), fileSize: uint64(len(data))}
+}
+
+// Builds the balanced DAG bottom up. Grouping each level into nodes of MaxLinks matches the layout of the go-ipfs balanced builder.
+func (b *Builder) root(level []link) link {
+	for len(level) > 1 {
+		var next []link
+
+		for i := 0; i < len(level); i += MaxLinks {
+			end := i + MaxLinks
+
+			if end > len(level) {
+				end = len(level)
+			}
+
+			next = append(next, b.parent(level[i:end]))
+		}
+
+		level = next
+	}
+
+	return level[0]
+}
+
+func (b *Builder) parent(children []link) link {
+	var fileSize, tsize uint64
+	blockSizes := make([]uint64, len(children))
+
+	for i, c := range children {
+		fileSize += c.fileSize
+		tsize += c.tsize
+		blockSizes[i] = c.fileSize
+	}
+
+	node := dagNode(children, unixfsData(nil, fileSize, blockSizes))
+
+	return link{cid: cidBytes(b.version, codecDagPb, node), tsize: tsize + uint64(len(node)), fileSize: fileSize}
+}
+
+// Compute returns the CID of everything read from r.
+func Compute(r io.Reader, version int) (string, error) {
+	b := NewBuilder(version)
+
+	if _, err := io.Copy(b, r); err != nil {
+		return "", err
+	}
+
+	return b.Sum(), nil
+}
+
+// Bytes returns the CID of data.
+func Bytes(data []byte, version int) string {
+	b := NewBuilder(version)
+	b.Write(data)
+
+	return b.Sum()
+}
+
+// Normalize strips the path prefix IPFS adds to identifiers ("/ipfs/Qm..." becomes "Qm...").
+func Normalize(id string) string {
+	return strings.TrimPrefix(strings.TrimSpace(id), "/ipfs/")
+}
+
+// Binary CID of a block. CIDv0 is a bare multihash.
+func cidBytes(version int, codec uint64, block []byte) []byte {
+	sum := sha256.Sum256(block)
+	multihash := append([]byte{sha2_256, sha256.Size}, sum[:]...)
+
+	if version == V0 {
+		return multihash
+	}
+
+	c := appendVarint(nil, cidVersion1)
+	c = appendVarint(c, codec)
+
+	return append(c, multihash...)
+}
+
+func encode(version int, c []byte) string {
+	if version == V0 {
+		return base58Encode(c)
+	}
+
+	// Multibase prefix for lowercase base32 without padding.
+	return "b" + base32Encode(c)
+}
@@ -0,0 +1,116 @@
+package cid
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"strings"
+	"testing"
+)
+
+// Identifiers printed by `ipfs add` and `ipfs add --cid-version=1` for the same data.
+func TestBytesKnownAnswers(t *testing.T) {
+	tests := []struct {
+		data string
+		v0   string
+		v1   string
+	}{
+		{"", "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH", "bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku"},
+		{"hello world", "Qmf412jQZiuVUtdgnB36FXFX7xg5V6KEbSJ4dpQuhkLyfD", "bafkreifzjut3te2nhyekklss27nh3k72ysco7y32koao5eei66wof36n5e"},
+		{"hello world\n", "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o", "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"},
+	}
+
+	for _, test := range tests {
+		if got := Bytes([]byte(test.data), V0); got != test.v0 {
+			t.Errorf("CIDv0 of %q = %v, want %v", test.data, got, test.v0)
+		}
+
+		if got := Bytes([]byte(test.data), V1); got != test.v1 {
+			t.Errorf("CIDv1 of %q = %v, want %v", test.data, got, test.v1)
+		}
+	}
+}
+
+// A single chunk is stored as one raw leaf by CIDv1, its CID is that of the block itself.
+func TestBytesSingleRawLeaf(t *testing.T) {
+	for _, size := range []int{1, 1000, ChunkSize} {
+		data := bytes.Repeat([]byte{0xab}, size)
+		sum := sha256.Sum256(data)
+		block := append([]byte{cidVersion1, codecRaw, sha2_256, sha256.Size}, sum[:]...)
+		want := "b" + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(block))
+
+		if got := Bytes(data, V1); got != want {
+			t.Errorf("CIDv1 of %v bytes = %v, want %v", size, got, want)
+		}
+	}
+}
+
+// Chunk boundaries and the layout of the DAG must not depend on how the data is written.
+func TestBuilderWriteSizes(t *testing.T) {
+	data := make([]byte, 3*ChunkSize+12345)
+
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	for _, version := range []int{V0, V1} {
+		want := Bytes(data, version)
+
+		for _, size := range []int{1 << 10, ChunkSize - 1, ChunkSize, ChunkSize + 1, len(data)} {
+			b := NewBuilder(version)
+
+			for rest := data; len(rest) > 0; {
+				n := size
+
+				if n > len(rest) {
+					n = len(rest)
+				}
+
+				b.Write(rest[:n])
+				rest = rest[n:]
+			}
+
+			if got := b.Sum(); got != want {
+				t.Errorf("version %v written in %v byte pieces = %v, want %v", version, size, got, want)
+			}
+		}
+
+		computed, err := Compute(bytes.NewReader(data), version)
+
+		if err != nil || computed != want {
+			t.Errorf("Compute of version %v = %v, %v, want %v", version, computed, err, want)
+		}
+	}
+}
+
+// Data past a chunk gets a parent node, so the identifier cannot be that of a single leaf.
+func TestBytesChunking(t *testing.T) {
+	chunk := bytes.Repeat([]byte{1}, ChunkSize)
+	more := append(append([]byte{}, chunk...), 1)
+
+	if Bytes(chunk, V0) == Bytes(more, V0) || Bytes(chunk, V1) == Bytes(more, V1) {
+		t.Fatal("a byte past the first chunk did not change the CID")
+	}
+
+	if !strings.HasPrefix(Bytes(more, V0), "Qm") || !strings.HasPrefix(Bytes(more, V1), "bafybei") {
+		t.Errorf("a file of two chunks should have a DAG-PB root, got %v and %v", Bytes(more, V0), Bytes(more, V1))
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{"QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o", "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"},
+		{"/ipfs/QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o", "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"},
+		{" QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o\n", "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if got := Normalize(test.id); got != test.want {
+			t.Errorf("Normalize(%q) = %q, want %q", test.id, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package cid
+
+import (
+	"encoding/base32"
+	"math/big"
+)
+
+// Protobuf wire types.
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+// Serializes a DAG-PB node. Links are written before data, matching the canonical DAG-PB encoding.
+func dagNode(links []link, data []byte) []byte {
+	var node []byte
+
+	for _, l := range links {
+		var pbLink []byte
+		pbLink = appendBytesField(pbLink, 1, l.cid)
+		// go-ipfs always sets the (empty) link name
+		pbLink = appendBytesField(pbLink, 2, nil)
+		pbLink = appendVarintField(pbLink, 3, l.tsize)
+
+		node = appendBytesField(node, 2, pbLink)
+	}
+
+	return appendBytesField(node, 1, data)
+}
+
+// Serializes a UnixFS file node.
+func unixfsData(data []byte, fileSize uint64, blockSizes []uint64) []byte {
+	var pb []byte
+	pb = appendVarintField(pb, 1, unixfsFile)
+
+	if len(data) > 0 {
+		pb = appendBytesField(pb, 2, data)
+	}
+
+	pb = appendVarintField(pb, 3, fileSize)
+
+	for _, size := range blockSizes {
+		pb = appendVarintField(pb, 4, size)
+	}
+
+	return pb
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+
+	return append(b, byte(v))
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = appendVarint(b, uint64(field<<3|wireVarint))
+
+	return appendVarint(b, v)
+}
+
+func appendBytesField(b []byte, field int, v []byte) []byte {
+	b = appendVarint(b, uint64(field<<3|wireBytes))
+	b = appendVarint(b, uint64(len(v)))
+
+	return append(b, v...)
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+func base58Encode(data []byte) string {
+	n := new(big.Int).SetBytes(data)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+
+	var out []byte
+
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+
+	// Leading zero bytes are encoded as the first character of the alphabet.
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+
+		out = append(out, base58Alphabet[0])
+	}
+
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+
+	return string(out)
+}
+
+var base32Lower = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+func base32Encode(data []byte) string {
+	return base32Lower.EncodeToString(data)
+}
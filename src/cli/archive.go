@@ -0,0 +1,213 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/BitlyTwiser/throw/src/archive"
+	"github.com/BitlyTwiser/throw/src/compression"
+	"github.com/BitlyTwiser/throw/src/pufs_client"
+	"github.com/BitlyTwiser/throw/src/tree"
+)
+
+// Describes a packed archive within the output of pack.
+type packed struct {
+	Path  string `json:"path"`
+	Name  string `json:"name"`
+	Files int    `json:"files"`
+	Size  int64  `json:"size"`
+}
+
+// Packs a folder into a single tar upload, with an index for entries and extract.
+func (c *CLI) pack(ctx context.Context, args []string) error {
+	flags := c.flags("pack")
+	name := flags.String("name", "", "Name to store the archive under. Defaults to the folder name followed by .tar")
+	compress := flags.String("compress", "", "Compress the archive with gzip or zstd, none turns compression off. Defaults to the compression settings")
+
+	var include, exclude patterns
+	flags.Var(&include, "include", "Only pack the files matching the pattern, may be given more than once")
+	flags.Var(&exclude, "exclude", "Skip the files and folders matching the pattern, may be given more than once")
+
+	if err := c.parse(flags, args, 1, 1); err != nil {
+		return err
+	}
+
+	root := flags.Arg(0)
+	filter := tree.Filter{Include: include, Exclude: exclude}
+
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+
+	switch *compress {
+	case "", compression.None, compression.Gzip, compression.Zstd:
+	default:
+		return fmt.Errorf("unknown compression %v, use none, gzip or zstd", *compress)
+	}
+
+	if *name == "" {
+		abs, err := filepath.Abs(root)
+
+		if err != nil {
+			return err
+		}
+
+		*name = filepath.Base(abs) + ".tar"
+	}
+
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+
+	if *compress != "" {
+		ctx = pufs_client.WithCompression(ctx, *compress)
+	}
+
+	var stored string
+	ctx = pufs_client.WithStoredName(ctx, func(s string) { stored = s })
+
+	index, err := c.client.UploadArchive(c.progress(ctx, *name), root, *name, filter)
+
+	if err != nil {
+		return err
+	}
+
+	result := packed{Path: root, Name: c.client.DisplayName(stored), Files: len(index.Entries), Size: index.Size}
+
+	if c.json {
+		return c.writeJSON(result)
+	}
+
+	fmt.Fprintf(c.stdout, "%v packed into %v, %v files and %v bytes\n", result.Path, result.Name, result.Files, result.Size)
+
+	return nil
+}
+
+// Lists the files within an archive from its index, without downloading the archive.
+func (c *CLI) entries(ctx context.Context, args []string) error {
+	flags := c.flags("entries")
+	long := flags.Bool("l", false, "Show the size and modification time of each file")
+
+	if err := c.parse(flags, args, 1, 2); err != nil {
+		return err
+	}
+
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+
+	index, err := c.client.ArchiveIndex(ctx, flags.Arg(0))
+
+	if err != nil {
+		return err
+	}
+
+	entries := []archive.Entry{}
+
+	for _, e := range index.Entries {
+		if strings.HasPrefix(e.Name, flags.Arg(1)) {
+			entries = append(entries, e)
+		}
+	}
+
+	if c.json {
+		return c.writeJSON(entries)
+	}
+
+	if !*long {
+		for _, e := range entries {
+			fmt.Fprintln(c.stdout, e.Name)
+		}
+
+		return nil
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+
+	for _, e := range entries {
+		fmt.Fprintf(w, "%v\t%v\t%v\t  %v\n", e.Mode, e.Size, e.ModTime.Format(time.RFC3339), e.Name)
+	}
+
+	return w.Flush()
+}
+
+// Pulls single files out of an archive. Each file is read from the start of the archive up to its end, the rest of the archive is never downloaded.
+// Files within the folder are only replaced with -f.
+func (c *CLI) extract(ctx context.Context, args []string) error {
+	flags := c.flags("extract")
+	destination := flags.String("o", ".", "Folder to extract into, or - to write the files to stdout")
+	force := flags.Bool("f", false, "Replace files that exist within the folder")
+
+	if err := c.parse(flags, args, 2, -1); err != nil {
+		return err
+	}
+
+	if *destination != "-" {
+		if info, err := os.Stat(*destination); err != nil {
+			return err
+		} else if !info.IsDir() {
+			return fmt.Errorf("%v is not a folder", *destination)
+		}
+	}
+
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+
+	name := flags.Arg(0)
+	index, err := c.client.ArchiveIndex(ctx, name)
+
+	if err != nil {
+		return err
+	}
+
+	if *destination == "-" {
+		for _, entry := range flags.Args()[1:] {
+			if err := c.catEntry(ctx, name, index, entry); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	var results []result
+
+	for _, entry := range flags.Args()[1:] {
+		extracted := result{Name: entry}
+
+		if extracted.Path, err = c.client.ExtractArchiveEntry(ctx, name, index, entry, *destination, *force); errors.Is(err, fs.ErrExist) {
+			extracted.Error = err.Error() + ", -f replaces it"
+		} else if err != nil {
+			extracted.Error = err.Error()
+		}
+
+		results = append(results, extracted)
+	}
+
+	return c.report(results, func(r result) string {
+		return fmt.Sprintf("%v saved to %v", r.Name, r.Path)
+	})
+}
+
+func (c *CLI) catEntry(ctx context.Context, name string, index *archive.Index, entry string) error {
+	r, err := c.client.OpenArchiveEntry(ctx, name, index, entry)
+
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	_, err = io.Copy(c.stdout, r)
+
+	return err
+}
@@ -0,0 +1,268 @@
+// Package cli is the command line frontend of throw. It runs without a display, so uploads and downloads can be scripted from CI and servers.
+// Commands read the same settings file and vault as the GUI, init creates them on machines the GUI never ran on.
+// The vault passphrase is taken from THROW_PASSPHRASE, a file or stdin, never prompted for.
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/BitlyTwiser/throw/src/pufs_client"
+	"github.com/BitlyTwiser/throw/src/settings"
+)
+
+// Exit codes returned by Run.
+const (
+	ExitOK    = 0
+	ExitError = 1
+	ExitUsage = 2
+)
+
+type command struct {
+	args string
+	help string
+	run  func(c *CLI, ctx context.Context, args []string) error
+}
+
+var commands map[string]command
+
+// Commands refer to the usage within this table, so it is filled in once the package is initialized.
+func init() {
+	commands = map[string]command{
+		"init":    {"[-host host] [-port port] [-download-path folder]", "Create the vault, and the settings file when it is missing, under the vault passphrase", (*CLI).initVault},
+		"ls":      {"[-l] [prefix]", "List stored files, optionally only those whose name starts with prefix", (*CLI).ls},
+		"put":     {"[-name name] path... | -name name - | -r [-name name] [-include pattern] [-exclude pattern] folder...", "Upload files, the files directly within folders, stdin when the path is -, or whole folders with -r", (*CLI).put},
+		"get":     {"name [destination | -] | -r [-include pattern] [-exclude pattern] folder [destination]", "Download a file into a folder, to a file path, or to stdout when the destination is -. With -r, download a folder", (*CLI).get},
+		"rm":      {"name...", "Delete files", (*CLI).rm},
+		"stat":    {"name...", "Describe files", (*CLI).stat},
+		"cat":     {"name...", "Write the content of files to stdout", (*CLI).cat},
+		"watch":   {"", "Print files announced by the server as they change, until interrupted", (*CLI).watch},
+		"pack":    {"[-name name] [-compress none | gzip | zstd] [-include pattern] [-exclude pattern] folder", "Pack a folder into a single tar upload, stored along with an index of its files", (*CLI).pack},
+		"entries": {"[-l] archive [prefix]", "List the files within a packed archive from its index", (*CLI).entries},
+		"extract": {"[-f] [-o folder | -o -] archive file...", "Pull single files out of a packed archive, each read from the start of the archive up to its end", (*CLI).extract},
+	}
+}
+
+// CLI holds the options and connection of a single run.
+type CLI struct {
+	stdin          io.Reader
+	stdout         io.Writer
+	stderr         io.Writer
+	json           bool
+	verbose        bool
+	settingsFile   string
+	passphraseFile string
+	client         *pufs_client.IpfsClient
+}
+
+// Returned once the usage has been printed, the arguments given cannot be run.
+var errUsage = errors.New("invalid usage")
+
+// Run runs the command within args, the arguments following the program name, and returns the exit code.
+func Run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	c := &CLI{stdin: stdin, stdout: stdout, stderr: stderr}
+
+	flags := c.flags("throw")
+	flags.Usage = c.usage
+
+	if err := flags.Parse(args); err != nil {
+		return c.exit(err)
+	}
+
+	if flags.NArg() == 0 {
+		c.usage()
+
+		return ExitUsage
+	}
+
+	cmd, ok := commands[flags.Arg(0)]
+
+	if !ok {
+		fmt.Fprintf(c.stderr, "Unknown command %v\n\n", flags.Arg(0))
+		c.usage()
+
+		return ExitUsage
+	}
+
+	defer c.close()
+
+	return c.exit(cmd.run(c, ctx, flags.Args()[1:]))
+}
+
+func (c *CLI) exit(err error) int {
+	switch {
+	case err == nil || errors.Is(err, flag.ErrHelp):
+		return ExitOK
+	case errors.Is(err, errUsage):
+		return ExitUsage
+	}
+
+	fmt.Fprintf(c.stderr, "throw: %v\n", err)
+
+	return ExitError
+}
+
+func (c *CLI) usage() {
+	fmt.Fprintln(c.stderr, "Usage: throw [options] command [arguments]")
+	fmt.Fprintln(c.stderr, "\nCommands:")
+
+	var names []string
+
+	for name := range commands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(c.stderr, "  %-8v %v\n           %v\n", name, commands[name].args, commands[name].help)
+	}
+
+	fmt.Fprintln(c.stderr, "\nOptions, accepted before or after the command:")
+	c.flags("throw").PrintDefaults()
+}
+
+// Returns a flag set holding the options every command accepts.
+func (c *CLI) flags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(c.stderr, "Usage: throw %v %v\n", name, commands[name].args)
+		flags.PrintDefaults()
+	}
+
+	flags.BoolVar(&c.json, "json", c.json, "Write results as JSON")
+	flags.BoolVar(&c.verbose, "v", c.verbose, "Log requests and transfers to stderr")
+	flags.StringVar(&c.settingsFile, "settings", envOr("THROW_SETTINGS", c.settingsFile), "Settings file, the vault is read from next to it. Defaults to $THROW_SETTINGS or the GUI settings file")
+	flags.StringVar(&c.passphraseFile, "passphrase-file", envOr("THROW_PASSPHRASE_FILE", c.passphraseFile), "File holding the vault passphrase, - reads it from stdin. Defaults to $THROW_PASSPHRASE_FILE, $THROW_PASSPHRASE is used when neither is set")
+
+	return flags
+}
+
+// Parses the arguments of a command, requiring between min and max positional arguments. A max below zero is unbounded.
+func (c *CLI) parse(flags *flag.FlagSet, args []string, min, max int) error {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+
+		return errUsage
+	}
+
+	if flags.NArg() < min || (max >= 0 && flags.NArg() > max) {
+		flags.Usage()
+
+		return errUsage
+	}
+
+	// The client logs every request, scripts only want the results.
+	if c.verbose {
+		log.SetOutput(c.stderr)
+	} else {
+		log.SetOutput(io.Discard)
+	}
+
+	return nil
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+
+	return fallback
+}
+
+// Unlocks the vault and connects to the host within the settings, loading the file list.
+func (c *CLI) connect(ctx context.Context) error {
+	// Loading creates missing settings files, a mistyped path should not.
+	s, err := c.settings(false)
+
+	if err != nil {
+		return err
+	}
+
+	if !s.VaultExists() {
+		return errors.New("no vault was found, create it with throw init or start the GUI once")
+	}
+
+	passphrase, err := c.passphrase()
+
+	if err != nil {
+		return err
+	}
+
+	if err := s.UnlockVault(passphrase); err != nil {
+		return fmt.Errorf("error unlocking vault. Error: %w", err)
+	}
+
+	c.client, err = pufs_client.New(fmt.Sprintf("%v:%v", s.Host, s.Port), pufs_client.WithSettings(s))
+
+	if err != nil {
+		return err
+	}
+
+	return c.client.LoadFiles(ctx)
+}
+
+// Loads the settings file given by -settings, or the one of the GUI. Missing files are only created when create is set.
+func (c *CLI) settings(create bool) (*settings.Settings, error) {
+	if c.settingsFile != "" {
+		if _, err := os.Stat(c.settingsFile); err != nil && !(create && os.IsNotExist(err)) {
+			return nil, err
+		}
+
+		settings.UseFile(c.settingsFile)
+	}
+
+	return settings.LoadSettings(), nil
+}
+
+func (c *CLI) passphrase() (string, error) {
+	if c.passphraseFile == "-" {
+		data, err := io.ReadAll(c.stdin)
+
+		if err != nil {
+			return "", err
+		}
+
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	if c.passphraseFile != "" {
+		data, err := os.ReadFile(c.passphraseFile)
+
+		if err != nil {
+			return "", err
+		}
+
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	if passphrase, ok := os.LookupEnv("THROW_PASSPHRASE"); ok {
+		return passphrase, nil
+	}
+
+	return "", errors.New("the vault passphrase is needed, set THROW_PASSPHRASE or -passphrase-file, - reads it from stdin")
+}
+
+func (c *CLI) close() {
+	if c.client != nil {
+		c.client.Close()
+	}
+}
+
+func (c *CLI) writeJSON(v interface{}) error {
+	encoder := json.NewEncoder(c.stdout)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(v)
+}
@@ -0,0 +1,765 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/BitlyTwiser/throw/src/pufs_client"
+	"github.com/BitlyTwiser/throw/src/tree"
+)
+
+// Describes a stored file within the output of ls, stat and watch.
+type fileEntry struct {
+	Name       string `json:"name"`
+	StoredName string `json:"stored_name"`
+	Size       int64  `json:"size"`
+	IpfsHash   string `json:"ipfs_hash"`
+	UploadedAt string `json:"uploaded_at"`
+	// Only known for files this client has transferred.
+	Checksum         string `json:"checksum,omitempty"`
+	MimeType         string `json:"mime_type,omitempty"`
+	Encryption       string `json:"encryption,omitempty"`
+	KeyId            string `json:"key_id,omitempty"`
+	LinkTarget       string `json:"link_target,omitempty"`
+	IpfsHashVerified bool   `json:"ipfs_hash_verified"`
+}
+
+// The result of uploading, downloading or deleting a single file.
+type result struct {
+	Path       string `json:"path,omitempty"`
+	Name       string `json:"name"`
+	StoredName string `json:"stored_name,omitempty"`
+	// Set for files of a folder upload that were already stored with the same content.
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Collects the values of a flag given more than once.
+type patterns []string
+
+func (p *patterns) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patterns) Set(value string) error {
+	*p = append(*p, value)
+
+	return nil
+}
+
+// Flags of folder transfers.
+type folderOptions struct {
+	recursive bool
+	include   patterns
+	exclude   patterns
+}
+
+func (o *folderOptions) register(flags *flag.FlagSet, recursive string) {
+	flags.BoolVar(&o.recursive, "r", false, recursive)
+	flags.Var(&o.include, "include", "Only transfer the files of a folder matching the pattern, may be given more than once. Requires -r")
+	flags.Var(&o.exclude, "exclude", "Skip the files and folders of a folder matching the pattern, may be given more than once. Requires -r")
+}
+
+// Reports a misuse of the folder flags, the usage has been printed by then.
+func (o *folderOptions) check(c *CLI, flags *flag.FlagSet) error {
+	if o.recursive || (len(o.include) == 0 && len(o.exclude) == 0) {
+		return nil
+	}
+
+	fmt.Fprintln(c.stderr, "-include and -exclude can only be given with -r")
+	flags.Usage()
+
+	return errUsage
+}
+
+func (o *folderOptions) filter() tree.Filter {
+	return tree.Filter{Include: o.include, Exclude: o.exclude}
+}
+
+func (c *CLI) entry(m pufs_client.FileData) fileEntry {
+	e := fileEntry{
+		Name:             c.client.DisplayName(m.FileName),
+		StoredName:       m.FileName,
+		Size:             m.FileSize,
+		IpfsHash:         m.IpfsHash,
+		UploadedAt:       m.UploadedAt,
+		Checksum:         m.Checksum,
+		MimeType:         m.MimeType,
+		Encryption:       m.Encryption,
+		KeyId:            m.KeyId,
+		IpfsHashVerified: m.IpfsHashVerified,
+	}
+
+	if m.LinkTarget != "" {
+		e.LinkTarget = c.client.DisplayName(m.LinkTarget)
+	}
+
+	// Metadata holds the time as shown within the GUI, scripts get RFC 3339.
+	if t, err := time.Parse(time.UnixDate, m.UploadedAt); err == nil {
+		e.UploadedAt = t.Format(time.RFC3339)
+	}
+
+	return e
+}
+
+// Describes the settings written by init.
+type initialized struct {
+	Host         string `json:"host"`
+	Port         string `json:"port"`
+	DownloadPath string `json:"download_path"`
+}
+
+// Creates the vault on machines the GUI never ran on. The passphrase is read as for every other command, so the vault can be set up from a script.
+func (c *CLI) initVault(ctx context.Context, args []string) error {
+	flags := c.flags("init")
+	host := flags.String("host", "", "Host of the pufs server, stored within the settings")
+	port := flags.String("port", "", "Port of the pufs server, stored within the settings")
+	downloadPath := flags.String("download-path", "", "Folder the GUI saves downloads to, stored within the settings")
+
+	if err := c.parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	s, err := c.settings(true)
+
+	if err != nil {
+		return err
+	}
+
+	if s.VaultExists() {
+		return errors.New("a vault exists already, remove it to start over")
+	}
+
+	passphrase, err := c.passphrase()
+
+	if err != nil {
+		return err
+	}
+
+	if passphrase == "" {
+		return errors.New("the vault passphrase cannot be empty")
+	}
+
+	if err := s.CreateVault(passphrase); err != nil {
+		return fmt.Errorf("error creating vault. Error: %w", err)
+	}
+
+	if *host != "" || *port != "" || *downloadPath != "" {
+		updated := *s
+
+		if *host != "" {
+			updated.Host = *host
+		}
+
+		if *port != "" {
+			updated.Port = *port
+		}
+
+		if *downloadPath != "" {
+			updated.DownloadPath = *downloadPath
+		}
+
+		if err := s.SaveSettings(updated); err != nil {
+			return err
+		}
+	}
+
+	result := initialized{Host: s.Host, Port: s.Port, DownloadPath: s.DownloadPath}
+
+	if c.json {
+		return c.writeJSON(result)
+	}
+
+	fmt.Fprintf(c.stdout, "Vault created, the server is %v:%v\n", result.Host, result.Port)
+
+	return nil
+}
+
+// Lists the stored files ordered by name.
+func (c *CLI) ls(ctx context.Context, args []string) error {
+	flags := c.flags("ls")
+	long := flags.Bool("l", false, "Show the size and upload time of each file")
+
+	if err := c.parse(flags, args, 0, 1); err != nil {
+		return err
+	}
+
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+
+	files, err := c.client.List(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	entries := []fileEntry{}
+
+	for _, m := range files {
+		if e := c.entry(m); strings.HasPrefix(e.Name, flags.Arg(0)) {
+			entries = append(entries, e)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	if c.json {
+		return c.writeJSON(entries)
+	}
+
+	if !*long {
+		for _, e := range entries {
+			fmt.Fprintln(c.stdout, e.Name)
+		}
+
+		return nil
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+
+	for _, e := range entries {
+		fmt.Fprintf(w, "%v\t%v\t  %v\n", e.Size, e.UploadedAt, e.Name)
+	}
+
+	return w.Flush()
+}
+
+// Uploads files the way the GUI does, through the upload policy and under a unique name.
+func (c *CLI) put(ctx context.Context, args []string) error {
+	flags := c.flags("put")
+	name := flags.String("name", "", "Name to store the file under. Required for stdin, only valid for a single file or folder")
+
+	var folders folderOptions
+	folders.register(flags, "Upload folders with every file below them, stored under the folder name joined with their relative paths")
+
+	if err := c.parse(flags, args, 1, -1); err != nil {
+		return err
+	}
+
+	if *name != "" && flags.NArg() > 1 {
+		fmt.Fprintln(c.stderr, "-name can only be given for a single file or folder")
+		flags.Usage()
+
+		return errUsage
+	}
+
+	if err := folders.check(c, flags); err != nil {
+		return err
+	}
+
+	if folders.recursive {
+		return c.putFolders(ctx, flags.Args(), *name, folders.filter())
+	}
+
+	uploads, err := c.uploads(flags.Args(), *name)
+
+	if err != nil {
+		return err
+	}
+
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+
+	var results []result
+
+	for _, upload := range uploads {
+		if err := c.upload(ctx, &upload); err != nil {
+			upload.Error = err.Error()
+		}
+
+		results = append(results, upload)
+	}
+
+	return c.report(results, func(r result) string {
+		if r.Path == "-" {
+			return fmt.Sprintf("stdin stored as %v", c.storedAs(r))
+		}
+
+		return fmt.Sprintf("%v stored as %v", r.Path, c.storedAs(r))
+	})
+}
+
+// Returns the name an upload is shown under. The server may have stored it under another name than requested, like report (1).pdf.
+func (c *CLI) storedAs(r result) string {
+	if r.StoredName == "" {
+		return r.Name
+	}
+
+	return c.client.DisplayName(r.StoredName)
+}
+
+// Returns the files to upload. Folders add the files directly within them, reading stdin is marked by the path -.
+func (c *CLI) uploads(paths []string, name string) ([]result, error) {
+	var uploads []result
+
+	for _, path := range paths {
+		if path == "-" {
+			if name == "" {
+				return nil, errors.New("a -name is needed to upload stdin")
+			}
+
+			if c.passphraseFile == "-" {
+				return nil, errors.New("stdin cannot hold both the vault passphrase and the file to upload")
+			}
+
+			uploads = append(uploads, result{Path: path, Name: name})
+
+			continue
+		}
+
+		info, err := os.Stat(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			if name == "" {
+				uploads = append(uploads, result{Path: path, Name: filepath.Base(path)})
+			} else {
+				uploads = append(uploads, result{Path: path, Name: name})
+			}
+
+			continue
+		}
+
+		if name != "" {
+			return nil, fmt.Errorf("-name cannot be given for the folder %v", path)
+		}
+
+		entries, err := os.ReadDir(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() {
+				log.Printf("Skipping %v, only files directly within %v are uploaded", entry.Name(), path)
+
+				continue
+			}
+
+			uploads = append(uploads, result{Path: filepath.Join(path, entry.Name()), Name: entry.Name()})
+		}
+	}
+
+	return uploads, nil
+}
+
+// Uploads every selected file below each folder. A file already stored with the same content is skipped, so an interrupted upload can be run again.
+func (c *CLI) putFolders(ctx context.Context, roots []string, name string, filter tree.Filter) error {
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+
+	for _, root := range roots {
+		if info, err := os.Stat(root); err != nil {
+			return err
+		} else if !info.IsDir() {
+			return fmt.Errorf("%v is not a folder, -r only uploads folders", root)
+		}
+	}
+
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+
+	var results []result
+
+	for _, root := range roots {
+		folder := name
+
+		if folder == "" {
+			abs, err := filepath.Abs(root)
+
+			if err != nil {
+				return err
+			}
+
+			folder = filepath.Base(abs)
+		}
+
+		files, err := c.client.UploadTree(c.progress(ctx, folder), root, folder, filter)
+
+		if err != nil && !errors.As(err, new(*pufs_client.TreeError)) {
+			return err
+		}
+
+		results = append(results, treeResults(files)...)
+	}
+
+	return c.report(results, func(r result) string {
+		if r.Skipped {
+			return fmt.Sprintf("%v already stored as %v", r.Path, c.storedAs(r))
+		}
+
+		return fmt.Sprintf("%v stored as %v", r.Path, c.storedAs(r))
+	})
+}
+
+// Logs the progress of a folder transfer at every whole percent, shown with -v.
+func (c *CLI) progress(ctx context.Context, folder string) context.Context {
+	last := -1
+
+	return pufs_client.WithProgress(ctx, func(transferred, total int64) {
+		if total <= 0 {
+			return
+		}
+
+		if percent := int(transferred * 100 / total); percent != last {
+			last = percent
+			log.Printf("%v: %v%% (%v of %v bytes)", folder, percent, transferred, total)
+		}
+	})
+}
+
+func treeResults(files []pufs_client.TreeFile) []result {
+	var results []result
+
+	for _, f := range files {
+		r := result{Path: f.Path, Name: f.Name, StoredName: f.StoredName, Skipped: f.Skipped}
+
+		if f.Err != nil {
+			r.Error = f.Err.Error()
+		}
+
+		results = append(results, r)
+	}
+
+	return results
+}
+
+func (c *CLI) upload(ctx context.Context, upload *result) error {
+	path := upload.Path
+
+	// stdin is spooled to disk, so it goes through the upload policy and journal like any other file.
+	if path == "-" {
+		temp, err := os.CreateTemp("", "throw-stdin-*")
+
+		if err != nil {
+			return err
+		}
+
+		defer os.Remove(temp.Name())
+
+		_, err = io.Copy(temp, c.stdin)
+
+		if closeErr := temp.Close(); err == nil {
+			err = closeErr
+		}
+
+		if err != nil {
+			return err
+		}
+
+		path = temp.Name()
+	}
+
+	ctx = pufs_client.WithStoredName(ctx, func(stored string) { upload.StoredName = stored })
+
+	return c.client.UploadFile(ctx, path, upload.Name)
+}
+
+// Downloads a file into a folder, to a file path or to stdout.
+func (c *CLI) get(ctx context.Context, args []string) error {
+	flags := c.flags("get")
+
+	var folders folderOptions
+	folders.register(flags, "Download a folder with every file below it, rebuilt within the destination folder")
+
+	if err := c.parse(flags, args, 1, 2); err != nil {
+		return err
+	}
+
+	if err := folders.check(c, flags); err != nil {
+		return err
+	}
+
+	destination := flags.Arg(1)
+
+	if destination == "" {
+		destination = "."
+	}
+
+	if folders.recursive && destination == "-" {
+		fmt.Fprintln(c.stderr, "-r downloads into a folder, not to stdout")
+		flags.Usage()
+
+		return errUsage
+	}
+
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+
+	if folders.recursive {
+		return c.getFolder(ctx, flags.Arg(0), destination, folders.filter())
+	}
+
+	if destination == "-" {
+		return c.client.Get(ctx, flags.Arg(0), c.stdout)
+	}
+
+	stored, err := c.client.Lookup(ctx, flags.Arg(0))
+
+	if err != nil {
+		return err
+	}
+
+	download := result{Path: destination, Name: c.client.DisplayName(stored), StoredName: stored}
+
+	if info, statErr := os.Stat(destination); statErr == nil && info.IsDir() {
+		// Downloads into folders are journaled and resumed like those started from the GUI.
+		download.Path = filepath.Join(destination, c.client.LocalFileName(stored))
+		err = c.client.DownloadTo(ctx, stored, destination)
+	} else {
+		err = c.writeFile(ctx, flags.Arg(0), destination)
+	}
+
+	if err != nil {
+		download.Error = err.Error()
+	}
+
+	return c.report([]result{download}, func(r result) string {
+		return fmt.Sprintf("%v saved to %v", r.Name, r.Path)
+	})
+}
+
+func (c *CLI) getFolder(ctx context.Context, folder, destination string, filter tree.Filter) error {
+	if info, err := os.Stat(destination); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%v is not a folder", destination)
+	}
+
+	files, err := c.client.DownloadTree(c.progress(ctx, folder), folder, destination, filter)
+
+	if err != nil && !errors.As(err, new(*pufs_client.TreeError)) {
+		return err
+	}
+
+	return c.report(treeResults(files), func(r result) string {
+		return fmt.Sprintf("%v saved to %v", r.Name, r.Path)
+	})
+}
+
+// Streams a file to path through a temporary file next to it, an interrupted download leaves nothing behind.
+func (c *CLI) writeFile(ctx context.Context, name, path string) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), ".throw-*")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(temp.Name())
+
+	err = c.client.Get(ctx, name, temp)
+
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
+}
+
+func (c *CLI) rm(ctx context.Context, args []string) error {
+	flags := c.flags("rm")
+
+	if err := c.parse(flags, args, 1, -1); err != nil {
+		return err
+	}
+
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+
+	var results []result
+
+	for _, name := range flags.Args() {
+		deleted := result{Name: name}
+
+		stored, err := c.client.Lookup(ctx, name)
+
+		if err == nil {
+			deleted.StoredName = stored
+			err = c.client.Delete(ctx, name)
+		}
+
+		if err != nil {
+			deleted.Error = err.Error()
+		}
+
+		results = append(results, deleted)
+	}
+
+	return c.report(results, func(r result) string {
+		return fmt.Sprintf("%v deleted", r.Name)
+	})
+}
+
+func (c *CLI) stat(ctx context.Context, args []string) error {
+	flags := c.flags("stat")
+
+	if err := c.parse(flags, args, 1, -1); err != nil {
+		return err
+	}
+
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+
+	var entries []fileEntry
+
+	for _, name := range flags.Args() {
+		m, err := c.client.Stat(ctx, name)
+
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, c.entry(m))
+	}
+
+	if c.json {
+		return c.writeJSON(entries)
+	}
+
+	for i, e := range entries {
+		if i > 0 {
+			fmt.Fprintln(c.stdout)
+		}
+
+		w := tabwriter.NewWriter(c.stdout, 0, 4, 1, ' ', 0)
+
+		fmt.Fprintf(w, "Name:\t%v\n", e.Name)
+		fmt.Fprintf(w, "Stored Name:\t%v\n", e.StoredName)
+		fmt.Fprintf(w, "Size:\t%v\n", e.Size)
+		fmt.Fprintf(w, "Uploaded At:\t%v\n", e.UploadedAt)
+		fmt.Fprintf(w, "IPFS Hash:\t%v (verified: %v)\n", e.IpfsHash, e.IpfsHashVerified)
+
+		for _, field := range [][2]string{
+			{"Checksum", e.Checksum},
+			{"Content Type", e.MimeType},
+			{"Encryption", e.Encryption},
+			{"Key", e.KeyId},
+			{"Duplicate Of", e.LinkTarget},
+		} {
+			if field[1] != "" {
+				fmt.Fprintf(w, "%v:\t%v\n", field[0], field[1])
+			}
+		}
+
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *CLI) cat(ctx context.Context, args []string) error {
+	flags := c.flags("cat")
+
+	if err := c.parse(flags, args, 1, -1); err != nil {
+		return err
+	}
+
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+
+	for _, name := range flags.Args() {
+		if err := c.client.Get(ctx, name, c.stdout); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Prints every file announced by the server event stream until ctx is cancelled. JSON is written one object per line.
+func (c *CLI) watch(ctx context.Context, args []string) error {
+	flags := c.flags("watch")
+
+	if err := c.parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+
+	// The context is cancelled by the time the watch ends, unsubscribing gets a fresh one.
+	defer c.client.UnsubscribeClient(context.Background())
+
+	encoder := json.NewEncoder(c.stdout)
+
+	return c.client.WatchRemote(ctx, func(f pufs_client.RemoteFile) {
+		e := fileEntry{
+			Name:       c.client.DisplayName(f.Name),
+			StoredName: f.Name,
+			Size:       f.Size,
+			IpfsHash:   f.IpfsHash,
+			UploadedAt: f.UploadedAt.Format(time.RFC3339),
+		}
+
+		if c.json {
+			encoder.Encode(e)
+
+			return
+		}
+
+		fmt.Fprintf(c.stdout, "%v\t%v\t%v\n", e.UploadedAt, e.Size, e.Name)
+	})
+}
+
+// Prints results, failures go to stderr unless JSON is written. Returns an error when any failed.
+func (c *CLI) report(results []result, describe func(result) string) error {
+	failed := 0
+
+	for _, r := range results {
+		if r.Error != "" {
+			failed++
+		}
+	}
+
+	if c.json {
+		if err := c.writeJSON(results); err != nil {
+			return err
+		}
+	} else {
+		for _, r := range results {
+			if r.Error != "" {
+				fmt.Fprintf(c.stderr, "throw: %v: %v\n", r.Name, r.Error)
+
+				continue
+			}
+
+			fmt.Fprintln(c.stdout, describe(r))
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%v of %v files failed", failed, len(results))
+	}
+
+	return nil
+}
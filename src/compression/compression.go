@@ -0,0 +1,274 @@
+// Package compression compresses file data before it is encrypted and uploaded.
+// Compressed data starts with a short header naming the algorithm, data without the header is passed through untouched.
+// This allows files uploaded with and without compression to sit side by side.
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/BitlyTwiser/throw/src/sniff"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	None = "none"
+	Gzip = "gzip"
+	Zstd = "zstd"
+	// Picks an algorithm based on the content type of the file.
+	Auto = "auto"
+)
+
+// The header is the magic followed by a single byte denoting the algorithm.
+const (
+	headerMagic = "THRWCMP"
+	HeaderSize  = len(headerMagic) + 1
+)
+
+var algorithmIds = map[string]byte{
+	Gzip: 1,
+	Zstd: 2,
+}
+
+var compressibleExtensions = map[string]bool{
+	".txt":  true,
+	".log":  true,
+	".json": true,
+	".csv":  true,
+	".tsv":  true,
+	".xml":  true,
+	".yaml": true,
+	".yml":  true,
+	".md":   true,
+	".html": true,
+	".sql":  true,
+}
+
+// Choose returns the algorithm to use for a file. The head is the start of the file content.
+// Auto mode uses zstd for text like content and skips compression otherwise.
+func Choose(mode, fileName string, head []byte) string {
+	switch mode {
+	case Gzip, Zstd:
+		return mode
+	case Auto:
+		if Compressible(fileName, head) {
+			return Zstd
+		}
+	}
+
+	return None
+}
+
+// Compressible reports if the file name or content denote a content type that compresses well.
+func Compressible(fileName string, head []byte) bool {
+	if compressibleExtensions[strings.ToLower(filepath.Ext(fileName))] {
+		return true
+	}
+
+	// Anything but text is likely compressed already (images, video, archives)
+	return sniff.IsText(sniff.Detect(fileName, head))
+}
+
+func header(algorithm string) ([]byte, error) {
+	id, ok := algorithmIds[algorithm]
+
+	if !ok {
+		return nil, fmt.Errorf("unknown compression algorithm: %v", algorithm)
+	}
+
+	return append([]byte(headerMagic), id), nil
+}
+
+// Returns the algorithm named by the header at the start of data, or None.
+func parseHeader(data []byte) (string, error) {
+	if len(data) < HeaderSize || string(data[:len(headerMagic)]) != headerMagic {
+		return None, nil
+	}
+
+	for algorithm, id := range algorithmIds {
+		if data[len(headerMagic)] == id {
+			return algorithm, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown compression algorithm id: %v", data[len(headerMagic)])
+}
+
+// NewWriter writes the header for algorithm to w, then returns a writer compressing into w. Close must be called to flush the compressed data.
+func NewWriter(w io.Writer, algorithm string) (io.WriteCloser, error) {
+	h, err := header(algorithm)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(h); err != nil {
+		return nil, err
+	}
+
+	switch algorithm {
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	default:
+		return zstd.NewWriter(w)
+	}
+}
+
+// NewReader returns a reader of the decompressed content of r. Data without a compression header is returned as is.
+func NewReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(HeaderSize)
+
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	algorithm, err := parseHeader(head)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch algorithm {
+	case Gzip:
+		br.Discard(HeaderSize)
+
+		return gzip.NewReader(br)
+	case Zstd:
+		br.Discard(HeaderSize)
+
+		d, err := zstd.NewReader(br)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return d.IOReadCloser(), nil
+	}
+
+	return io.NopCloser(br), nil
+}
+
+// Compress returns a reader of the compressed content of r, including the header. Compression runs in a separate goroutine, closing the reader stops it.
+func Compress(r io.Reader, algorithm string) (io.ReadCloser, error) {
+	if _, err := header(algorithm); err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		w, err := NewWriter(pw, algorithm)
+
+		if err != nil {
+			pw.CloseWithError(err)
+
+			return
+		}
+
+		if _, err := io.Copy(w, r); err != nil {
+			w.Close()
+			pw.CloseWithError(err)
+
+			return
+		}
+
+		pw.CloseWithError(w.Close())
+	}()
+
+	return pr, nil
+}
+
+// Bytes compresses data in memory. The data is returned as is if compression would not make it any smaller.
+func Bytes(data []byte, algorithm string) ([]byte, error) {
+	if algorithm == None {
+		return data, nil
+	}
+
+	var buffer bytes.Buffer
+	w, err := NewWriter(&buffer, algorithm)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	if buffer.Len() >= len(data) {
+		return data, nil
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// Decompress reverses Bytes. Data without a compression header is returned as is.
+func Decompress(data []byte) ([]byte, error) {
+	if algorithm, err := parseHeader(data); err != nil || algorithm == None {
+		return data, err
+	}
+
+	r, err := NewReader(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// NewDecompressWriter returns a writer that decompresses everything written to it into w.
+// Close must be called once all data is written, it returns any error raised while decompressing.
+func NewDecompressWriter(w io.Writer) io.WriteCloser {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+
+	go func() {
+		r, err := NewReader(pr)
+
+		if err == nil {
+			_, err = io.Copy(w, r)
+			r.Close()
+		}
+
+		// Unblocks the writer if decompression stopped early.
+		pr.CloseWithError(err)
+		done <- err
+	}()
+
+	return &decompressWriter{pw: pw, done: done}
+}
+
+type decompressWriter struct {
+	pw     *io.PipeWriter
+	done   chan error
+	closed bool
+	err    error
+}
+
+func (d *decompressWriter) Write(p []byte) (int, error) {
+	return d.pw.Write(p)
+}
+
+// Close is safe to call more than once, later calls return the result of the first.
+func (d *decompressWriter) Close() error {
+	if !d.closed {
+		d.closed = true
+		d.pw.Close()
+		d.err = <-d.done
+	}
+
+	return d.err
+}
@@ -0,0 +1,171 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+var text = bytes.Repeat([]byte("throw stores files on pufs, "), 200)
+
+// The header is part of every stored object, changing it breaks files already uploaded.
+func TestHeader(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		want      string
+	}{
+		{Gzip, "THRWCMP\x01"},
+		{Zstd, "THRWCMP\x02"},
+	}
+
+	for _, test := range tests {
+		data, err := Bytes(text, test.algorithm)
+
+		if err != nil {
+			t.Fatalf("Bytes with %v: %v", test.algorithm, err)
+		}
+
+		if got := string(data[:HeaderSize]); got != test.want {
+			t.Errorf("header of %v = %q, want %q", test.algorithm, got, test.want)
+		}
+
+		if algorithm, err := parseHeader(data); err != nil || algorithm != test.algorithm {
+			t.Errorf("parseHeader of %v = %v, %v", test.algorithm, algorithm, err)
+		}
+	}
+
+	if _, err := header(Auto); err == nil {
+		t.Error("auto has no header of its own, header should fail")
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		algorithm string
+		fails     bool
+	}{
+		{"empty", "", None, false},
+		{"plain text", "hello world", None, false},
+		{"magic only", "THRWCMP", None, false},
+		{"gzip", "THRWCMP\x01rest", Gzip, false},
+		{"zstd", "THRWCMP\x02", Zstd, false},
+		{"unknown algorithm", "THRWCMP\x09", "", true},
+	}
+
+	for _, test := range tests {
+		algorithm, err := parseHeader([]byte(test.data))
+
+		if (err != nil) != test.fails || algorithm != test.algorithm {
+			t.Errorf("%v: parseHeader = %q, %v", test.name, algorithm, err)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	random := make([]byte, 64<<10)
+
+	for i := range random {
+		random[i] = byte(i*2654435761>>13) ^ byte(i)
+	}
+
+	inputs := map[string][]byte{
+		"empty":  {},
+		"text":   text,
+		"binary": random,
+	}
+
+	for _, algorithm := range []string{None, Gzip, Zstd} {
+		for name, input := range inputs {
+			data, err := Bytes(input, algorithm)
+
+			if err != nil {
+				t.Fatalf("%v %v: Bytes: %v", algorithm, name, err)
+			}
+
+			if got, err := Decompress(data); err != nil || !bytes.Equal(got, input) {
+				t.Errorf("%v %v: Decompress did not return the input, err %v", algorithm, name, err)
+			}
+
+			if algorithm == None {
+				continue
+			}
+
+			// Streamed compression always writes the header, even when the result is larger.
+			r, err := Compress(bytes.NewReader(input), algorithm)
+
+			if err != nil {
+				t.Fatalf("%v %v: Compress: %v", algorithm, name, err)
+			}
+
+			streamed, err := io.ReadAll(r)
+
+			if algorithm, _ := parseHeader(streamed); err != nil || algorithm == None {
+				t.Fatalf("%v %v: compressed stream lacks a header, err %v", algorithm, name, err)
+			}
+
+			var out bytes.Buffer
+			w := NewDecompressWriter(&out)
+
+			// Writes smaller than the header must be handled.
+			for _, b := range streamed {
+				if _, err := w.Write([]byte{b}); err != nil {
+					t.Fatalf("%v %v: decompress write: %v", algorithm, name, err)
+				}
+			}
+
+			if err := w.Close(); err != nil || !bytes.Equal(out.Bytes(), input) {
+				t.Errorf("%v %v: NewDecompressWriter did not return the input, err %v", algorithm, name, err)
+			}
+		}
+	}
+}
+
+func TestBytesKeepsIncompressibleData(t *testing.T) {
+	data := []byte("short")
+
+	for _, algorithm := range []string{Gzip, Zstd} {
+		got, err := Bytes(data, algorithm)
+
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%v: Bytes = %q, %v, want the data as is", algorithm, got, err)
+		}
+	}
+}
+
+func TestDecompressWriterPassesPlainData(t *testing.T) {
+	var out bytes.Buffer
+	w := NewDecompressWriter(&out)
+
+	w.Write([]byte("THRW"))
+	w.Write([]byte("not compressed"))
+
+	if err := w.Close(); err != nil || out.String() != "THRWnot compressed" {
+		t.Errorf("got %q, %v", out.String(), err)
+	}
+}
+
+func TestChoose(t *testing.T) {
+	tests := []struct {
+		mode     string
+		fileName string
+		head     []byte
+		want     string
+	}{
+		{None, "notes.txt", text, None},
+		{"", "notes.txt", text, None},
+		{Gzip, "photo.jpg", nil, Gzip},
+		{Zstd, "photo.jpg", nil, Zstd},
+		{Auto, "server.log", nil, Zstd},
+		{Auto, "DATA.CSV", nil, Zstd},
+		{Auto, "photo.jpg", []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"), None},
+		{Auto, "archive.zip", []byte("PK\x03\x04"), None},
+	}
+
+	for _, test := range tests {
+		if got := Choose(test.mode, test.fileName, test.head); got != test.want {
+			t.Errorf("Choose(%q, %q) = %q, want %q", test.mode, test.fileName, got, test.want)
+		}
+	}
+}
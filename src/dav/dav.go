@@ -0,0 +1,406 @@
+// Package dav serves the files stored on pufs over WebDAV, so desktop file managers and applications can mount the store.
+// Reads stream from pufs, writes are spooled to a temporary file and uploaded once the file is closed.
+// Uploads go through the client as they do within the GUI: the upload policy is checked, files are encrypted and new names follow the unique name rules.
+// pufs has no folders or renames. Folders exist through the files within them, empty folders only live as long as the server. Moves download, upload and delete each file.
+package dav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/BitlyTwiser/throw/src/loopback"
+	"github.com/BitlyTwiser/throw/src/pufs_client"
+	"github.com/BitlyTwiser/throw/src/pufsfs"
+	"golang.org/x/net/webdav"
+)
+
+// Serve runs a WebDAV server on address until ctx is cancelled. Only loopback addresses are accepted, requests must carry token unless it is empty.
+func Serve(ctx context.Context, client *pufs_client.IpfsClient, address, token string) error {
+	listener, err := loopback.Listen(address)
+
+	if err != nil {
+		return err
+	}
+
+	handler := &webdav.Handler{
+		FileSystem: NewFileSystem(ctx, client),
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				log.Printf("WebDAV %v %v failed. Error: %v", r.Method, r.URL.Path, err)
+			}
+		},
+	}
+
+	server := &http.Server{Handler: loopback.Guard(listener, token, handler)}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Printf("Serving WebDAV on http://%v", listener.Addr())
+
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// FileSystem implements webdav.FileSystem over the pufs client.
+type FileSystem struct {
+	ctx    context.Context
+	client *pufs_client.IpfsClient
+	files  *pufsfs.FS
+	http   http.FileSystem
+	mutex  sync.Mutex
+	// Folders created over WebDAV that hold no files yet.
+	folders map[string]bool
+	// Stored names of paths deleted by this file system. The file list is updated asynchronously, writing the path again reuses the name as is rather than getting a unique one.
+	deleted map[string]string
+}
+
+// NewFileSystem returns the WebDAV file system of the files held by the server. Uploads started by it end once ctx is cancelled.
+func NewFileSystem(ctx context.Context, client *pufs_client.IpfsClient) *FileSystem {
+	files := pufsfs.New(ctx, client)
+
+	return &FileSystem{
+		ctx:     ctx,
+		client:  client,
+		files:   files,
+		http:    files.HTTP(),
+		folders: make(map[string]bool),
+		deleted: make(map[string]string),
+	}
+}
+
+// WebDAV names are slash rooted, io/fs paths are not.
+func fsPath(name string) string {
+	name = path.Clean("/" + name)
+
+	if name == "/" {
+		return "."
+	}
+
+	return name[1:]
+}
+
+func (d *FileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	return d.stat(fsPath(name))
+}
+
+func (d *FileSystem) stat(name string) (os.FileInfo, error) {
+	info, err := d.files.Stat(name)
+
+	if err == nil {
+		return fileInfo{info}, nil
+	}
+
+	if errors.Is(err, fs.ErrNotExist) && d.isFolder(name) {
+		return folderInfo(name), nil
+	}
+
+	return nil, err
+}
+
+func (d *FileSystem) isFolder(name string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.folders[name]
+}
+
+// Mkdir creates an empty folder. It is kept in memory, pufs only learns of it once a file is written within it.
+func (d *FileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	name = fsPath(name)
+
+	if _, err := d.stat(name); err == nil {
+		return os.ErrExist
+	}
+
+	if err := d.checkParent(name); err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.folders[name] = true
+
+	return nil
+}
+
+func (d *FileSystem) checkParent(name string) error {
+	parent, err := d.stat(path.Dir(name))
+
+	if err != nil {
+		return err
+	}
+
+	if !parent.IsDir() {
+		return fmt.Errorf("%v is not a folder", path.Dir(name))
+	}
+
+	return nil
+}
+
+// OpenFile opens a file for reading, or for writing when flag asks to write, create or truncate.
+func (d *FileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	name = fsPath(name)
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return d.create(name, flag)
+	}
+
+	f, err := d.http.Open("/" + name)
+
+	if err == nil {
+		return &readFile{File: f, fs: d, name: name}, nil
+	}
+
+	if errors.Is(err, fs.ErrNotExist) && d.isFolder(name) {
+		return &folder{fs: d, name: name}, nil
+	}
+
+	return nil, err
+}
+
+// RemoveAll deletes a file, or every file within a folder.
+func (d *FileSystem) RemoveAll(ctx context.Context, name string) error {
+	name = fsPath(name)
+
+	if name == "." {
+		return os.ErrPermission
+	}
+
+	files, err := d.storedBelow(name)
+
+	if err != nil {
+		return err
+	}
+
+	defer d.files.Refresh()
+
+	for rel, stored := range files {
+		if err := d.client.DeleteFile(ctx, stored, false); err != nil {
+			return err
+		}
+
+		d.mutex.Lock()
+		d.deleted[rel] = stored
+		d.mutex.Unlock()
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for folder := range d.folders {
+		if folder == name || strings.HasPrefix(folder, name+"/") {
+			delete(d.folders, folder)
+		}
+	}
+
+	return nil
+}
+
+// Rename moves a file or every file within a folder. pufs cannot rename, each file is downloaded, uploaded under its new name and deleted.
+func (d *FileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	from, to := fsPath(oldName), fsPath(newName)
+
+	if from == "." || to == "." {
+		return os.ErrPermission
+	}
+
+	if to == from || strings.HasPrefix(to, from+"/") {
+		return fmt.Errorf("cannot move %v into itself", from)
+	}
+
+	if err := d.checkParent(to); err != nil {
+		return err
+	}
+
+	files, err := d.storedBelow(from)
+
+	if err != nil {
+		return err
+	}
+
+	defer d.files.Refresh()
+
+	for rel, stored := range files {
+		if err := d.move(ctx, stored, rel, to+strings.TrimPrefix(rel, from)); err != nil {
+			return err
+		}
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for folder := range d.folders {
+		if folder == from || strings.HasPrefix(folder, from+"/") {
+			delete(d.folders, folder)
+			d.folders[to+strings.TrimPrefix(folder, from)] = true
+		}
+	}
+
+	return nil
+}
+
+// Returns the stored name of the file at name, or of every file below the folder at name, keyed by path.
+func (d *FileSystem) storedBelow(name string) (map[string]string, error) {
+	info, err := d.stat(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]string)
+
+	if !info.IsDir() {
+		files[name] = storedName(info)
+
+		return files, nil
+	}
+
+	// Empty folders only exist in memory.
+	if _, err := d.files.Stat(name); errors.Is(err, fs.ErrNotExist) {
+		return files, nil
+	}
+
+	err = fs.WalkDir(d.files, name, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		info, err := entry.Info()
+
+		if err != nil {
+			return err
+		}
+
+		files[p] = storedName(info)
+
+		return nil
+	})
+
+	return files, err
+}
+
+func storedName(info os.FileInfo) string {
+	data, _ := info.Sys().(pufs_client.FileData)
+
+	return data.FileName
+}
+
+func (d *FileSystem) move(ctx context.Context, stored, from, to string) error {
+	log.Printf("Moving %v to %v", from, to)
+
+	r, err := d.client.OpenFile(ctx, stored)
+
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	temp, err := spool(r)
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(temp)
+
+	if err := d.upload(ctx, temp, to); err != nil {
+		return err
+	}
+
+	if err := d.client.DeleteFile(ctx, stored, false); err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.deleted[from] = stored
+
+	return nil
+}
+
+// Copies r into a temporary file, returning its path.
+func spool(r io.Reader) (string, error) {
+	temp, err := os.CreateTemp("", "throw-webdav-*")
+
+	if err != nil {
+		return "", err
+	}
+
+	defer temp.Close()
+
+	if _, err := io.Copy(temp, r); err != nil {
+		os.Remove(temp.Name())
+
+		return "", err
+	}
+
+	return temp.Name(), temp.Close()
+}
+
+// Uploads the file at local to name. pufs cannot replace a file, an existing file is deleted and uploaded again under its stored name.
+// The server deletes the newest file of a name, so the new version cannot be uploaded first. The upload policy is checked before anything is deleted instead.
+// New files get a name the way the GUI names them, encrypted and made unique as the settings ask.
+func (d *FileSystem) upload(ctx context.Context, local, name string) error {
+	defer d.files.Refresh()
+
+	if _, err := d.client.CheckPolicy(ctx, local, name); err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	stored, reuse := d.deleted[name]
+	delete(d.deleted, name)
+	d.mutex.Unlock()
+
+	if info, err := d.files.Stat(name); err == nil {
+		if info.IsDir() {
+			return fmt.Errorf("%v is a folder", name)
+		}
+
+		stored, reuse = storedName(info), true
+
+		if err := d.client.DeleteFile(pufs_client.WithReplace(ctx), stored, false); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if !reuse {
+		return d.client.UploadFile(ctx, local, name)
+	}
+
+	return d.client.UploadFile(pufs_client.WithExactName(ctx), local, stored)
+}
+
+// Describes a stored file. The content type recorded by the client saves the WebDAV handler from downloading the file to sniff it.
+type fileInfo struct {
+	os.FileInfo
+}
+
+func (i fileInfo) ContentType(ctx context.Context) (string, error) {
+	if data, ok := i.Sys().(pufs_client.FileData); ok && data.MimeType != "" {
+		return data.MimeType, nil
+	}
+
+	return "", webdav.ErrNotImplemented
+}
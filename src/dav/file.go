@@ -0,0 +1,210 @@
+package dav
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+var (
+	errReadOnly  = errors.New("the file was opened for reading")
+	errNotFolder = errors.New("not a folder")
+)
+
+// Opens a file for writing. The content is spooled to a temporary file, files opened without truncating start from the stored content.
+func (d *FileSystem) create(name string, flag int) (webdav.File, error) {
+	info, err := d.stat(name)
+	exists := err == nil
+
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	switch {
+	case exists && info.IsDir():
+		return nil, fmt.Errorf("%v is a folder", name)
+	case exists && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, os.ErrExist
+	case !exists && flag&os.O_CREATE == 0:
+		return nil, os.ErrNotExist
+	case !exists:
+		if err := d.checkParent(name); err != nil {
+			return nil, err
+		}
+	}
+
+	temp, err := os.CreateTemp("", "throw-webdav-*")
+
+	if err != nil {
+		return nil, err
+	}
+
+	f := &writeFile{File: temp, fs: d, name: name}
+
+	if exists && flag&os.O_TRUNC == 0 {
+		if err := f.load(storedName(info), flag&os.O_APPEND != 0); err != nil {
+			temp.Close()
+			os.Remove(temp.Name())
+
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+// A file being written, uploaded once closed.
+type writeFile struct {
+	*os.File
+	fs   *FileSystem
+	name string
+}
+
+func (f *writeFile) load(stored string, appending bool) error {
+	r, err := f.fs.client.OpenFile(f.fs.ctx, stored)
+
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	if _, err := io.Copy(f.File, r); err != nil {
+		return err
+	}
+
+	if appending {
+		return nil
+	}
+
+	_, err = f.File.Seek(0, io.SeekStart)
+
+	return err
+}
+
+func (f *writeFile) Readdir(int) ([]os.FileInfo, error) {
+	return nil, errNotFolder
+}
+
+func (f *writeFile) Stat() (os.FileInfo, error) {
+	info, err := f.File.Stat()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return namedInfo{FileInfo: info, name: path.Base(f.name)}, nil
+}
+
+func (f *writeFile) Close() error {
+	defer os.Remove(f.File.Name())
+
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+
+	return f.fs.upload(f.fs.ctx, f.File.Name(), f.name)
+}
+
+// A stored file or folder opened for reading.
+type readFile struct {
+	http.File
+	fs   *FileSystem
+	name string
+}
+
+func (f *readFile) Write([]byte) (int, error) {
+	return 0, errReadOnly
+}
+
+func (f *readFile) Stat() (os.FileInfo, error) {
+	info, err := f.File.Stat()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return fileInfo{info}, nil
+}
+
+// Empty folders are added once every stored entry has been read, WebDAV reads folders whole.
+func (f *readFile) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+
+	if err != nil {
+		return nil, err
+	}
+
+	listed := make(map[string]bool)
+
+	for i, info := range infos {
+		infos[i] = fileInfo{info}
+		listed[info.Name()] = true
+	}
+
+	if count <= 0 {
+		for _, folder := range f.fs.emptyFolders(f.name) {
+			if !listed[folder.Name()] {
+				infos = append(infos, folder)
+			}
+		}
+	}
+
+	return infos, nil
+}
+
+// A folder created over WebDAV that holds no stored files.
+type folder struct {
+	fs   *FileSystem
+	name string
+}
+
+func (f *folder) Close() error                                 { return nil }
+func (f *folder) Read([]byte) (int, error)                     { return 0, errNotFolder }
+func (f *folder) Write([]byte) (int, error)                    { return 0, errReadOnly }
+func (f *folder) Seek(offset int64, whence int) (int64, error) { return 0, nil }
+func (f *folder) Stat() (os.FileInfo, error)                   { return folderInfo(f.name), nil }
+
+func (f *folder) Readdir(count int) ([]os.FileInfo, error) {
+	return f.fs.emptyFolders(f.name), nil
+}
+
+// Returns the empty folders directly within the folder at name.
+func (d *FileSystem) emptyFolders(name string) []os.FileInfo {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var infos []os.FileInfo
+
+	for folder := range d.folders {
+		if path.Dir(folder) == name && folder != name {
+			infos = append(infos, folderInfo(folder))
+		}
+	}
+
+	return infos
+}
+
+type folderInfo string
+
+func (i folderInfo) Name() string       { return path.Base(string(i)) }
+func (i folderInfo) Size() int64        { return 0 }
+func (i folderInfo) Mode() os.FileMode  { return os.ModeDir | 0755 }
+func (i folderInfo) ModTime() time.Time { return time.Time{} }
+func (i folderInfo) IsDir() bool        { return true }
+func (i folderInfo) Sys() interface{}   { return nil }
+
+// Reports the WebDAV name of a temporary file.
+type namedInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (i namedInfo) Name() string { return i.name }
@@ -0,0 +1,212 @@
+// Package envelope implements the self-describing format encrypted files are stored in.
+//
+// An envelope starts with a header naming the format version, cipher, key derivation function, salt and key id.
+// The header is followed by frames, each holding its own length and nonce. Frames do not depend on how the data is chunked in transit,
+// and the final frame is marked so a truncated file is detected. The header and frame position are authenticated with every frame.
+//
+//	header: magic(7) version(1) cipher(1) kdf(1) paramsLen(2) params saltLen(1) salt keyIdLen(1) keyId
+//	frame:  flags(1) length(4) nonce(12) ciphertext(length)
+package envelope
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	Magic   = "THRWENC"
+	Version = 1
+
+	// AES-256 in GCM mode.
+	CipherAES256GCM = 1
+
+	// The key is the SHA-256 of the password. This is the scheme tinycrypt uses.
+	KDFSHA256 = 1
+	// Password based key derivation, the parameters are stored within the header.
+	KDFArgon2id = 2
+	KDFScrypt   = 3
+	// The file key is random and wrapped for each recipient's public key, the wrapped keys are stored in place of the parameters.
+	KDFX25519 = 4
+
+	// Plaintext bytes held within each frame.
+	FrameSize = 256 << 10
+
+	NonceSize = 12
+	TagSize   = 16
+
+	frameHeaderSize = 1 + 4 + NonceSize
+	finalFrame      = 1
+)
+
+var (
+	// Returned by ParseHeader when more data is needed to read the header.
+	ErrShortHeader = errors.New("envelope header is incomplete")
+	ErrTruncated   = errors.New("envelope is truncated, the final frame is missing")
+)
+
+// Header describes how the frames of an envelope are encrypted.
+type Header struct {
+	Version byte
+	Cipher  byte
+	KDF     byte
+	// Parameters of the key derivation function, their layout depends on the KDF.
+	KDFParams []byte
+	Salt      []byte
+	KeyId     string
+}
+
+// KeyFunc returns the key used for the envelope described by a header.
+type KeyFunc func(h *Header) ([]byte, error)
+
+// IsEnvelope reports if data starts with an envelope header.
+func IsEnvelope(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(Magic))
+}
+
+// Marshal encodes the header.
+func (h *Header) Marshal() ([]byte, error) {
+	if len(h.KDFParams) > 0xffff || len(h.Salt) > 0xff || len(h.KeyId) > 0xff {
+		return nil, errors.New("envelope header field is too long")
+	}
+
+	b := []byte(Magic)
+	b = append(b, h.Version, h.Cipher, h.KDF)
+	b = append(b, byte(len(h.KDFParams)>>8), byte(len(h.KDFParams)))
+	b = append(b, h.KDFParams...)
+	b = append(b, byte(len(h.Salt)))
+	b = append(b, h.Salt...)
+	b = append(b, byte(len(h.KeyId)))
+	b = append(b, h.KeyId...)
+
+	return b, nil
+}
+
+// ParseHeader decodes the header at the start of data, returning the header and its encoded size.
+// ErrShortHeader is returned if data ends before the header does.
+func ParseHeader(data []byte) (*Header, int, error) {
+	if len(data) < len(Magic) {
+		return nil, 0, ErrShortHeader
+	}
+
+	if !IsEnvelope(data) {
+		return nil, 0, errors.New("data is not an envelope")
+	}
+
+	r := &reader{data: data, offset: len(Magic)}
+	h := &Header{}
+
+	h.Version = r.byte()
+	h.Cipher = r.byte()
+	h.KDF = r.byte()
+	h.KDFParams = r.bytes(int(r.uint16()))
+	h.Salt = r.bytes(int(r.byte()))
+	h.KeyId = string(r.bytes(int(r.byte())))
+
+	if r.short {
+		return nil, 0, ErrShortHeader
+	}
+
+	if h.Version != Version {
+		return nil, 0, fmt.Errorf("unsupported envelope version: %v", h.Version)
+	}
+
+	if h.Cipher != CipherAES256GCM {
+		return nil, 0, fmt.Errorf("unsupported envelope cipher: %v", h.Cipher)
+	}
+
+	return h, r.offset, nil
+}
+
+// Reads fields from a buffer, recording rather than failing on a short buffer.
+type reader struct {
+	data   []byte
+	offset int
+	short  bool
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.short || r.offset+n > len(r.data) {
+		r.short = true
+
+		return nil
+	}
+
+	b := append([]byte{}, r.data[r.offset:r.offset+n]...)
+	r.offset += n
+
+	return b
+}
+
+func (r *reader) byte() byte {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+
+	return 0
+}
+
+func (r *reader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+
+	return 0
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Binds a frame to the header and to its position, frames cannot be reordered, dropped or moved between files.
+func frameAAD(header []byte, index uint64, flags byte) []byte {
+	aad := make([]byte, len(header)+9)
+	copy(aad, header)
+	binary.BigEndian.PutUint64(aad[len(header):], index)
+	aad[len(aad)-1] = flags
+
+	return aad
+}
+
+// SealBlock encrypts a single block of data, the nonce is prepended to the ciphertext.
+func SealBlock(key, data []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, NonceSize)
+
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// OpenBlock decrypts a block sealed by SealBlock.
+func OpenBlock(key, data []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < NonceSize {
+		return nil, errors.New("encrypted block is too short")
+	}
+
+	return aead.Open(nil, data[:NonceSize], data[NonceSize:], nil)
+}
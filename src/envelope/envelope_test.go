@@ -0,0 +1,271 @@
+package envelope
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+var testKey = bytes.Repeat([]byte{0x42}, 32)
+
+func testHeader() *Header {
+	return &Header{Version: Version, Cipher: CipherAES256GCM, KDF: KDFScrypt, KDFParams: []byte{15, 8, 1}, Salt: []byte{1, 2, 3, 4}, KeyId: "team"}
+}
+
+func keyFunc(h *Header) ([]byte, error) {
+	return testKey, nil
+}
+
+// Envelopes written by every earlier release must stay readable, the layout of the header is fixed.
+func TestHeaderMarshal(t *testing.T) {
+	want := []byte("THRWENC" + "\x01\x01\x03" + "\x00\x03\x0f\x08\x01" + "\x04\x01\x02\x03\x04" + "\x04team")
+
+	got, err := testHeader().Marshal()
+
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("Marshal = %q, %v, want %q", got, err, want)
+	}
+
+	h, n, err := ParseHeader(append(got, "frames"...))
+
+	if err != nil || n != len(want) {
+		t.Fatalf("ParseHeader = %v, %v", n, err)
+	}
+
+	if h.KDF != KDFScrypt || !bytes.Equal(h.KDFParams, []byte{15, 8, 1}) || !bytes.Equal(h.Salt, []byte{1, 2, 3, 4}) || h.KeyId != "team" {
+		t.Errorf("ParseHeader = %+v", h)
+	}
+
+	for i := 0; i < len(want); i++ {
+		if _, _, err := ParseHeader(want[:i]); err != ErrShortHeader {
+			t.Errorf("ParseHeader of the first %v bytes = %v, want ErrShortHeader", i, err)
+		}
+	}
+}
+
+func TestParseHeaderRejects(t *testing.T) {
+	valid, _ := testHeader().Marshal()
+
+	tests := []struct {
+		name   string
+		change func([]byte)
+	}{
+		{"magic", func(b []byte) { b[0] = 'X' }},
+		{"version", func(b []byte) { b[len(Magic)] = 2 }},
+		{"cipher", func(b []byte) { b[len(Magic)+1] = 9 }},
+	}
+
+	for _, test := range tests {
+		data := append([]byte{}, valid...)
+		test.change(data)
+
+		if _, _, err := ParseHeader(data); err == nil || err == ErrShortHeader {
+			t.Errorf("%v: ParseHeader = %v, want an error", test.name, err)
+		}
+	}
+
+	if _, err := (&Header{KeyId: string(make([]byte, 256))}).Marshal(); err == nil {
+		t.Error("Marshal accepted a key id longer than 255 bytes")
+	}
+}
+
+// Splits the frames following the header by their lengths.
+func frames(t *testing.T, data []byte) [][]byte {
+	_, n, err := ParseHeader(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result [][]byte
+
+	for data = data[n:]; len(data) > 0; {
+		length := int(binary.BigEndian.Uint32(data[1:5]))
+		result = append(result, data[:frameHeaderSize+length])
+		data = data[frameHeaderSize+length:]
+	}
+
+	return result
+}
+
+func TestFraming(t *testing.T) {
+	tests := []struct {
+		size   int
+		frames int
+	}{
+		{0, 1},
+		{1, 1},
+		{FrameSize - 1, 1},
+		{FrameSize, 1},
+		{FrameSize + 1, 2},
+		{3 * FrameSize, 3},
+	}
+
+	header, _ := testHeader().Marshal()
+
+	for _, test := range tests {
+		data, err := Seal(bytes.Repeat([]byte{7}, test.size), testHeader(), testKey)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := len(header) + test.frames*(frameHeaderSize+TagSize) + test.size; len(data) != want {
+			t.Errorf("%v bytes sealed into %v bytes, want %v", test.size, len(data), want)
+		}
+
+		got := frames(t, data)
+
+		if len(got) != test.frames {
+			t.Fatalf("%v bytes sealed into %v frames, want %v", test.size, len(got), test.frames)
+		}
+
+		for i, frame := range got {
+			if final := frame[0]&finalFrame != 0; final != (i == len(got)-1) {
+				t.Errorf("%v bytes: frame %v final flag is %v", test.size, i, final)
+			}
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	plain := make([]byte, 2*FrameSize+1000)
+
+	for i := range plain {
+		plain[i] = byte(i % 251)
+	}
+
+	sealed, err := Seal(plain, testHeader(), testKey)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opened, key, err := Open(sealed, keyFunc)
+
+	if err != nil || !bytes.Equal(opened, plain) || !bytes.Equal(key, testKey) {
+		t.Fatalf("Open did not return the plaintext and key, err %v", err)
+	}
+
+	// Transport chunks do not line up with frames.
+	for _, size := range []int{1000, 64<<10 + 3, FrameSize + TagSize + frameHeaderSize, len(sealed)} {
+		decryptPieces(t, sealed, plain, size)
+	}
+
+	// Pieces smaller than the header and frame header are held back until they are complete.
+	small := []byte("a short file")
+	sealedSmall, _ := Seal(small, testHeader(), testKey)
+
+	for _, size := range []int{1, 5, frameHeaderSize} {
+		decryptPieces(t, sealedSmall, small, size)
+	}
+
+	streamed, err := io.ReadAll(Encrypt(bytes.NewReader(plain), testHeader(), testKey))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opened, _, err := Open(streamed, keyFunc); err != nil || !bytes.Equal(opened, plain) {
+		t.Errorf("Open of an Encrypt stream did not return the plaintext, err %v", err)
+	}
+}
+
+func decryptPieces(t *testing.T, sealed, plain []byte, size int) {
+	var out bytes.Buffer
+	d := NewDecryptWriter(&out, keyFunc)
+
+	for rest := sealed; len(rest) > 0; {
+		n := size
+
+		if n > len(rest) {
+			n = len(rest)
+		}
+
+		if _, err := d.Write(rest[:n]); err != nil {
+			t.Fatalf("writing %v byte pieces: %v", size, err)
+		}
+
+		rest = rest[n:]
+	}
+
+	if err := d.Close(); err != nil || !bytes.Equal(out.Bytes(), plain) {
+		t.Errorf("writing %v byte pieces did not return the plaintext, err %v", size, err)
+	}
+}
+
+func TestOpenRejectsTampering(t *testing.T) {
+	sealed, err := Seal(bytes.Repeat([]byte("x"), 2*FrameSize+10), testHeader(), testKey)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, n, _ := ParseHeader(sealed)
+	header := sealed[:n]
+	f := frames(t, sealed)
+	join := func(parts ...[]byte) []byte { return bytes.Join(append([][]byte{header}, parts...), nil) }
+
+	flipped := append([]byte{}, sealed...)
+	flipped[len(flipped)-1] ^= 1
+
+	otherHeader := testHeader()
+	otherHeader.KeyId = "other"
+	moved, _ := otherHeader.Marshal()
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"final frame dropped", join(f[0], f[1]), ErrTruncated},
+		{"cut within a frame", sealed[:len(sealed)-5], ErrTruncated},
+		{"frames reordered", join(f[1], f[0], f[2]), nil},
+		{"frame repeated", join(f[0], f[0], f[1], f[2]), nil},
+		{"bit flipped", flipped, nil},
+		{"data after the final frame", append(append([]byte{}, sealed...), 0), nil},
+		{"frames moved to another header", bytes.Join([][]byte{moved, f[0], f[1], f[2]}, nil), nil},
+		{"header only", header, ErrTruncated},
+	}
+
+	for _, test := range tests {
+		_, _, err := Open(test.data, keyFunc)
+
+		if err == nil || (test.want != nil && !errors.Is(err, test.want)) {
+			t.Errorf("%v: Open = %v, want %v", test.name, err, test.want)
+		}
+	}
+
+	wrongKey := func(*Header) ([]byte, error) { return bytes.Repeat([]byte{1}, 32), nil }
+
+	if _, _, err := Open(sealed, wrongKey); err == nil {
+		t.Error("Open succeeded with the wrong key")
+	}
+}
+
+func TestBlock(t *testing.T) {
+	for _, size := range []int{0, 32, 1000} {
+		data := bytes.Repeat([]byte{9}, size)
+		sealed, err := SealBlock(testKey, data)
+
+		if err != nil || len(sealed) != NonceSize+size+TagSize {
+			t.Fatalf("SealBlock of %v bytes = %v bytes, %v", size, len(sealed), err)
+		}
+
+		if opened, err := OpenBlock(testKey, sealed); err != nil || !bytes.Equal(opened, data) {
+			t.Errorf("OpenBlock of %v bytes = %v", size, err)
+		}
+
+		sealed[NonceSize] ^= 1
+
+		if _, err := OpenBlock(testKey, sealed); err == nil {
+			t.Errorf("OpenBlock accepted a changed block of %v bytes", size)
+		}
+	}
+
+	if _, err := OpenBlock(testKey, []byte{1, 2, 3}); err == nil {
+		t.Error("OpenBlock accepted a block shorter than its nonce")
+	}
+}
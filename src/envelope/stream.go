@@ -0,0 +1,272 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Writer encrypts everything written to it into an envelope. Close must be called to write the final frame.
+type Writer struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	buffer []byte
+	index  uint64
+	closed bool
+}
+
+// NewWriter writes the header to w and returns a writer encrypting into w using key.
+func NewWriter(w io.Writer, h *Header, key []byte) (*Writer, error) {
+	header, err := h.Marshal()
+
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &Writer{w: w, aead: aead, header: header, buffer: make([]byte, 0, FrameSize)}, nil
+}
+
+func (e *Writer) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to closed envelope")
+	}
+
+	n := len(p)
+
+	for len(p) > 0 {
+		space := FrameSize - len(e.buffer)
+
+		if space > len(p) {
+			space = len(p)
+		}
+
+		e.buffer = append(e.buffer, p[:space]...)
+		p = p[space:]
+
+		// A full frame is only written once more data arrives, the last frame must carry the final flag.
+		if len(e.buffer) == FrameSize && len(p) > 0 {
+			if err := e.writeFrame(0); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// Close writes the remaining data as the final frame.
+func (e *Writer) Close() error {
+	if e.closed {
+		return nil
+	}
+
+	e.closed = true
+
+	return e.writeFrame(finalFrame)
+}
+
+func (e *Writer) writeFrame(flags byte) error {
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+len(e.buffer)+TagSize)
+	frame[0] = flags
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(e.buffer)+TagSize))
+	nonce := frame[5:frameHeaderSize]
+
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	frame = e.aead.Seal(frame, nonce, e.buffer, frameAAD(e.header, e.index, flags))
+
+	e.index++
+	e.buffer = e.buffer[:0]
+
+	_, err := e.w.Write(frame)
+
+	return err
+}
+
+// DecryptWriter decrypts an envelope written to it in pieces of any size, writing the plaintext to w.
+// Close must be called once all data is written, it reports envelopes that end early.
+type DecryptWriter struct {
+	w       io.Writer
+	keyFunc KeyFunc
+	aead    cipher.AEAD
+	key     []byte
+	Header  *Header
+	header  []byte
+	pending []byte
+	index   uint64
+	final   bool
+}
+
+func NewDecryptWriter(w io.Writer, keyFunc KeyFunc) *DecryptWriter {
+	return &DecryptWriter{w: w, keyFunc: keyFunc}
+}
+
+// Key returns the key of the envelope, known once the header has been written.
+func (d *DecryptWriter) Key() []byte {
+	return d.key
+}
+
+func (d *DecryptWriter) Write(p []byte) (int, error) {
+	d.pending = append(d.pending, p...)
+
+	if d.aead == nil {
+		h, n, err := ParseHeader(d.pending)
+
+		if err == ErrShortHeader {
+			return len(p), nil
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		key, err := d.keyFunc(h)
+
+		if err != nil {
+			return 0, err
+		}
+
+		aead, err := newAEAD(key)
+
+		if err != nil {
+			return 0, err
+		}
+
+		d.Header, d.key, d.aead = h, key, aead
+		d.header = append([]byte{}, d.pending[:n]...)
+		d.pending = d.pending[n:]
+	}
+
+	for len(d.pending) >= frameHeaderSize {
+		if d.final {
+			return 0, errors.New("data found after the final envelope frame")
+		}
+
+		flags := d.pending[0]
+		length := int(binary.BigEndian.Uint32(d.pending[1:5]))
+
+		if length < TagSize || length > FrameSize+TagSize {
+			return 0, fmt.Errorf("invalid envelope frame length: %v", length)
+		}
+
+		if len(d.pending) < frameHeaderSize+length {
+			break
+		}
+
+		nonce := d.pending[5:frameHeaderSize]
+		ciphertext := d.pending[frameHeaderSize : frameHeaderSize+length]
+
+		plaintext, err := d.aead.Open(nil, nonce, ciphertext, frameAAD(d.header, d.index, flags))
+
+		if err != nil {
+			return 0, fmt.Errorf("could not decrypt envelope frame %v: %v", d.index, err)
+		}
+
+		if _, err := d.w.Write(plaintext); err != nil {
+			return 0, err
+		}
+
+		d.index++
+		d.final = flags&finalFrame != 0
+		d.pending = d.pending[frameHeaderSize+length:]
+	}
+
+	// Keeps the buffer from growing, only a partial frame remains.
+	d.pending = append([]byte{}, d.pending...)
+
+	return len(p), nil
+}
+
+func (d *DecryptWriter) Close() error {
+	if d.aead == nil {
+		return ErrShortHeader
+	}
+
+	if !d.final {
+		return ErrTruncated
+	}
+
+	if len(d.pending) > 0 {
+		return errors.New("data found after the final envelope frame")
+	}
+
+	return nil
+}
+
+// Encrypt returns a reader of the envelope holding the content of r. Encryption runs in a separate goroutine, closing the reader stops it.
+func Encrypt(r io.Reader, h *Header, key []byte) io.ReadCloser {
+	pr, pw := io.Pipe()
+
+	go func() {
+		w, err := NewWriter(pw, h, key)
+
+		if err != nil {
+			pw.CloseWithError(err)
+
+			return
+		}
+
+		if _, err := io.Copy(w, r); err != nil {
+			pw.CloseWithError(err)
+
+			return
+		}
+
+		pw.CloseWithError(w.Close())
+	}()
+
+	return pr
+}
+
+// Seal encrypts data held in memory into an envelope.
+func Seal(data []byte, h *Header, key []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	w, err := NewWriter(&buffer, h, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// Open decrypts an envelope held in memory. The key of the envelope is returned along with the plaintext.
+func Open(data []byte, keyFunc KeyFunc) ([]byte, []byte, error) {
+	var buffer bytes.Buffer
+	d := NewDecryptWriter(&buffer, keyFunc)
+
+	if _, err := d.Write(data); err != nil {
+		return nil, nil, err
+	}
+
+	if err := d.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	return buffer.Bytes(), d.Key(), nil
+}
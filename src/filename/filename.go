@@ -0,0 +1,115 @@
+// Package filename encrypts file names before they are sent to the server.
+// Encryption is deterministic, the same name always encrypts to the same stored name, so every client holding the key finds the same file.
+// A synthetic IV is computed as an HMAC of the name, the name is then encrypted with AES-CTR under that IV. Decryption recomputes the HMAC, so tampered names are detected.
+package filename
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/BitlyTwiser/throw/src/kdf"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Prefix marks a stored name as encrypted.
+const Prefix = "thrw~"
+
+const ivSize = 16
+
+// Every client must derive the same key from the same password. The salt and parameters are fixed, changing them would hide every stored name.
+var (
+	keySalt   = []byte("throw file names")
+	keyParams = kdf.Params{Algorithm: kdf.Argon2id, Time: 1, MemoryKiB: 64 << 10, Threads: 4}
+)
+
+var ErrInvalid = errors.New("encrypted file name is invalid or was encrypted with another key")
+
+type Cipher struct {
+	block  cipher.Block
+	macKey []byte
+}
+
+// NewCipher derives the name keys from password.
+func NewCipher(password string) (*Cipher, error) {
+	if password == "" {
+		return nil, errors.New("file names can only be encrypted once an encryption password is set")
+	}
+
+	master, err := kdf.Derive(password, keySalt, keyParams)
+
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]byte, 64)
+
+	if _, err := io.ReadFull(hkdf.New(sha256.New, master, nil, []byte("throw file name keys")), keys); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(keys[:32])
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cipher{block: block, macKey: keys[32:]}, nil
+}
+
+// IsEncrypted reports if a stored name was encrypted.
+func IsEncrypted(stored string) bool {
+	return strings.HasPrefix(stored, Prefix)
+}
+
+// Placeholder is shown in place of a name that cannot be decrypted.
+func Placeholder(stored string) string {
+	sum := sha256.Sum256([]byte(stored))
+
+	return fmt.Sprintf("Encrypted file (%x)", sum[:4])
+}
+
+// Encrypt returns the stored name of name.
+func (c *Cipher) Encrypt(name string) string {
+	iv := c.iv(name)
+	ciphertext := make([]byte, len(name))
+	cipher.NewCTR(c.block, iv).XORKeyStream(ciphertext, []byte(name))
+
+	return Prefix + base64.RawURLEncoding.EncodeToString(append(iv, ciphertext...))
+}
+
+// Decrypt returns the name a stored name was encrypted from.
+func (c *Cipher) Decrypt(stored string) (string, error) {
+	if !IsEncrypted(stored) {
+		return "", ErrInvalid
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(stored, Prefix))
+
+	if err != nil || len(data) < ivSize {
+		return "", ErrInvalid
+	}
+
+	iv, ciphertext := data[:ivSize], data[ivSize:]
+	name := make([]byte, len(ciphertext))
+	cipher.NewCTR(c.block, iv).XORKeyStream(name, ciphertext)
+
+	if !hmac.Equal(iv, c.iv(string(name))) {
+		return "", ErrInvalid
+	}
+
+	return string(name), nil
+}
+
+func (c *Cipher) iv(name string) []byte {
+	mac := hmac.New(sha256.New, c.macKey)
+	mac.Write([]byte(name))
+
+	return mac.Sum(nil)[:ivSize]
+}
@@ -0,0 +1,100 @@
+package filename
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func newCipher(t *testing.T, password string) *Cipher {
+	c, err := NewCipher(password)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+// Every client must find names stored by earlier releases, the key derivation and layout are fixed.
+func TestEncryptKnownAnswer(t *testing.T) {
+	const want = "thrw~5f8lJo_Ju2kFCr2Yt2eNG8N4wApheKE9-q2BPkN7FsY"
+
+	if got := newCipher(t, "correct horse").Encrypt("notes/report.pdf"); got != want {
+		t.Errorf("Encrypt = %v, want %v", got, want)
+	}
+
+	if got := Placeholder("thrw~abc"); got != "Encrypted file (74f31888)" {
+		t.Errorf("Placeholder = %v", got)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	c := newCipher(t, "correct horse")
+	other := newCipher(t, "battery staple")
+
+	names := []string{"", "a", "notes/report.pdf", "naïve café.txt", strings.Repeat("long name ", 50)}
+
+	for _, name := range names {
+		stored := c.Encrypt(name)
+
+		if !IsEncrypted(stored) {
+			t.Errorf("%q encrypted to %v, which lacks the prefix", name, stored)
+		}
+
+		if again := c.Encrypt(name); again != stored {
+			t.Errorf("%q encrypted to %v and then %v", name, stored, again)
+		}
+
+		if got, err := c.Decrypt(stored); err != nil || got != name {
+			t.Errorf("Decrypt of %v = %q, %v, want %q", stored, got, err, name)
+		}
+
+		if other.Encrypt(name) == stored {
+			t.Errorf("%q encrypted to the same name under two passwords", name)
+		}
+
+		if _, err := other.Decrypt(stored); err != ErrInvalid {
+			t.Errorf("Decrypt of %v with another password = %v, want ErrInvalid", stored, err)
+		}
+	}
+
+	if c.Encrypt("a.txt") == c.Encrypt("b.txt") {
+		t.Error("two names encrypted to the same stored name")
+	}
+}
+
+func TestDecryptRejects(t *testing.T) {
+	c := newCipher(t, "correct horse")
+	stored := c.Encrypt("notes/report.pdf")
+	data, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(stored, Prefix))
+
+	flip := func(i int) string {
+		changed := append([]byte{}, data...)
+		changed[i] ^= 1
+
+		return Prefix + base64.RawURLEncoding.EncodeToString(changed)
+	}
+
+	tests := []struct {
+		name   string
+		stored string
+	}{
+		{"plain name", "notes/report.pdf"},
+		{"not base64", Prefix + "!!!"},
+		{"shorter than the iv", Prefix + base64.RawURLEncoding.EncodeToString(data[:ivSize-1])},
+		{"iv changed", flip(0)},
+		{"name changed", flip(len(data) - 1)},
+		{"name cut", Prefix + base64.RawURLEncoding.EncodeToString(data[:len(data)-1])},
+	}
+
+	for _, test := range tests {
+		if got, err := c.Decrypt(test.stored); err != ErrInvalid {
+			t.Errorf("%v: Decrypt = %q, %v, want ErrInvalid", test.name, got, err)
+		}
+	}
+
+	if _, err := NewCipher(""); err == nil {
+		t.Error("NewCipher accepted an empty password")
+	}
+}
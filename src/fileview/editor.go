@@ -1,6 +1,7 @@
-package pufs_client
+package fileview
 
 import (
+	"context"
 	"fmt"
 	"os"
 
@@ -9,10 +10,17 @@ import (
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 	"github.com/BitlyTwiser/throw/src/notifications"
+	"github.com/BitlyTwiser/throw/src/pufs_client"
+	"github.com/BitlyTwiser/throw/src/sniff"
 )
 
 // By the nature of the IPFS system, IPFS hashes are immutable. Thus, in order for us to peoperly "update" a file, we must first delete the file then re-add the file.
-func FileEditor(data []byte, client IpfsClient, fileName string, w fyne.Window) *fyne.Container {
+// fileName is the stored name, the file is saved locally and uploaded again under its decrypted name.
+func FileEditor(ctx context.Context, data []byte, client *pufs_client.IpfsClient, fileName string, w fyne.Window) *fyne.Container {
+	localName := client.LocalFileName(fileName)
+
+	// The editor works on UTF-8, the text is written back in the charset it was read in.
+	charset := sniff.Charset(client.MimeType(fileName))
 
 	fileEditor := widget.NewMultiLineEntry()
 	fileEditor.Wrapping = 1
@@ -22,7 +30,7 @@ func FileEditor(data []byte, client IpfsClient, fileName string, w fyne.Window)
 	toolbar := widget.NewToolbar(
 		widget.NewToolbarAction(theme.DocumentSaveIcon(), func() {
 			//Delete file
-			err := client.DeleteFile(fileName, false)
+			err := client.DeleteFile(pufs_client.WithReplace(ctx), fileName, false)
 
 			if err != nil {
 				notifications.SendErrorNotification(fmt.Sprintf("Error saving file. Error: %v", err))
@@ -31,9 +39,9 @@ func FileEditor(data []byte, client IpfsClient, fileName string, w fyne.Window)
 			}
 			//Re-Add newfound data
 			err = saveFile(
-				fileEditor.Text,
+				string(sniff.FromUTF8([]byte(fileEditor.Text), charset)),
 				client.Settings.DownloadPath,
-				fileName)
+				localName)
 
 			if err != nil {
 				notifications.SendErrorNotification(fmt.Sprintf("File data failed to save. Error: %v", err.Error()))
@@ -41,7 +49,7 @@ func FileEditor(data []byte, client IpfsClient, fileName string, w fyne.Window)
 				notifications.SendSuccessNotification("File data saved")
 			}
 
-			client.UploadFile(fmt.Sprintf("%v/%v", client.Settings.DownloadPath, fileName), fileName)
+			client.UploadFile(ctx, fmt.Sprintf("%v/%v", client.Settings.DownloadPath, localName), client.DisplayName(fileName))
 		}),
 		widget.NewToolbarSeparator(),
 		widget.NewToolbarAction(theme.CancelIcon(), func() {
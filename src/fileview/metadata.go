@@ -0,0 +1,112 @@
+// Package fileview holds the windows and icons the GUI shows for stored files.
+package fileview
+
+import (
+	"fmt"
+	"strconv"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/BitlyTwiser/throw/src/pufs_client"
+	"github.com/BitlyTwiser/throw/src/sniff"
+)
+
+// Picks the file list icon of a content type.
+func FileIcon(mimeType string) fyne.Resource {
+	switch sniff.Category(mimeType) {
+	case "text":
+		return theme.FileTextIcon()
+	case "image":
+		return theme.FileImageIcon()
+	case "audio":
+		return theme.FileAudioIcon()
+	case "video":
+		return theme.FileVideoIcon()
+	case "application":
+		return theme.FileApplicationIcon()
+	}
+
+	return theme.FileIcon()
+}
+
+// Create fyne table, insert file data within.
+// The display name is the decrypted name of files stored under an encrypted name.
+func FileMetadata(fileData pufs_client.FileData, displayName string) {
+	w := fyne.CurrentApp().NewWindow("File Metadata")
+	w.Resize(fyne.NewSize(400, 200))
+
+	fileSize := strconv.Itoa(int(fileData.FileSize))
+
+	fileNameLabel := widget.NewLabel(fmt.Sprintf("File Name: %v\n--------------", displayName))
+	fileNameLabel.Wrapping = 1
+
+	fileSizeLabel := widget.NewLabel(fmt.Sprintf("File Size: %vMB\n------------------------", fileSize))
+	fileSizeLabel.Wrapping = 1
+
+	fileUploadedAt := widget.NewLabel(fmt.Sprintf("Uploaded at: %v\n------------", fileData.UploadedAt))
+	fileUploadedAt.Wrapping = 1
+
+	hash := fileData.IpfsHash
+	if fileData.IpfsHashVerified {
+		hash = fmt.Sprintf("%v (verified locally)", hash)
+	}
+
+	ipfsHash := widget.NewLabel(fmt.Sprintf("Ipfs Hash: %v\n--------------------", hash))
+	ipfsHash.Wrapping = 1
+
+	checksum := fileData.Checksum
+	if checksum == "" {
+		checksum = "Not verified yet, download the file to verify"
+	}
+
+	checksumLabel := widget.NewLabel(fmt.Sprintf("SHA-256: %v\n--------------------", checksum))
+	checksumLabel.Wrapping = 1
+
+	table := container.NewGridWithRows(
+		3,
+		fileNameLabel,
+		fileSizeLabel,
+		fileUploadedAt,
+		ipfsHash,
+		checksumLabel,
+	)
+
+	if displayName != fileData.FileName {
+		storedLabel := widget.NewLabel(fmt.Sprintf("Stored as: %v\n--------------------", fileData.FileName))
+		storedLabel.Wrapping = 1
+		table.Add(storedLabel)
+	}
+
+	if fileData.LinkTarget != "" {
+		linkLabel := widget.NewLabel(fmt.Sprintf("Duplicate of: %v\n--------------------", fileData.LinkTarget))
+		linkLabel.Wrapping = 1
+		table.Add(linkLabel)
+	}
+
+	if fileData.MimeType != "" {
+		typeLabel := widget.NewLabel(fmt.Sprintf("Type: %v\n--------------------", fileData.MimeType))
+		typeLabel.Wrapping = 1
+		table.Add(typeLabel)
+	}
+
+	if fileData.Encryption != pufs_client.EncryptionNone {
+		encryption := fileData.Encryption
+		if encryption == pufs_client.EncryptionEnvelope {
+			keyId := fileData.KeyId
+			if keyId == "" {
+				keyId = "default"
+			}
+
+			encryption = fmt.Sprintf("%v (key %v)", encryption, keyId)
+		}
+
+		encryptionLabel := widget.NewLabel(fmt.Sprintf("Encryption: %v\n--------------------", encryption))
+		encryptionLabel.Wrapping = 1
+		table.Add(encryptionLabel)
+	}
+
+	w.SetContent(table)
+	w.Show()
+}
@@ -0,0 +1,207 @@
+// Package gateway serves the files stored on pufs over HTTP on localhost, so media players and browsers can stream them.
+// Files are served under /files/{name} by their display name. Content is decrypted as it is sent, Range requests are supported.
+// pufs always streams a file from its start, a range further into a file is reached by downloading and discarding what comes before it.
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/BitlyTwiser/throw/src/loopback"
+	"github.com/BitlyTwiser/throw/src/pufs_client"
+	"github.com/BitlyTwiser/throw/src/pufsfs"
+	"github.com/BitlyTwiser/throw/src/sniff"
+)
+
+const filesPrefix = "/files/"
+
+// Serve runs the gateway on address until ctx is cancelled. Only loopback addresses are accepted, requests must carry token unless it is empty.
+func Serve(ctx context.Context, client *pufs_client.IpfsClient, address, token string) error {
+	listener, err := loopback.Listen(address)
+
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Handler: loopback.Guard(listener, token, NewHandler(ctx, client))}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Printf("Serving files on http://%v%v", listener.Addr(), filesPrefix)
+
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// Handler serves GET and HEAD requests for /files/{name}.
+type Handler struct {
+	files http.FileSystem
+	mutex sync.Mutex
+	// Content types sniffed from files the client has no content type for, keyed by stored name and IPFS hash.
+	types map[string]string
+}
+
+// NewHandler returns the gateway handler. Downloads started by it end once ctx is cancelled.
+func NewHandler(ctx context.Context, client *pufs_client.IpfsClient) *Handler {
+	return &Handler{files: pufsfs.New(ctx, client).HTTP(), types: make(map[string]string)}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, filesPrefix)
+
+	if !strings.HasPrefix(r.URL.Path, filesPrefix) || !fs.ValidPath(name) || name == "." {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	f, err := h.files.Open("/" + name)
+
+	if err != nil {
+		h.error(w, r, err)
+
+		return
+	}
+
+	defer f.Close()
+
+	info, err := f.Stat()
+
+	if err != nil {
+		h.error(w, r, err)
+
+		return
+	}
+
+	data, ok := info.Sys().(pufs_client.FileData)
+
+	if info.IsDir() || !ok {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	contentType, err := h.contentType(info.Name(), data, f)
+
+	if err != nil {
+		h.error(w, r, err)
+
+		return
+	}
+
+	// The IPFS hash changes with the stored content, so it is a strong validator. ServeContent answers If-None-Match and If-Range with it.
+	if data.IpfsHash != "" {
+		w.Header().Set("ETag", fmt.Sprintf("%q", data.IpfsHash))
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	// Stored pages and images with script would run on the origin of the gateway, they are downloaded rather than shown.
+	if active(contentType) {
+		disposition := mime.FormatMediaType("attachment", map[string]string{"filename": info.Name()})
+
+		if disposition == "" {
+			disposition = "attachment"
+		}
+
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		w.Header().Set("Content-Disposition", disposition)
+	}
+
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// Returns the content type recorded by the client, sniffing the start of the file when there is none.
+func (h *Handler) contentType(name string, data pufs_client.FileData, f http.File) (string, error) {
+	if data.MimeType != "" {
+		return data.MimeType, nil
+	}
+
+	key := data.FileName + "/" + data.IpfsHash
+
+	h.mutex.Lock()
+	contentType, ok := h.types[key]
+	h.mutex.Unlock()
+
+	if ok {
+		return contentType, nil
+	}
+
+	head := make([]byte, sniff.HeadSize)
+	n, err := io.ReadFull(f, head)
+
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	contentType = sniff.Detect(name, head[:n])
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.types[key] = contentType
+
+	return contentType, nil
+}
+
+// Content types a browser runs script within.
+var activeTypes = map[string]bool{
+	"text/html":              true,
+	"application/xhtml+xml":  true,
+	"image/svg+xml":          true,
+	"text/xml":               true,
+	"application/xml":        true,
+	"text/javascript":        true,
+	"application/javascript": true,
+	"application/ecmascript": true,
+	"text/xsl":               true,
+	"application/xslt+xml":   true,
+}
+
+// Reports if a content type can run script when shown by a browser.
+func active(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+
+	if err != nil {
+		return true
+	}
+
+	return activeTypes[mediaType] || strings.HasSuffix(mediaType, "+xml")
+}
+
+func (h *Handler) error(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, fs.ErrNotExist) {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	log.Printf("Error serving %v. Error: %v", r.URL.Path, err)
+	http.Error(w, "error reading the file from pufs", http.StatusBadGateway)
+}
@@ -0,0 +1,254 @@
+// Package kdf derives file keys from a password using Argon2id or scrypt.
+// The parameters are stored alongside each file, so the cost can be raised later without breaking files encrypted earlier.
+package kdf
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+
+	"github.com/BitlyTwiser/throw/src/envelope"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	Argon2id = "argon2id"
+	Scrypt   = "scrypt"
+
+	KeySize  = 32
+	SaltSize = 16
+)
+
+// Upper bounds for parameters read from a file. A crafted header must not be able to exhaust memory or stall the client.
+const (
+	maxArgon2Time      = 64
+	maxArgon2MemoryKiB = 1 << 20
+	maxArgon2Threads   = 16
+	maxScryptLogN      = 24
+	maxScryptR         = 32
+	maxScryptP         = 16
+	// Scrypt uses 128 * r * N bytes.
+	maxScryptMemory = 1 << 30
+)
+
+// Params holds the algorithm and its cost. Argon2id uses Time, MemoryKiB and Threads. Scrypt uses LogN, R and P.
+type Params struct {
+	Algorithm string
+	Time      uint32
+	MemoryKiB uint32
+	Threads   uint8
+	LogN      uint8
+	R         uint32
+	P         uint32
+}
+
+// Default returns the parameters recommended for the algorithm. Argon2id is used for an unknown algorithm.
+func Default(algorithm string) Params {
+	if algorithm == Scrypt {
+		return Params{Algorithm: Scrypt, LogN: 15, R: 8, P: 1}
+	}
+
+	return Params{Algorithm: Argon2id, Time: 1, MemoryKiB: 64 << 10, Threads: 4}
+}
+
+// OrDefault fills in the default parameters when none have been chosen.
+func (p Params) OrDefault() Params {
+	if p.Validate() != nil {
+		return Default(p.Algorithm)
+	}
+
+	return p
+}
+
+func (p Params) String() string {
+	if p.Algorithm == Scrypt {
+		return fmt.Sprintf("scrypt N=2^%v r=%v p=%v", p.LogN, p.R, p.P)
+	}
+
+	return fmt.Sprintf("argon2id t=%v m=%vMiB p=%v", p.Time, p.MemoryKiB>>10, p.Threads)
+}
+
+// Validate reports parameters that are missing or out of bounds.
+func (p Params) Validate() error {
+	switch p.Algorithm {
+	case Argon2id:
+		if p.Time == 0 || p.Time > maxArgon2Time || p.MemoryKiB < 8*uint32(p.Threads) || p.MemoryKiB > maxArgon2MemoryKiB || p.Threads == 0 || p.Threads > maxArgon2Threads {
+			return fmt.Errorf("invalid argon2id parameters: %v", p)
+		}
+	case Scrypt:
+		if p.LogN < 10 || p.LogN > maxScryptLogN || p.R == 0 || p.R > maxScryptR || p.P == 0 || p.P > maxScryptP || scryptMemory(p.LogN, p.R) > maxScryptMemory {
+			return fmt.Errorf("invalid scrypt parameters: %v", p)
+		}
+	default:
+		return fmt.Errorf("unknown key derivation function: %v", p.Algorithm)
+	}
+
+	return nil
+}
+
+func scryptMemory(logN uint8, r uint32) uint64 {
+	return 128 * uint64(r) << logN
+}
+
+// Id returns the identifier of the algorithm within an envelope header.
+func (p Params) Id() byte {
+	if p.Algorithm == Scrypt {
+		return envelope.KDFScrypt
+	}
+
+	return envelope.KDFArgon2id
+}
+
+// Marshal encodes the parameters for an envelope header.
+func (p Params) Marshal() []byte {
+	if p.Algorithm == Scrypt {
+		b := make([]byte, 9)
+		b[0] = p.LogN
+		binary.BigEndian.PutUint32(b[1:5], p.R)
+		binary.BigEndian.PutUint32(b[5:9], p.P)
+
+		return b
+	}
+
+	b := make([]byte, 9)
+	binary.BigEndian.PutUint32(b[0:4], p.Time)
+	binary.BigEndian.PutUint32(b[4:8], p.MemoryKiB)
+	b[8] = p.Threads
+
+	return b
+}
+
+// Unmarshal decodes the parameters stored within an envelope header.
+func Unmarshal(id byte, data []byte) (Params, error) {
+	var p Params
+
+	if len(data) != 9 {
+		return p, errors.New("invalid key derivation parameters")
+	}
+
+	switch id {
+	case envelope.KDFArgon2id:
+		p = Params{
+			Algorithm: Argon2id,
+			Time:      binary.BigEndian.Uint32(data[0:4]),
+			MemoryKiB: binary.BigEndian.Uint32(data[4:8]),
+			Threads:   data[8],
+		}
+	case envelope.KDFScrypt:
+		p = Params{
+			Algorithm: Scrypt,
+			LogN:      data[0],
+			R:         binary.BigEndian.Uint32(data[1:5]),
+			P:         binary.BigEndian.Uint32(data[5:9]),
+		}
+	default:
+		return p, fmt.Errorf("unknown key derivation function: %v", id)
+	}
+
+	return p, p.Validate()
+}
+
+// NewSalt returns a random salt, a fresh salt is used for every file.
+func NewSalt() ([]byte, error) {
+	salt := make([]byte, SaltSize)
+
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	return salt, nil
+}
+
+// Derive returns the key for password and salt.
+func Derive(password string, salt []byte, p Params) ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	if p.Algorithm == Scrypt {
+		return scrypt.Key([]byte(password), salt, 1<<p.LogN, int(p.R), int(p.P), KeySize)
+	}
+
+	return argon2.IDKey([]byte(password), salt, p.Time, p.MemoryKiB, p.Threads, KeySize), nil
+}
+
+// Benchmark picks the strongest parameters that derive a key within target on this machine.
+func Benchmark(algorithm string, target time.Duration) (Params, error) {
+	salt, err := NewSalt()
+
+	if err != nil {
+		return Params{}, err
+	}
+
+	measure := func(p Params) (time.Duration, error) {
+		start := time.Now()
+		_, err := Derive("benchmark", salt, p)
+
+		return time.Since(start), err
+	}
+
+	p := Default(algorithm)
+
+	if p.Algorithm == Scrypt {
+		// Each step doubles the cost. Stop before the step that would overshoot the target or the memory bound.
+		for p.LogN < maxScryptLogN && scryptMemory(p.LogN+1, p.R) <= maxScryptMemory {
+			elapsed, err := measure(p)
+
+			if err != nil {
+				return p, err
+			}
+
+			if elapsed*2 > target {
+				break
+			}
+
+			p.LogN++
+		}
+
+		return p, nil
+	}
+
+	threads := runtime.NumCPU()
+
+	if threads > 4 {
+		threads = 4
+	}
+
+	p.Threads = uint8(threads)
+
+	// Memory is the main cost of argon2id. Shrink it on slow machines, then add passes while there is time left.
+	for p.MemoryKiB > 16<<10 {
+		elapsed, err := measure(p)
+
+		if err != nil {
+			return p, err
+		}
+
+		if elapsed <= target {
+			break
+		}
+
+		p.MemoryKiB /= 2
+	}
+
+	for p.Time < maxArgon2Time {
+		elapsed, err := measure(p)
+
+		if err != nil {
+			return p, err
+		}
+
+		if elapsed*time.Duration(p.Time+1)/time.Duration(p.Time) > target {
+			break
+		}
+
+		p.Time++
+	}
+
+	return p, nil
+}
@@ -0,0 +1,193 @@
+package kdf
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/BitlyTwiser/throw/src/envelope"
+)
+
+// Vectors from the argon2 reference implementation and RFC 7914, cut to KeySize.
+func TestDeriveKnownAnswers(t *testing.T) {
+	tests := []struct {
+		password string
+		salt     string
+		params   Params
+		want     string
+	}{
+		{"password", "somesalt", Params{Algorithm: Argon2id, Time: 2, MemoryKiB: 64 << 10, Threads: 1}, "09316115d5cf24ed5a15a31a3ba326e5cf32edc24702987c02b6566f61913cf7"},
+		{"password", "NaCl", Params{Algorithm: Scrypt, LogN: 10, R: 8, P: 16}, "fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b373162"},
+	}
+
+	for _, test := range tests {
+		key, err := Derive(test.password, []byte(test.salt), test.params)
+
+		if err != nil {
+			t.Fatalf("%v: %v", test.params, err)
+		}
+
+		if got := hex.EncodeToString(key); got != test.want {
+			t.Errorf("%v = %v, want %v", test.params, got, test.want)
+		}
+	}
+}
+
+// The parameters are stored within envelope headers, their encoding is fixed.
+func TestMarshal(t *testing.T) {
+	tests := []struct {
+		params Params
+		id     byte
+		want   []byte
+	}{
+		{Params{Algorithm: Argon2id, Time: 3, MemoryKiB: 64 << 10, Threads: 4}, envelope.KDFArgon2id, []byte{0, 0, 0, 3, 0, 1, 0, 0, 4}},
+		{Params{Algorithm: Scrypt, LogN: 15, R: 8, P: 1}, envelope.KDFScrypt, []byte{15, 0, 0, 0, 8, 0, 0, 0, 1}},
+	}
+
+	for _, test := range tests {
+		if got := test.params.Marshal(); !bytes.Equal(got, test.want) {
+			t.Errorf("Marshal of %v = %v, want %v", test.params, got, test.want)
+		}
+
+		if id := test.params.Id(); id != test.id {
+			t.Errorf("Id of %v = %v, want %v", test.params, id, test.id)
+		}
+
+		p, err := Unmarshal(test.id, test.want)
+
+		if err != nil || p != test.params {
+			t.Errorf("Unmarshal of %v = %v, %v", test.params, p, err)
+		}
+	}
+}
+
+func TestUnmarshalRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		id   byte
+		data []byte
+	}{
+		{"short", envelope.KDFScrypt, []byte{15, 0, 0, 0, 8}},
+		{"unknown id", envelope.KDFSHA256, make([]byte, 9)},
+		{"argon2id memory beyond the bound", envelope.KDFArgon2id, []byte{0, 0, 0, 1, 0xff, 0xff, 0xff, 0xff, 1}},
+		{"argon2id without passes", envelope.KDFArgon2id, []byte{0, 0, 0, 0, 0, 1, 0, 0, 1}},
+		{"scrypt cost beyond the bound", envelope.KDFScrypt, []byte{40, 0, 0, 0, 8, 0, 0, 0, 1}},
+		{"scrypt without parallelism", envelope.KDFScrypt, []byte{15, 0, 0, 0, 8, 0, 0, 0, 0}},
+	}
+
+	for _, test := range tests {
+		if _, err := Unmarshal(test.id, test.data); err == nil {
+			t.Errorf("%v: Unmarshal accepted %v", test.name, test.data)
+		}
+	}
+}
+
+// Parameters come from the header of any stored file, crafted ones must be refused before any memory is allocated for them.
+func TestCraftedHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		params Params
+	}{
+		{"scrypt block size beyond the bound", Params{Algorithm: Scrypt, LogN: 24, R: 1 << 20, P: 1}},
+		{"scrypt parallelism beyond the bound", Params{Algorithm: Scrypt, LogN: 10, R: 8, P: 1 << 20}},
+		{"scrypt memory beyond the bound", Params{Algorithm: Scrypt, LogN: 24, R: 1, P: 1}},
+		{"scrypt memory beyond the bound at the largest block size", Params{Algorithm: Scrypt, LogN: 19, R: maxScryptR, P: 1}},
+		{"argon2id memory beyond the bound", Params{Algorithm: Argon2id, Time: 1, MemoryKiB: 4 << 20, Threads: 1}},
+		{"argon2id threads beyond the bound", Params{Algorithm: Argon2id, Time: 1, MemoryKiB: 64 << 10, Threads: 255}},
+	}
+
+	for _, test := range tests {
+		h := &envelope.Header{Version: envelope.Version, Cipher: envelope.CipherAES256GCM, KDF: test.params.Id(), KDFParams: test.params.Marshal(), Salt: make([]byte, SaltSize)}
+		data, err := h.Marshal()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parsed, _, err := envelope.ParseHeader(data)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := Unmarshal(parsed.KDF, parsed.KDFParams); err == nil {
+			t.Errorf("%v: Unmarshal accepted %v", test.name, test.params)
+		}
+
+		if _, err := Derive("password", parsed.Salt, test.params); err == nil {
+			t.Errorf("%v: Derive accepted %v", test.name, test.params)
+		}
+	}
+
+	// The largest parameters accepted stay within the memory bound.
+	largest := []Params{
+		{Algorithm: Scrypt, LogN: 18, R: maxScryptR, P: maxScryptP},
+		{Algorithm: Scrypt, LogN: 23, R: 1, P: 1},
+		{Algorithm: Argon2id, Time: maxArgon2Time, MemoryKiB: maxArgon2MemoryKiB, Threads: maxArgon2Threads},
+	}
+
+	for _, p := range largest {
+		if err := p.Validate(); err != nil {
+			t.Errorf("Validate of %v = %v", p, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		params Params
+		valid  bool
+	}{
+		{Default(Argon2id), true},
+		{Default(Scrypt), true},
+		{Params{Algorithm: Argon2id, Time: 1, MemoryKiB: 8, Threads: 1}, true},
+		{Params{Algorithm: Argon2id, Time: 1, MemoryKiB: 8, Threads: 4}, false},
+		{Params{Algorithm: Argon2id, Time: 65, MemoryKiB: 64 << 10, Threads: 1}, false},
+		{Params{Algorithm: Scrypt, LogN: 9, R: 8, P: 1}, false},
+		{Params{Algorithm: Scrypt, LogN: 10, R: 1 << 15, P: 1 << 15}, false},
+		{Params{}, false},
+		{Params{Algorithm: "pbkdf2"}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.params.Validate(); (err == nil) != test.valid {
+			t.Errorf("Validate of %+v = %v", test.params, err)
+		}
+	}
+
+	if got := (Params{}).OrDefault(); got != Default(Argon2id) {
+		t.Errorf("OrDefault of no parameters = %v", got)
+	}
+
+	if got := (Params{Algorithm: Scrypt}).OrDefault(); got != Default(Scrypt) {
+		t.Errorf("OrDefault of scrypt without a cost = %v", got)
+	}
+}
+
+func TestDeriveSalt(t *testing.T) {
+	p := Params{Algorithm: Scrypt, LogN: 10, R: 8, P: 1}
+
+	a, err := NewSalt()
+
+	if err != nil || len(a) != SaltSize {
+		t.Fatalf("NewSalt = %v, %v", a, err)
+	}
+
+	b, _ := NewSalt()
+
+	if bytes.Equal(a, b) {
+		t.Fatal("NewSalt returned the same salt twice")
+	}
+
+	keyA, _ := Derive("password", a, p)
+	keyAgain, _ := Derive("password", a, p)
+	keyB, _ := Derive("password", b, p)
+
+	if len(keyA) != KeySize || !bytes.Equal(keyA, keyAgain) || bytes.Equal(keyA, keyB) {
+		t.Error("keys must depend on the salt and nothing else")
+	}
+
+	if _, err := Derive("password", a, Params{Algorithm: Scrypt}); err == nil {
+		t.Error("Derive accepted invalid parameters")
+	}
+}
@@ -0,0 +1,127 @@
+// Package keyring holds the named keys files can be encrypted with.
+// Every encrypted file records the id of its key, so files encrypted under an older key stay readable while that key is kept.
+package keyring
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Id of the key held within Settings.Password. Files encrypted before the keyring existed use it.
+const DefaultKeyId = ""
+
+type Key struct {
+	Id        string
+	Name      string
+	Password  string
+	CreatedAt string
+}
+
+type Keyring struct {
+	Keys []Key
+	// Id of the key new files are encrypted with. Empty uses the default key.
+	Active string
+}
+
+// NewKey creates a key with a random id.
+func NewKey(name, password string) (Key, error) {
+	if name == "" || password == "" {
+		return Key{}, errors.New("a key needs a name and a password")
+	}
+
+	id := make([]byte, 8)
+
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return Key{}, err
+	}
+
+	return Key{
+		Id:        hex.EncodeToString(id),
+		Name:      name,
+		Password:  password,
+		CreatedAt: time.Now().Format(time.UnixDate),
+	}, nil
+}
+
+// Add appends a key, names must be unique.
+func (k *Keyring) Add(key Key) error {
+	if k.FindByName(key.Name) != nil {
+		return fmt.Errorf("a key named %v already exists", key.Name)
+	}
+
+	k.Keys = append(k.Keys, key)
+
+	return nil
+}
+
+// Remove drops a key. The default key is used for new files if the removed key was active.
+func (k *Keyring) Remove(id string) {
+	var keys []Key
+
+	for _, key := range k.Keys {
+		if key.Id != id {
+			keys = append(keys, key)
+		}
+	}
+
+	k.Keys = keys
+
+	if k.Active == id {
+		k.Active = DefaultKeyId
+	}
+}
+
+func (k *Keyring) Find(id string) *Key {
+	for i := range k.Keys {
+		if k.Keys[i].Id == id {
+			return &k.Keys[i]
+		}
+	}
+
+	return nil
+}
+
+func (k *Keyring) FindByName(name string) *Key {
+	for i := range k.Keys {
+		if k.Keys[i].Name == name {
+			return &k.Keys[i]
+		}
+	}
+
+	return nil
+}
+
+// SetActive selects the key new files are encrypted with.
+func (k *Keyring) SetActive(id string) error {
+	if id != DefaultKeyId && k.Find(id) == nil {
+		return fmt.Errorf("no key found with id: %v", id)
+	}
+
+	k.Active = id
+
+	return nil
+}
+
+// Name returns a readable name for a key id.
+func (k *Keyring) Name(id string) string {
+	if id == DefaultKeyId {
+		return "Default"
+	}
+
+	if key := k.Find(id); key != nil {
+		return key.Name
+	}
+
+	return fmt.Sprintf("Unknown key (%v)", id)
+}
+
+// Copy returns a keyring that shares no memory with k.
+func (k Keyring) Copy() Keyring {
+	k.Keys = append([]Key{}, k.Keys...)
+
+	return k
+}
@@ -0,0 +1,75 @@
+// Package loopback listens for the local servers of throw. Only loopback addresses are accepted, and only requests naming them are served.
+// Any web page can send requests to localhost, a token can be required on top of that.
+package loopback
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Listen listens on a TCP address, refusing any address but localhost and loopback IPs.
+func Listen(address string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("only localhost is served, %v is not a loopback address", address)
+	}
+
+	return net.Listen("tcp", address)
+}
+
+// Guard serves next only to requests made to localhost, 127.0.0.1 or ::1 at the port of the listener.
+// A page on another site can point its own name at 127.0.0.1, its requests still carry that name as their Host and are refused.
+// When token is set it is required as a bearer token, or as the password of basic authentication for clients that only support that.
+func Guard(listener net.Listener, token string, next http.Handler) http.Handler {
+	port := ""
+
+	if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+		port = strconv.Itoa(addr.Port)
+	}
+
+	hosts := map[string]bool{
+		net.JoinHostPort("localhost", port): true,
+		net.JoinHostPort("127.0.0.1", port): true,
+		net.JoinHostPort("::1", port):       true,
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hosts[strings.ToLower(r.Host)] {
+			http.Error(w, "only requests to localhost are served", http.StatusMisdirectedRequest)
+
+			return
+		}
+
+		if token != "" && !authorized(r, token) {
+			w.Header().Add("WWW-Authenticate", `Bearer realm="throw"`)
+			w.Header().Add("WWW-Authenticate", `Basic realm="throw"`)
+			http.Error(w, "a token is required", http.StatusUnauthorized)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Reports if a request carries the token.
+func authorized(r *http.Request, token string) bool {
+	given := ""
+
+	if _, password, ok := r.BasicAuth(); ok {
+		given = password
+	} else if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		given = auth[7:]
+	}
+
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
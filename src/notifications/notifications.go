@@ -1,6 +1,8 @@
 package notifications
 
 import (
+	"log"
+
 	"fyne.io/fyne/v2"
 )
 
@@ -12,6 +14,26 @@ func SendErrorNotification(message string) {
 	displayNotification(fyne.NewNotification("Error", message))
 }
 
+// Without a running app, as within the command line client, notifications are logged.
 func displayNotification(n *fyne.Notification) {
-	fyne.CurrentApp().SendNotification(n)
+	a := fyne.CurrentApp()
+
+	if a == nil {
+		log.Printf("%v: %v", n.Title, n.Content)
+
+		return
+	}
+
+	a.SendNotification(n)
+}
+
+// Desktop sends the notifications of the pufs client as desktop notifications.
+type Desktop struct{}
+
+func (Desktop) Success(message string) {
+	SendSuccessNotification(message)
+}
+
+func (Desktop) Error(message string) {
+	SendErrorNotification(message)
 }
@@ -0,0 +1,176 @@
+// Package policy decides what may be uploaded.
+// Each rule carries an action: allow skips the rule, warn lets the upload go ahead after telling the user, block refuses the upload.
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BitlyTwiser/throw/src/sniff"
+)
+
+const (
+	Allow = "allow"
+	Warn  = "warn"
+	Block = "block"
+)
+
+var Actions = []string{Allow, Warn, Block}
+
+// Rules reported within a violation.
+const (
+	RuleSize       = "size"
+	RuleType       = "type"
+	RuleEncryption = "encryption"
+)
+
+// Patterns are MIME types (text/plain), MIME type families (image/*) or extensions (.pdf).
+type Policy struct {
+	// Largest file in bytes, 0 for no limit. Larger files are handled by SizeAction, block when empty.
+	MaxFileSize int64
+	SizeAction  string
+	// When set, files matching none of these patterns are handled by TypeAction.
+	Allowed []string
+	// Files matching any of these patterns are handled by TypeAction, block when empty.
+	Denied     []string
+	TypeAction string
+	// Action for files that would be stored unencrypted, allow when empty.
+	RequireEncryption string
+}
+
+// Describes an upload about to start.
+type Upload struct {
+	FileName  string
+	Size      int64
+	MimeType  string
+	Encrypted bool
+}
+
+// A Violation is a rule an upload breaks. Violations with the block action are returned as errors by the client.
+type Violation struct {
+	FileName string
+	Rule     string
+	Action   string
+	Reason   string
+}
+
+func (v *Violation) Error() string {
+	if v.Action == Block {
+		return fmt.Sprintf("upload policy blocks %v: %v", v.FileName, v.Reason)
+	}
+
+	return fmt.Sprintf("upload policy warning for %v: %v", v.FileName, v.Reason)
+}
+
+// IsViolation reports if err is a blocked upload, returning the violation.
+func IsViolation(err error) (*Violation, bool) {
+	var v *Violation
+
+	return v, errors.As(err, &v)
+}
+
+// Check returns every rule the upload breaks.
+func (p Policy) Check(u Upload) []*Violation {
+	var violations []*Violation
+
+	add := func(rule, action, reason string) {
+		if action != Allow {
+			violations = append(violations, &Violation{FileName: u.FileName, Rule: rule, Action: action, Reason: reason})
+		}
+	}
+
+	if p.MaxFileSize > 0 && u.Size > p.MaxFileSize {
+		add(RuleSize, orDefault(p.SizeAction, Block), fmt.Sprintf("the file is %v bytes, the limit is %v bytes", u.Size, p.MaxFileSize))
+	}
+
+	if pattern, ok := matchAny(p.Denied, u); ok {
+		add(RuleType, orDefault(p.TypeAction, Block), fmt.Sprintf("%v files are denied", pattern))
+	} else if _, ok := matchAny(p.Allowed, u); len(p.Allowed) > 0 && !ok {
+		add(RuleType, orDefault(p.TypeAction, Block), fmt.Sprintf("%v files are not within the allowed types (%v)", describe(u), FormatPatterns(p.Allowed)))
+	}
+
+	if !u.Encrypted {
+		add(RuleEncryption, orDefault(p.RequireEncryption, Allow), "the file would be stored unencrypted")
+	}
+
+	return violations
+}
+
+// Blocked returns the first violation that refuses the upload, or nil.
+func Blocked(violations []*Violation) *Violation {
+	for _, v := range violations {
+		if v.Action == Block {
+			return v
+		}
+	}
+
+	return nil
+}
+
+// ParsePatterns splits a comma separated list of patterns as typed into the settings.
+func ParsePatterns(text string) []string {
+	var patterns []string
+
+	for _, p := range strings.Split(text, ",") {
+		p = strings.ToLower(strings.TrimSpace(p))
+
+		if p == "" {
+			continue
+		}
+
+		// Bare extensions are accepted, "exe" is ".exe".
+		if !strings.Contains(p, "/") && !strings.HasPrefix(p, ".") {
+			p = "." + p
+		}
+
+		patterns = append(patterns, p)
+	}
+
+	return patterns
+}
+
+func FormatPatterns(patterns []string) string {
+	return strings.Join(patterns, ", ")
+}
+
+func orDefault(action, fallback string) string {
+	if action == "" {
+		return fallback
+	}
+
+	return action
+}
+
+func matchAny(patterns []string, u Upload) (string, bool) {
+	for _, p := range patterns {
+		if match(p, u) {
+			return p, true
+		}
+	}
+
+	return "", false
+}
+
+func match(pattern string, u Upload) bool {
+	if strings.HasPrefix(pattern, ".") {
+		return strings.EqualFold(filepath.Ext(u.FileName), pattern)
+	}
+
+	mediaType := sniff.MediaType(u.MimeType)
+
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*"))
+	}
+
+	return mediaType == pattern
+}
+
+func describe(u Upload) string {
+	if t := sniff.MediaType(u.MimeType); t != "" {
+		return t
+	}
+
+	return filepath.Ext(u.FileName)
+}
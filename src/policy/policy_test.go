@@ -0,0 +1,93 @@
+package policy
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	pdf := Upload{FileName: "report.PDF", Size: 2000, MimeType: "application/pdf", Encrypted: true}
+	photo := Upload{FileName: "photo.jpg", Size: 500, MimeType: "image/jpeg", Encrypted: true}
+	script := Upload{FileName: "run.sh", Size: 10, MimeType: "application/x-sh; charset=utf-8"}
+
+	type violation struct {
+		rule   string
+		action string
+	}
+
+	tests := []struct {
+		name   string
+		policy Policy
+		upload Upload
+		want   []violation
+	}{
+		{"no rules", Policy{}, script, nil},
+		{"under the size limit", Policy{MaxFileSize: 2000}, pdf, nil},
+		{"size blocks by default", Policy{MaxFileSize: 1000}, pdf, []violation{{RuleSize, Block}}},
+		{"size warns", Policy{MaxFileSize: 1000, SizeAction: Warn}, pdf, []violation{{RuleSize, Warn}}},
+		{"size allowed", Policy{MaxFileSize: 1000, SizeAction: Allow}, pdf, nil},
+
+		{"denied extension, any case", Policy{Denied: []string{".pdf"}}, pdf, []violation{{RuleType, Block}}},
+		{"denied type family", Policy{Denied: []string{"image/*"}, TypeAction: Warn}, photo, []violation{{RuleType, Warn}}},
+		{"denied type with parameters", Policy{Denied: []string{"application/x-sh"}}, script, []violation{{RuleType, Block}}},
+		{"not denied", Policy{Denied: []string{"image/*", ".exe"}}, pdf, nil},
+
+		{"within the allow list", Policy{Allowed: []string{"image/*", ".pdf"}}, photo, nil},
+		{"outside the allow list", Policy{Allowed: []string{"image/*"}}, pdf, []violation{{RuleType, Block}}},
+		// The deny list wins over the allow list, a file matching both is denied once.
+		{"allowed and denied", Policy{Allowed: []string{"image/*"}, Denied: []string{".jpg"}, TypeAction: Warn}, photo, []violation{{RuleType, Warn}}},
+
+		{"unencrypted allowed by default", Policy{}, script, nil},
+		{"unencrypted warns", Policy{RequireEncryption: Warn}, script, []violation{{RuleEncryption, Warn}}},
+		{"encrypted", Policy{RequireEncryption: Block}, pdf, nil},
+
+		{"every rule", Policy{MaxFileSize: 1, Denied: []string{".sh"}, RequireEncryption: Block}, script, []violation{{RuleSize, Block}, {RuleType, Block}, {RuleEncryption, Block}}},
+	}
+
+	for _, test := range tests {
+		var got []violation
+
+		for _, v := range test.policy.Check(test.upload) {
+			got = append(got, violation{v.Rule, v.Action})
+
+			if v.FileName != test.upload.FileName || v.Reason == "" {
+				t.Errorf("%v: violation %+v does not describe the upload", test.name, v)
+			}
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: Check = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestBlocked(t *testing.T) {
+	warn := &Violation{FileName: "a", Rule: RuleSize, Action: Warn, Reason: "too large"}
+	block := &Violation{FileName: "a", Rule: RuleType, Action: Block, Reason: "denied"}
+
+	if Blocked([]*Violation{warn}) != nil {
+		t.Error("a warning blocked the upload")
+	}
+
+	if Blocked([]*Violation{warn, block}) != block {
+		t.Error("Blocked did not return the blocking violation")
+	}
+
+	if v, ok := IsViolation(fmt.Errorf("upload failed: %w", block)); !ok || v != block {
+		t.Error("IsViolation did not find a wrapped violation")
+	}
+}
+
+func TestParsePatterns(t *testing.T) {
+	got := ParsePatterns(" EXE, .Pdf ,image/*,, text/plain ")
+	want := []string{".exe", ".pdf", "image/*", "text/plain"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParsePatterns = %v, want %v", got, want)
+	}
+
+	if got := FormatPatterns(want); got != ".exe, .pdf, image/*, text/plain" {
+		t.Errorf("FormatPatterns = %q", got)
+	}
+}
@@ -0,0 +1,148 @@
+package pufs_client
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// Lookup returns the stored name of the file shown as name. Stored names are accepted as well, for files whose name cannot be decrypted.
+// The file list is loaded on first use.
+func (c *IpfsClient) Lookup(ctx context.Context, name string) (string, error) {
+	if err := c.loadOnce(ctx); err != nil {
+		return "", err
+	}
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	for _, stored := range c.Files {
+		if c.DisplayName(stored) == name {
+			return stored, nil
+		}
+	}
+
+	for _, stored := range c.Files {
+		if stored == name {
+			return stored, nil
+		}
+	}
+
+	return "", &RequestError{Op: "lookup", FileName: name, Err: ErrNotFound}
+}
+
+// List returns what is known of every stored file, in the order the server lists them. A name listed more than once is returned once.
+func (c *IpfsClient) List(ctx context.Context) ([]FileData, error) {
+	if err := c.loadOnce(ctx); err != nil {
+		return nil, err
+	}
+
+	c.mutex.RLock()
+	files := append([]string{}, c.Files...)
+	c.mutex.RUnlock()
+
+	listed := make(map[string]bool)
+	list := []FileData{}
+
+	for _, stored := range files {
+		if listed[stored] {
+			continue
+		}
+
+		listed[stored] = true
+		list = append(list, c.fileData(stored))
+	}
+
+	return list, nil
+}
+
+// Stat returns what is known of the file shown as name. Checksums, content types and encryption are only known for files this client has transferred.
+func (c *IpfsClient) Stat(ctx context.Context, name string) (FileData, error) {
+	stored, err := c.Lookup(ctx, name)
+
+	if err != nil {
+		return FileData{}, err
+	}
+
+	return c.fileData(stored), nil
+}
+
+// Put uploads the data read from r under name, picking a unique stored name as UploadFile does, and returns the stored name.
+// The data is spooled to disk and checked against the upload policy like any other upload.
+func (c *IpfsClient) Put(ctx context.Context, name string, r io.Reader) (string, error) {
+	if err := c.loadOnce(ctx); err != nil {
+		return "", err
+	}
+
+	spool, err := os.CreateTemp("", "throw-put-*")
+
+	if err != nil {
+		return "", requestError("put", name, err)
+	}
+
+	defer os.Remove(spool.Name())
+
+	_, err = io.Copy(spool, c.policyLimit(r))
+
+	if closeErr := spool.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return "", requestError("put", name, err)
+	}
+
+	var stored string
+
+	err = c.UploadFile(WithStoredName(ctx, func(s string) { stored = s }), spool.Name(), name)
+
+	return stored, requestError("put", name, err)
+}
+
+// Get writes the plaintext of the file shown as name to w. Data is written as it arrives, a checksum mismatch is only known once all of it has been written.
+func (c *IpfsClient) Get(ctx context.Context, name string, w io.Writer) error {
+	stored, err := c.Lookup(ctx, name)
+
+	if err != nil {
+		return err
+	}
+
+	r, err := c.OpenFile(ctx, stored)
+
+	if err != nil {
+		return requestError("get", name, err)
+	}
+
+	defer r.Close()
+
+	_, err = io.Copy(w, r)
+
+	return requestError("get", name, err)
+}
+
+// Delete deletes the file shown as name.
+func (c *IpfsClient) Delete(ctx context.Context, name string) error {
+	stored, err := c.Lookup(ctx, name)
+
+	if err != nil {
+		return err
+	}
+
+	return requestError("delete", name, c.DeleteFile(ctx, stored, false))
+}
+
+func (c *IpfsClient) loadOnce(ctx context.Context) error {
+	if c.loaded {
+		return nil
+	}
+
+	return requestError("list", "", c.LoadFiles(ctx))
+}
+
+func (c *IpfsClient) fileData(stored string) FileData {
+	if m := c.GetFileMetadata(stored); m != nil {
+		return *m
+	}
+
+	return FileData{FileName: stored}
+}
@@ -0,0 +1,256 @@
+package pufs_client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/BitlyTwiser/throw/src/archive"
+	"github.com/BitlyTwiser/throw/src/tree"
+)
+
+// UploadArchive packs the files of the folder at root selected by the filter and ignore files into a tar, streamed to the server as a single object shown as name.
+// The index of the archive is stored once the archive is, under archive.IndexName of the name the archive ended up with. Only the name of the archive is passed to WithStoredName.
+// Compression is taken from the settings or WithCompression.
+// Progress is reported in bytes of the tar, against the size of the files packed.
+func (c *IpfsClient) UploadArchive(ctx context.Context, root, name string, filter tree.Filter) (*archive.Index, error) {
+	if name = strings.Trim(path.Clean("/"+name), "/"); name == "" || archive.IsIndex(name) {
+		return nil, fmt.Errorf("%q cannot be used as an archive name", name)
+	}
+
+	files, err := tree.Walk(root, filter)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files within %v are selected", root)
+	}
+
+	var total int64
+
+	// The archive is one object, a single file refused by the upload policy refuses all of it.
+	for _, f := range files {
+		warnings, err := c.CheckPolicy(ctx, f.Path, path.Join(name, f.Name))
+
+		if err != nil {
+			return nil, fmt.Errorf("%v cannot be packed. Error: %w", f.Name, err)
+		}
+
+		for _, w := range warnings {
+			log.Printf("Packing %v. Warning: %v", f.Path, w)
+		}
+
+		total += f.Size
+	}
+
+	// The tar is written as it is read by the upload, the index is known once the upload has read all of it.
+	r, w := io.Pipe()
+	packed := make(chan *archive.Index, 1)
+
+	go func() {
+		index, err := archive.Pack(w, files)
+		packed <- index
+		w.CloseWithError(err)
+	}()
+
+	var stored string
+
+	uploadCtx := WithProgress(ctx, func(transferred, _ int64) { reportProgress(ctx, transferred, total) })
+	uploadCtx = WithStoredName(uploadCtx, func(s string) {
+		stored = s
+
+		if callback, ok := ctx.Value(storedNameKey{}).(func(string)); ok {
+			callback(s)
+		}
+	})
+
+	err = c.UploadReader(uploadCtx, r, -1, name)
+	r.CloseWithError(err)
+	index := <-packed
+
+	if err != nil {
+		return nil, requestError("upload", name, err)
+	}
+
+	if err := c.storeIndex(ctx, c.DisplayName(stored), index); err != nil {
+		return nil, fmt.Errorf("%v was uploaded, but its index could not be stored. Error: %w", name, err)
+	}
+
+	c.NotifySuccess(fmt.Sprintf("%v files packed into %v", len(index.Entries), c.DisplayName(stored)))
+
+	return index, nil
+}
+
+// Stores the index next to the archive shown as name. pufs cannot replace files, an index left behind by an archive of the same name is deleted first.
+func (c *IpfsClient) storeIndex(ctx context.Context, name string, index *archive.Index) error {
+	data, err := index.Marshal()
+
+	if err != nil {
+		return err
+	}
+
+	indexName := archive.IndexName(name)
+
+	if old, err := c.Lookup(ctx, indexName); err == nil {
+		log.Printf("Replacing the archive index %v", indexName)
+
+		if err := c.DeleteFile(WithReplace(ctx), old, false); err != nil {
+			return err
+		}
+	}
+
+	stored, err := c.exactFileName(indexName)
+
+	if err != nil {
+		return err
+	}
+
+	// The index is not what the caller uploaded, keep it out of their progress and stored name.
+	ctx = WithStoredName(WithProgress(WithExactName(ctx), nil), func(string) {})
+
+	return c.UploadReader(ctx, bytes.NewReader(data), int64(len(data)), stored)
+}
+
+// ArchiveIndex returns the index stored next to the archive shown as name.
+func (c *IpfsClient) ArchiveIndex(ctx context.Context, name string) (*archive.Index, error) {
+	var data bytes.Buffer
+
+	if err := c.Get(ctx, archive.IndexName(name), &data); err != nil {
+		return nil, err
+	}
+
+	return archive.ParseIndex(data.Bytes())
+}
+
+// Archives returns the names of every stored archive with an index, ordered as the server lists them.
+func (c *IpfsClient) Archives(ctx context.Context) ([]string, error) {
+	files, err := c.List(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	shown := make(map[string]bool)
+
+	for _, f := range files {
+		shown[c.DisplayName(f.FileName)] = true
+	}
+
+	var archives []string
+
+	for _, f := range files {
+		if name := c.DisplayName(f.FileName); !archive.IsIndex(name) && shown[archive.IndexName(name)] {
+			archives = append(archives, name)
+		}
+	}
+
+	return archives, nil
+}
+
+// OpenArchiveEntry streams a single file out of the archive shown as name, verified against the checksum within the index.
+// pufs has no ranged downloads and only sends files from their start. The archive is read up to the end of the entry and the download stops there, so the cost grows with the offset of the entry: files early within an archive are read fastest, those near its end cost nearly the whole archive.
+func (c *IpfsClient) OpenArchiveEntry(ctx context.Context, name string, index *archive.Index, entry string) (io.ReadCloser, error) {
+	e, ok := index.Entry(entry)
+
+	if !ok {
+		return nil, &RequestError{Op: "extract", FileName: entry, Err: ErrNotFound}
+	}
+
+	stored, err := c.Lookup(ctx, name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := c.OpenFile(ctx, stored)
+
+	if err != nil {
+		return nil, requestError("extract", entry, err)
+	}
+
+	section, err := archive.Section(r, e)
+
+	if err != nil {
+		r.Close()
+
+		return nil, requestError("extract", entry, err)
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{section, r}, nil
+}
+
+// ExtractArchiveEntry writes a single file of the archive shown as name into the folder at dir, under the base name of the entry, and returns its path.
+// An existing file is only replaced when overwrite is set, otherwise an error matching fs.ErrExist is returned before anything is downloaded.
+// The file is written next to its target first, so a failed extraction leaves nothing behind.
+func (c *IpfsClient) ExtractArchiveEntry(ctx context.Context, name string, index *archive.Index, entry, dir string, overwrite bool) (string, error) {
+	target := filepath.Join(dir, path.Base(entry))
+
+	if err := checkTarget(target, overwrite); err != nil {
+		return "", err
+	}
+
+	r, err := c.OpenArchiveEntry(ctx, name, index, entry)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer r.Close()
+
+	temp, err := os.CreateTemp(dir, ".throw-extract-*")
+
+	if err != nil {
+		return "", err
+	}
+
+	defer os.Remove(temp.Name())
+
+	_, err = io.Copy(temp, r)
+
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return "", requestError("extract", entry, err)
+	}
+
+	if e, ok := index.Entry(entry); ok && e.Mode.Perm() != 0 {
+		if err := os.Chmod(temp.Name(), e.Mode.Perm()); err != nil {
+			return "", fmt.Errorf("could not set the permissions of %v. Error: %w", entry, err)
+		}
+	}
+
+	// The target may have been created while the entry was downloading.
+	if err := checkTarget(target, overwrite); err != nil {
+		return "", err
+	}
+
+	return target, os.Rename(temp.Name(), target)
+}
+
+func checkTarget(target string, overwrite bool) error {
+	if overwrite {
+		return nil
+	}
+
+	if _, err := os.Lstat(target); err == nil {
+		return fmt.Errorf("%v is not replaced: %w", target, fs.ErrExist)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package pufs_client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/BitlyTwiser/throw/src/envelope"
+)
+
+// Encrypted objects end with a trailer holding the SHA-256 of the plaintext, encrypted with the file key and followed by a magic marker.
+// Hiding the digest keeps the trailer from confirming guesses of the file content.
+// Every other object is stored exactly as given, so other pufs clients read the same bytes under the same IPFS hash. Their checksum is only kept in metadata.
+const (
+	checksumMagic     = "THRWSUME"
+	checksumMagicSize = 8
+	// AES-GCM adds a 12 byte nonce and a 16 byte tag to the digest.
+	trailerSize = sha256.Size + envelope.NonceSize + envelope.TagSize + checksumMagicSize
+)
+
+// ChecksumError is returned when downloaded data does not match the checksum recorded at upload time.
+type ChecksumError struct {
+	FileName string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch for %v. Expected: %v Got: %v", e.FileName, e.Expected, e.Actual)
+}
+
+// Builds the trailer appended to an uploaded object, with the digest encrypted by the file key. Objects without a key get no trailer.
+func checksumTrailer(digest []byte, key []byte) ([]byte, error) {
+	if key == nil {
+		return nil, nil
+	}
+
+	sealed, err := envelope.SealBlock(key, digest)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(sealed, checksumMagic...), nil
+}
+
+// Splits the checksum trailer from the end of data, returning the body and the encrypted digest.
+// Objects without a trailer return a nil digest.
+func splitChecksumTrailer(data []byte) ([]byte, []byte) {
+	if len(data) < trailerSize || string(data[len(data)-checksumMagicSize:]) != checksumMagic {
+		return data, nil
+	}
+
+	body := data[:len(data)-trailerSize]
+
+	return body, data[len(body) : len(data)-checksumMagicSize]
+}
+
+// Holds back the end of a stored object as it streams past, the trailer is only known once the object has ended.
+type trailerSplitter struct {
+	tail []byte
+}
+
+// Next takes the next bytes of the object and returns those that cannot belong to the trailer.
+func (t *trailerSplitter) Next(data []byte) []byte {
+	data = append(t.tail, data...)
+
+	if len(data) <= trailerSize {
+		t.tail = data
+
+		return nil
+	}
+
+	release := len(data) - trailerSize
+	t.tail = append([]byte{}, data[release:]...)
+
+	return data[:release]
+}
+
+// End returns the rest of the object and the digest held by its trailer, nil when it has none.
+func (t *trailerSplitter) End() ([]byte, []byte) {
+	return splitChecksumTrailer(t.tail)
+}
+
+// Returns the checksum a download is verified against. Objects without a trailer are verified against the checksum recorded in metadata, if any.
+func (c *IpfsClient) expectedChecksum(fileName string, digest []byte, key []byte) (string, error) {
+	if digest != nil {
+		return openChecksum(digest, key)
+	}
+
+	if m := c.GetFileMetadata(fileName); m != nil {
+		return m.Checksum, nil
+	}
+
+	return "", nil
+}
+
+// Returns the hex encoded checksum held by a trailer, decrypting it with key.
+func openChecksum(digest []byte, key []byte) (string, error) {
+	if key == nil {
+		return "", fmt.Errorf("cannot decrypt the file checksum: %w", ErrNoKey)
+	}
+
+	plain, err := envelope.OpenBlock(key, digest)
+
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt file checksum: %v", err)
+	}
+
+	return hex.EncodeToString(plain), nil
+}
+
+// Compares the checksum of downloaded data against the expected checksum. Files without a recorded checksum cannot be verified and are accepted.
+func verifyChecksum(fileName, expected, actual string) error {
+	if expected == "" {
+		return nil
+	}
+
+	if expected != actual {
+		return &ChecksumError{FileName: fileName, Expected: expected, Actual: actual}
+	}
+
+	return nil
+}
@@ -1,41 +1,61 @@
+// Package pufs_client is the client of a pufs host. Files are encrypted, compressed and checksummed on their way up and verified on their way down.
+// It has no GUI dependencies: results are reported through a Notifier and changes through event handlers, see New for the options.
 package pufs_client
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"log"
-	"math"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	pufs_pb "github.com/BitlyTwiser/pufs-server/proto"
 
-	"github.com/BitlyTwiser/throw/src/notifications"
+	"github.com/BitlyTwiser/throw/src/cid"
+	"github.com/BitlyTwiser/throw/src/compression"
+	"github.com/BitlyTwiser/throw/src/envelope"
 	"github.com/BitlyTwiser/throw/src/settings"
-	"github.com/BitlyTwiser/tinychunk"
+	"github.com/BitlyTwiser/throw/src/sniff"
 
-	"github.com/BitlyTwiser/tinycrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-//Note: FileUploadedInApp Denotes if the file was uploaded in the application. If so, skip the reload when server sends back the ACK that a file was uploaded
+// The client keeps Files current as files are uploaded, deleted and announced by the server, see OnEvent to be told when it changes.
 type IpfsClient struct {
-	Id                int64
-	Client            pufs_pb.IpfsFileSystemClient
-	Files             []string
-	FileUpload        chan string
-	DeletedFile       chan string
-	FileDeleted       chan bool
-	FileUploadedInApp chan bool
-	Settings          *settings.Settings
-	nameInt           int
-	InvalidFileTypes  []string
-	FileMetadata      map[string]FileData
+	Id           int64
+	Client       pufs_pb.IpfsFileSystemClient
+	Files        []string
+	Settings     *settings.Settings
+	nameInt      int
+	FileMetadata map[string]FileData
+	// Told of finished uploads, downloads and errors. Nil to log them.
+	Notifier Notifier
+	// Transfers may run concurrently, guards FileMetadata and unique name creation.
+	mutex sync.RWMutex
+	names nameCipher
+	// Notified of files announced by the server event stream.
+	remoteListeners []chan string
+	eventHandlers   []EventHandler
+	// Set by New, closed by Close.
+	conn *grpc.ClientConn
+	// Set once LoadFiles has filled the file list.
+	loaded bool
+	// Metadata writes are held back while batches run, see batchMetadata.
+	metadataBatches int
+	metadataDirty   bool
 }
 
 type FileData struct {
@@ -43,15 +63,39 @@ type FileData struct {
 	FileSize   int64
 	IpfsHash   string
 	UploadedAt string
+	// Set once the IPFS hash reported by the server matched the hash computed locally from the data sent or received.
+	IpfsHashVerified bool
+	// Hex encoded SHA-256 of the plaintext. Known once a file has been uploaded or downloaded by this client.
+	Checksum string
+	// Set when the file is a link to an existing file with the same content.
+	LinkTarget string
+	// How the stored object is encrypted and the id of the keyring entry holding its key.
+	Encryption string
+	KeyId      string
+	// Content type sniffed from the plaintext, text types carry their charset.
+	MimeType string
 }
 
 type Empty struct{}
 
-func (c *IpfsClient) UploadFileStream(fileData *os.File, fileSize int64, fileName string) error {
-	var wg sync.WaitGroup
+const (
+	// Size of each chunk read from a source and sent over the upload stream.
+	chunkSize int = 2 << 20
+	// Anything at or above this size is streamed, gRPC caps single messages at 4MB.
+	streamThreshold int64 = 2 << 21
+	// Attempts made to resume an interrupted transfer before reporting an error.
+	transferAttempts int = 3
+	// Deadline for requests that do not move file data, also the floor for transfer deadlines.
+	requestTimeout = 30 * time.Second
+	// Used when no minimum throughput has been set within the settings.
+	defaultMinThroughputKBps int = 64
+)
+
+// UploadFileStream sends the contents of r to the server in fixed size chunks.
+// Only a single chunk is held in memory at any point, so the file size does not dictate memory usage.
+func (c *IpfsClient) UploadFileStream(ctx context.Context, r io.Reader, fileSize int64, fileName string) error {
 	log.Printf("Sending large file.. File Size: %v", fileSize)
-	// Look to make the time variables depending on file size as well.
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := c.transferContext(ctx, fileSize)
 	defer cancel()
 
 	fileUpload, err := c.Client.UploadFileStream(ctx)
@@ -60,23 +104,20 @@ func (c *IpfsClient) UploadFileStream(fileData *os.File, fileSize int64, fileNam
 		return err
 	}
 
-	fileName = c.createUniqueFileName(fileName)
+	fileName, err = c.remoteFileName(ctx, fileName)
 
-	//No IPFS hash here, that will not be known until we upload on server
+	if err != nil {
+		return err
+	}
+
+	// The IPFS hash depends on every byte sent, it is computed as data goes out and recorded once the upload completes.
 	metadata := &pufs_pb.File{
 		Filename:   fileName,
-		FileSize:   fileSize,
+		FileSize:   storedFileSize(fileName, fileSize),
 		IpfsHash:   "",
 		UploadedAt: timestamppb.New(time.Now()),
 	}
 
-	data := make([]byte, fileSize)
-	_, err = fileData.Read(data)
-
-	if err != nil {
-		return err
-	}
-
 	log.Println("Sending first request")
 	// Send metadata request first then data.
 	m := &pufs_pb.UploadFileStreamRequest{Data: &pufs_pb.UploadFileStreamRequest_FileMetadata{
@@ -85,49 +126,122 @@ func (c *IpfsClient) UploadFileStream(fileData *os.File, fileSize int64, fileNam
 
 	if err := fileUpload.Send(m); err != nil {
 		log.Printf("Error sending first request: %v", err)
+		return err
+	}
+
+	// The buffer is re-used for every chunk. gRPC serializes the message before Send returns, so this is safe.
+	buffer := make([]byte, chunkSize)
+	checksum := sha256.New()
+	ipfsHash := cid.NewBuilder(cid.V0)
+	var sent int64
+
+	// The file header decides on encryption and compression.
+	source := bufio.NewReaderSize(r, chunkSize)
+	head, err := source.Peek(sniff.HeadSize)
+
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return err
 	}
 
-	validFile := c.validFileType(data)
+	mimeType := sniff.Detect(c.DisplayName(fileName), head)
+	validFile := encryptable(mimeType)
 
 	if !validFile {
-		log.Println("Invalid file type, cannot encrypt binary files.")
+		log.Printf("Cannot encrypt executable files, %v is %v", fileName, mimeType)
 	}
-	// Total chunks is utilized here to ensure we add enough wait groups.
-	// In this particular case, we are chunking the data into 2MB chunks. If alloted amount was altered, we would be forced to revisit this logic.
-	totalChunks := uint(math.Floor(float64(fileSize) / float64((2 << 20))))
 
-	wg.Add(int(totalChunks))
-	err = tinychunk.Chunk(data, 2, func(chunkedData []byte) error {
-		defer wg.Done()
+	// Checksum and progress track the plaintext as it is read, before compression.
+	// Compression reads from a separate goroutine, so the counter is accessed atomically.
+	plaintext := writerFunc(func(p []byte) (int, error) {
+		checksum.Write(p)
+		atomic.AddInt64(&sent, int64(len(p)))
 
-		if c.Settings.Encrypted && validFile {
-			log.Println("Encrypting file data")
+		return len(p), nil
+	})
 
-			ed, err := tinycrypt.EncryptByteStream(c.Settings.Password, chunkedData)
+	var data io.Reader = io.TeeReader(source, plaintext)
 
-			if err != nil {
-				return err
-			}
+	if algorithm := c.compressionFor(ctx, c.DisplayName(fileName), head); algorithm != compression.None {
+		log.Printf("Compressing file data using %v", algorithm)
+
+		compressed, err := compression.Compress(data, algorithm)
+
+		if err != nil {
+			return err
+		}
+
+		defer compressed.Close()
+		data = compressed
+	}
 
-			chunkedData = *ed
+	// Compressed data is encrypted, the key encrypts the checksum as well.
+	var key []byte
+	encryption, keyId := c.encryptionFor(ctx, validFile)
+
+	if encryption != EncryptionNone {
+		log.Println("Encrypting file data")
+
+		var h *envelope.Header
+		h, key, err = c.newEnvelope(ctx, keyId)
+
+		if err != nil {
+			return err
+		}
+
+		encrypted := envelope.Encrypt(data, h, key)
+		defer encrypted.Close()
+		data = encrypted
+	}
+
+	for {
+		n, err := io.ReadFull(data, buffer)
+
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			log.Printf("Error reading file data: %v", err)
+			return err
+		}
+
+		if n == 0 {
+			break
 		}
 
+		chunkedData := buffer[:n]
+
 		log.Println("Sending chunked data")
 		if err := fileUpload.Send(&pufs_pb.UploadFileStreamRequest{Data: &pufs_pb.UploadFileStreamRequest_FileData{FileData: chunkedData}}); err != nil {
 			log.Printf("Error sending file: %v", err)
 			return err
 		}
 
-		return nil
-	})
+		ipfsHash.Write(chunkedData)
+		reportProgress(ctx, atomic.LoadInt64(&sent), fileSize)
+
+		// A short read denotes the end of the stream.
+		if n < chunkSize {
+			break
+		}
+	}
+
+	if fileSize >= 0 && sent != fileSize {
+		return fmt.Errorf("file size mismatch. Expected %v bytes, read %v bytes", fileSize, sent)
+	}
 
-	wg.Wait()
+	digest := checksum.Sum(nil)
+	trailer, err := checksumTrailer(digest, key)
 
 	if err != nil {
-		log.Printf("Error chunking and sending data: %v", err)
 		return err
 	}
 
+	if trailer != nil {
+		if err := fileUpload.Send(&pufs_pb.UploadFileStreamRequest{Data: &pufs_pb.UploadFileStreamRequest_FileData{FileData: trailer}}); err != nil {
+			log.Printf("Error sending file checksum: %v", err)
+			return err
+		}
+
+		ipfsHash.Write(trailer)
+	}
+
 	resp, err := fileUpload.CloseAndRecv()
 
 	if err != nil {
@@ -143,24 +257,52 @@ func (c *IpfsClient) UploadFileStream(fileData *os.File, fileSize int64, fileNam
 
 	c.SaveFileMetadata(FileData{
 		FileName:   fileName,
-		FileSize:   fileSize,
-		IpfsHash:   "",
+		FileSize:   sent,
+		IpfsHash:   ipfsHash.Sum(),
 		UploadedAt: time.Now().String(),
+		Checksum:   hex.EncodeToString(digest),
+		Encryption: encryption,
+		KeyId:      keyId,
+		MimeType:   mimeType,
 	})
 
-	c.FileUpload <- fileName
-	c.FileUploadedInApp <- true
+	c.uploaded(fileName)
+
+	return nil
+}
+
+// Uploads the file at path once it passes the upload policy.
+func (c *IpfsClient) UploadFile(ctx context.Context, path, fileName string) error {
+	warnings, err := c.CheckPolicy(ctx, path, fileName)
+
+	if err != nil {
+		return err
+	}
+
+	for _, w := range warnings {
+		log.Println(w)
+		c.NotifyError(w.Error())
+	}
+
+	if err := c.uploadFile(ctx, path, fileName); err != nil {
+		return err
+	}
+
+	c.NotifySuccess("File uploaded")
 
 	return nil
 }
 
-func (c *IpfsClient) UploadFile(path, fileName string) error {
+// Uploads the file at path without notifying the user, bulk operations report once they are done.
+func (c *IpfsClient) uploadFile(ctx context.Context, path, fileName string) error {
 	file, err := os.OpenFile(path, os.O_RDONLY, 0400)
 
 	if err != nil {
 		return err
 	}
 
+	defer file.Close()
+
 	fileInfo, err := file.Stat()
 
 	if err != nil {
@@ -169,45 +311,162 @@ func (c *IpfsClient) UploadFile(path, fileName string) error {
 
 	fileSize := fileInfo.Size()
 
-	//gRPC data size cap at 4MB
-	if fileSize >= (2 << 21) {
-		log.Println("Sending big file")
-		err = c.UploadFileStream(file, fileSize, fileName)
+	if fileSize >= streamThreshold {
+		return c.uploadJournaled(ctx, file, fileInfo, path, fileName)
+	}
 
-		if err != nil {
-			return err
-		}
+	return c.UploadReader(ctx, file, fileSize, fileName)
+}
+
+// Streams a file from disk, journaling the upload until it completes. An interrupted upload of an unchanged file is retried under its original remote name.
+// pufs discards incomplete upload streams, a retried upload sends the file from its start.
+func (c *IpfsClient) uploadJournaled(ctx context.Context, file *os.File, fileInfo os.FileInfo, path, fileName string) error {
+	journal, err := LoadJournal(JournalUpload, path)
+
+	if err != nil {
+		log.Printf("Error loading transfer journal, starting over. Error: %v", err)
+	}
+
+	if journal != nil && journal.TotalSize == fileInfo.Size() && journal.ModTime == fileInfo.ModTime().Unix() {
+		log.Printf("Retrying interrupted upload of %v", path)
+		fileName = journal.FileName
 	} else {
-		log.Printf("Sending file of size: %v", fileSize)
+		journal = NewTransferJournal(JournalUpload, fileName, path, fileInfo.Size())
+		journal.ModTime = fileInfo.ModTime().Unix()
+	}
 
-		fileData := make([]byte, fileSize)
-		_, err := file.Read(fileData)
+	if err := journal.Save(); err != nil {
+		return err
+	}
 
-		if err != nil {
-			return err
+	if err := c.UploadFileStream(ctx, file, fileInfo.Size(), fileName); err != nil {
+		return err
+	}
+
+	return journal.Remove()
+}
+
+// ResumeTransfers picks up every transfer that was interrupted by a crash or disconnect. Downloads continue from their last checkpoint, uploads are sent again.
+func (c *IpfsClient) ResumeTransfers(ctx context.Context) {
+	journals, err := PendingJournals()
+
+	if err != nil {
+		log.Printf("Error loading transfer journals. Error: %v", err)
+
+		return
+	}
+
+	for _, j := range journals {
+		var err error
+
+		// Key rotations resume their own transfers.
+		if inRotationDir(j.LocalPath) {
+			continue
 		}
 
-		err = c.UploadFileData(fileData, fileSize, fileName)
+		switch j.Direction {
+		case JournalUpload:
+			if _, statErr := os.Stat(j.LocalPath); statErr != nil {
+				log.Printf("Source of interrupted upload is gone, dropping journal: %v", j.LocalPath)
+				j.Remove()
+
+				continue
+			}
+
+			err = c.UploadFile(ctx, j.LocalPath, j.FileName)
+		case JournalDownload:
+			err = c.DownloadCappedFile(ctx, j.FileName, filepath.Dir(j.LocalPath))
+		}
 
 		if err != nil {
-			return err
+			c.NotifyError(fmt.Sprintf("Error resuming %v of %v. Error: %v", j.Direction, j.FileName, err))
 		}
 	}
+}
 
-	c.SaveFileMetadata(FileData{
-		FileName:   fileName,
-		FileSize:   fileSize,
-		IpfsHash:   "",
-		UploadedAt: time.Now().String(),
-	})
+// UploadReader uploads the data read from r. The fileSize may be -1 when the size is not known ahead of time (stdin, network streams etc..)
+// Small payloads are sent as a single request, everything else is streamed in chunks.
+func (c *IpfsClient) UploadReader(ctx context.Context, r io.Reader, fileSize int64, fileName string) error {
+	if fileSize < 0 {
+		return c.uploadUnknownSize(ctx, r, fileName)
+	}
+
+	//gRPC data size cap at 4MB
+	if fileSize >= streamThreshold {
+		log.Println("Sending big file")
 
-	notifications.SendSuccessNotification("File uploaded")
+		return c.UploadFileStream(ctx, r, fileSize, fileName)
+	}
 
-	return nil
+	log.Printf("Sending file of size: %v", fileSize)
+
+	fileData := make([]byte, fileSize)
+	_, err := io.ReadFull(r, fileData)
+
+	if err != nil {
+		return err
+	}
+
+	return c.UploadFileData(ctx, fileData, fileSize, fileName)
+}
+
+// The server expects the file size within the metadata, which is sent before any data.
+// Read up to the stream threshold, if the reader is drained by then the data is sent in a single request.
+// Otherwise the remainder is spooled to a temporary file on disk so memory usage stays flat and the size is known.
+func (c *IpfsClient) uploadUnknownSize(ctx context.Context, r io.Reader, fileName string) error {
+	head := make([]byte, streamThreshold)
+	n, err := io.ReadFull(r, head)
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return c.UploadFileData(ctx, head[:n], int64(n), fileName)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	spool, err := os.CreateTemp("", "throw-upload-*")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	rest, err := io.Copy(spool, r)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return c.UploadFileStream(ctx, io.MultiReader(bytes.NewReader(head), spool), int64(n)+rest, fileName)
 }
 
-func (c *IpfsClient) DeleteFile(fileName string, showMessage bool) error {
-	ctx, cancel := context.WithCancel(context.Background())
+// DeleteFile deletes a stored file. Files other files link to are refused, unless the delete is started WithReplace.
+func (c *IpfsClient) DeleteFile(ctx context.Context, fileName string, showMessage bool) error {
+	// Links only hold the name of their target, deleting it would leave them pointing at nothing.
+	if replace, _ := ctx.Value(replaceKey{}).(bool); !replace {
+		links, err := c.linksTo(ctx, fileName)
+
+		if err != nil {
+			return err
+		}
+
+		if len(links) > 0 {
+			for i, link := range links {
+				links[i] = c.DisplayName(link)
+			}
+
+			return fmt.Errorf("%v is linked to by %v, delete the links first", c.DisplayName(fileName), strings.Join(links, ", "))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
 	defer cancel()
 
 	resp, err := c.Client.DeleteFile(ctx, &pufs_pb.DeleteFileRequest{FileName: fileName})
@@ -218,7 +477,7 @@ func (c *IpfsClient) DeleteFile(fileName string, showMessage bool) error {
 
 	if resp.Successful {
 		if showMessage {
-			notifications.SendSuccessNotification("File Deleted")
+			c.NotifySuccess("File Deleted")
 		}
 	} else {
 		return fmt.Errorf("error occured deleting file: %v", resp)
@@ -226,18 +485,37 @@ func (c *IpfsClient) DeleteFile(fileName string, showMessage bool) error {
 
 	c.DeleteFileMetadata(fileName)
 
-	// Push onto a different channel for refreshing files.
-	c.DeletedFile <- fileName
-	c.FileDeleted <- true
+	c.deleted(fileName)
 
 	return nil
 }
 
-func (c *IpfsClient) DownloadCappedFile(fileName, path string) error {
-	var data []byte
+// DownloadCappedFile streams a file into a partial file next to the target, renaming it once complete.
+// Progress is journaled every few seconds or megabytes. If an earlier attempt was interrupted, the confirmed data on disk is validated against the journal and kept.
+// Note: pufs always streams from the start of the file, so confirmed chunks are still received but are skipped rather than re-written.
+func (c *IpfsClient) DownloadCappedFile(ctx context.Context, fileName, path string) error {
 	log.Printf("Downloading larger file: %v", fileName)
 
-	ctx, cancel := context.WithCancel(context.Background())
+	target := filepath.Join(path, c.LocalFileName(fileName))
+	partial := target + partialSuffix
+
+	journal, err := c.downloadJournal(fileName, target, partial)
+
+	if err != nil {
+		return err
+	}
+
+	size := journal.TotalSize
+
+	if size <= 0 {
+		size, err = c.FileSize(ctx, fileName)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := c.transferContext(ctx, size)
 	defer cancel()
 
 	req := &pufs_pb.DownloadFileRequest{FileName: fileName}
@@ -248,12 +526,100 @@ func (c *IpfsClient) DownloadCappedFile(fileName, path string) error {
 		return err
 	}
 
-	file, err := os.OpenFile(fmt.Sprintf("%v/%v", path, fileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	file, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY, 0600)
 
 	if err != nil {
 		log.Printf("error opening file to store downloaded data: %v", err)
+		return err
+	}
+
+	defer file.Close()
+
+	// Drop anything past the confirmed data, it may have been written without being journaled.
+	if err := file.Truncate(journal.BytesConfirmed); err != nil {
+		return err
+	}
+
+	if _, err := file.Seek(journal.BytesConfirmed, io.SeekStart); err != nil {
+		return err
+	}
+
+	if journal.BytesConfirmed > 0 {
+		log.Printf("Resuming download of %v from byte %v", fileName, journal.BytesConfirmed)
+	}
+
+	var received int64
+
+	// Never leave corrupt data behind, the next attempt starts from scratch.
+	discard := func() {
+		file.Close()
+		os.Remove(partial)
+		journal.Remove()
+	}
+
+	// Writes decompressed data to disk, skipping data that was confirmed by a previous attempt.
+	sink := writerFunc(func(data []byte) (int, error) {
+		skip := journal.BytesConfirmed - received
+		received += int64(len(data))
+		reportProgress(ctx, received, journal.TotalSize)
+
+		if skip >= int64(len(data)) {
+			return len(data), nil
+		}
+
+		written := data
+
+		if skip > 0 {
+			written = data[skip:]
+		}
+
+		n, err := file.Write(written)
+
+		if err != nil {
+			return 0, fmt.Errorf("%w: %v", errWrite, err)
+		}
+
+		if n == 0 {
+			return 0, errWrite
+		}
+
+		journal.Confirm(written)
+
+		if !journal.Due() {
+			return len(data), nil
+		}
+
+		// Data must be on disk before the journal claims it.
+		if err := file.Sync(); err != nil {
+			return 0, fmt.Errorf("%w: %v", errWrite, err)
+		}
+
+		return len(data), journal.Save()
+	})
+
+	plaintext := compression.NewDecompressWriter(sink)
+	defer plaintext.Close()
+
+	// Envelope frames are decrypted whatever the chunk boundaries of the stream, then decompressed.
+	payload := c.newPayloadWriter(plaintext)
+
+	write := func(data []byte) error {
+		if _, err := payload.Write(data); err != nil {
+			// Data that cannot be decoded is corrupt, a failed disk write is not.
+			if !errors.Is(err, errWrite) {
+				discard()
+			}
+
+			return err
+		}
+
+		return nil
 	}
 
+	// The checksum trailer may be split across the final messages, so the tail of the stream is held back until the stream ends.
+	var trailer trailerSplitter
+	ipfsHash := cid.NewBuilder(cid.V0)
+
 	for {
 		fileChunk, err := download.Recv()
 
@@ -268,35 +634,105 @@ func (c *IpfsClient) DownloadCappedFile(fileName, path string) error {
 			return err
 		}
 
-		data = fileChunk.GetFileData()
+		ipfsHash.Write(fileChunk.GetFileData())
 
-		if c.Settings.Encrypted {
-			dd, err := tinycrypt.DecryptByteStream(c.Settings.Password, fileChunk.GetFileData())
-
-			if err != nil {
+		if released := trailer.Next(fileChunk.GetFileData()); len(released) > 0 {
+			if err := write(released); err != nil {
 				return err
 			}
-
-			data = *dd
 		}
+	}
 
-		n, err := file.Write(data)
+	body, digest := trailer.End()
 
-		if err != nil {
+	if len(body) > 0 {
+		if err := write(body); err != nil {
 			return err
 		}
+	}
 
-		if n == 0 {
-			return errors.New("no bytes were written to file")
-		}
+	if err := payload.Close(); err != nil {
+		discard()
+
+		return err
 	}
 
-	return nil
+	if err := plaintext.Close(); err != nil {
+		discard()
+
+		return err
+	}
+
+	info := payload.Info()
+
+	if digest != nil && info.key == nil {
+		discard()
+
+		return fmt.Errorf("%v has an encrypted checksum but no encryption envelope, it is either corrupt or was encrypted per chunk by an older client", fileName)
+	}
+
+	expected, err := c.expectedChecksum(fileName, digest, info.key)
+
+	if err != nil {
+		discard()
+
+		return err
+	}
+
+	if err := verifyChecksum(fileName, expected, journal.Checksum); err != nil {
+		discard()
+
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(partial, target); err != nil {
+		return err
+	}
+
+	done := c.batchMetadata()
+	c.saveDownload(fileName, journal.Checksum, detectFile(c.DisplayName(fileName), target), info)
+
+	if m := c.GetFileMetadata(fileName); m != nil {
+		c.checkIpfsHash(fileName, m.IpfsHash, ipfsHash.Sum())
+	}
+
+	done()
+
+	return journal.Remove()
+}
+
+// Loads the journal of an interrupted download, falling back to a fresh journal if the partial file cannot be trusted.
+func (c *IpfsClient) downloadJournal(fileName, target, partial string) (*TransferJournal, error) {
+	var size int64
+
+	if m := c.GetFileMetadata(fileName); m != nil {
+		size = m.FileSize
+	}
+
+	journal, err := LoadJournal(JournalDownload, fileName)
+
+	if err != nil {
+		log.Printf("Error loading transfer journal, starting over. Error: %v", err)
+	}
+
+	if journal == nil || journal.LocalPath != target {
+		journal = NewTransferJournal(JournalDownload, fileName, target, size)
+	} else if err := journal.Verify(partial); err != nil {
+		log.Printf("Partial download of %v could not be verified, starting over. Error: %v", fileName, err)
+		journal.Reset()
+	}
+
+	return journal, journal.Save()
 }
 
 // We must chunk the file here if its over the 4MB limit.
-func (c *IpfsClient) DownloadFile(fileName, path string) error {
-	ctx, cancel := context.WithCancel(context.Background())
+func (c *IpfsClient) DownloadFile(ctx context.Context, fileName, path string) error {
+	// Only files below the stream threshold are sent in a single response.
+	ctx, cancel := c.transferContext(ctx, streamThreshold)
 	defer cancel()
 
 	log.Printf("Downloading file: %v", fileName)
@@ -309,60 +745,141 @@ func (c *IpfsClient) DownloadFile(fileName, path string) error {
 
 	fileData, fileMetadata := fileResp.FileData, fileResp.FileMetadata
 
-	if c.Settings.Encrypted {
-		dd, err := tinycrypt.DecryptByteStream(c.Settings.Password, fileData)
+	if target, ok := linkTarget(fileData); ok {
+		return c.downloadLink(ctx, fileName, target, path)
+	}
 
-		if err != nil {
-			return nil
-		}
+	// Verified before anything touches the disk, a mismatch leaves no file behind.
+	fileData, actual, info, err := c.decodeObject(fileName, fileData)
 
-		fileData = *dd
+	if err != nil {
+		return err
 	}
 
 	log.Println("Downloading file and saving to disk...")
 
-	err = os.WriteFile(fmt.Sprintf("%v/%v", path, fileMetadata.Filename), fileData, 0600)
+	// Write to a partial file first so an interrupted write never leaves a truncated file behind.
+	target := filepath.Join(path, c.LocalFileName(fileMetadata.Filename))
+	err = os.WriteFile(target+partialSuffix, fileData, 0600)
 
 	if err != nil {
 		return err
 	}
 
-	notifications.SendSuccessNotification("File Downloaded")
+	err = os.Rename(target+partialSuffix, target)
+
+	if err != nil {
+		return err
+	}
+
+	done := c.batchMetadata()
+	c.saveDownload(fileName, actual, sniff.Detect(c.DisplayName(fileName), fileData), info)
+	c.checkIpfsHash(fileName, fileMetadata.GetIpfsHash(), cid.Bytes(fileResp.FileData, cid.V0))
+	done()
+	reportProgress(ctx, int64(len(fileData)), int64(len(fileData)))
+
+	c.NotifySuccess("File Downloaded")
 
 	return nil
 }
 
+// Decrypts, decompresses and verifies a stored object held in memory, returning the plaintext and its checksum.
+func (c *IpfsClient) decodeObject(fileName string, data []byte) ([]byte, string, payloadInfo, error) {
+	data, digest := splitChecksumTrailer(data)
+
+	// The stored data describes how it was encrypted, the current settings only provide the keys.
+	data, info, err := c.openPayload(data, digest != nil)
+
+	if err != nil {
+		return nil, "", info, err
+	}
+
+	expected, err := c.expectedChecksum(fileName, digest, info.key)
+
+	if err != nil {
+		return nil, "", info, err
+	}
+
+	data, err = compression.Decompress(data)
+
+	if err != nil {
+		return nil, "", info, err
+	}
+
+	sum := sha256.Sum256(data)
+	actual := hex.EncodeToString(sum[:])
+
+	if err := verifyChecksum(fileName, expected, actual); err != nil {
+		return nil, "", info, err
+	}
+
+	return data, actual, info, nil
+}
+
 //Uploads a file stream that is under the 4MB gRPC file size cap
-func (c *IpfsClient) UploadFileData(fileData []byte, fileSize int64, fileName string) error {
-	ctx, cancel := context.WithCancel(context.Background())
+func (c *IpfsClient) UploadFileData(ctx context.Context, fileData []byte, fileSize int64, fileName string) error {
+	ctx, cancel := c.transferContext(ctx, fileSize)
 	defer cancel()
 
-	fileName = c.createUniqueFileName(fileName)
+	fileName, err := c.remoteFileName(ctx, fileName)
+
+	if err != nil {
+		return err
+	}
 
 	file := &pufs_pb.File{
 		Filename:   fileName,
-		FileSize:   fileSize,
+		FileSize:   storedFileSize(fileName, fileSize),
 		IpfsHash:   "",
 		UploadedAt: timestamppb.New(time.Now()),
 	}
 
-	validFile := c.validFileType(fileData)
+	mimeType := sniff.Detect(c.DisplayName(fileName), fileData)
+	validFile := encryptable(mimeType)
 
 	if !validFile {
-		log.Println("Cannot encrypt binary files")
+		log.Printf("Cannot encrypt executable files, %v is %v", fileName, mimeType)
+	}
+
+	digest := sha256.Sum256(fileData)
+
+	fileData, err = compression.Bytes(fileData, c.compressionFor(ctx, c.DisplayName(fileName), fileData))
+
+	if err != nil {
+		return err
 	}
 
+	var key []byte
+
 	// Validate if files are binary files here.
-	if c.Settings.Encrypted && validFile {
-		ed, err := tinycrypt.EncryptByteStream(c.Settings.Password, fileData)
+	encryption, keyId := c.encryptionFor(ctx, validFile)
+
+	if encryption != EncryptionNone {
+		var h *envelope.Header
+		h, key, err = c.newEnvelope(ctx, keyId)
+
+		if err != nil {
+			return err
+		}
+
+		fileData, err = envelope.Seal(fileData, h, key)
 
 		if err != nil {
 			return err
 		}
+	}
+
+	trailer, err := checksumTrailer(digest[:], key)
 
-		fileData = *ed
+	if err != nil {
+		return err
 	}
 
+	fileData = append(fileData, trailer...)
+
+	// The data is final at this point, so the IPFS hash is known before the upload.
+	file.IpfsHash = cid.Bytes(fileData, cid.V0)
+
 	log.Println("Uploading file")
 
 	request := &pufs_pb.UploadFileRequest{FileData: fileData, FileMetadata: file}
@@ -376,25 +893,40 @@ func (c *IpfsClient) UploadFileData(fileData []byte, fileSize int64, fileName st
 		return errors.New("something went wrong uploading file")
 	}
 
-	c.FileUpload <- fileName
-	c.FileUploadedInApp <- true
+	reportProgress(ctx, fileSize, fileSize)
+
+	c.SaveFileMetadata(FileData{
+		FileName:   fileName,
+		FileSize:   fileSize,
+		IpfsHash:   file.IpfsHash,
+		UploadedAt: time.Now().String(),
+		Checksum:   hex.EncodeToString(digest[:]),
+		Encryption: encryption,
+		KeyId:      keyId,
+		MimeType:   mimeType,
+	})
+
+	c.uploaded(fileName)
 
 	return nil
 }
 
 // Load files upon client start.
-func (c *IpfsClient) LoadFiles() {
-	ctx, cancel := context.WithCancel(context.Background())
+func (c *IpfsClient) LoadFiles(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
 	defer cancel()
 
 	req, err := c.Client.ListFiles(ctx, &pufs_pb.FilesRequest{})
 
 	if err != nil {
-		notifications.SendErrorNotification(fmt.Sprintf("Error loading files from server. Error: %v", err))
+		c.NotifyError(fmt.Sprintf("Error loading files from server. Error: %v", err))
 
-		return
+		return err
 	}
 
+	index := loadMetadataIndex()
+	defer c.batchMetadata()()
+
 	for {
 		file, err := req.Recv()
 
@@ -403,86 +935,138 @@ func (c *IpfsClient) LoadFiles() {
 		}
 
 		if err != nil {
-			log.Fatalf("Error reading file stream. Error: %v", err)
-			break
+			c.NotifyError(fmt.Sprintf("Error reading file stream. Error: %v", err))
+
+			return err
 		}
 		t := time.Unix(file.Files.UploadedAt.Seconds, 0)
 		date := t.Format(time.UnixDate)
-		c.SaveFileMetadata(FileData{
+		// Anything this client already knows about the file is kept, the locally computed hash is checked against the server.
+		local := c.GetFileMetadata(file.Files.Filename)
+
+		// Entries from a previous run are only trusted while the server still holds the same content under the name.
+		if v, ok := index[file.Files.Filename]; local == nil && ok && v.IpfsHash == cid.Normalize(file.Files.IpfsHash) {
+			local = &v
+		}
+
+		data := FileData{
 			FileName:   file.Files.Filename,
 			FileSize:   file.Files.FileSize,
-			IpfsHash:   file.Files.IpfsHash,
+			IpfsHash:   cid.Normalize(file.Files.IpfsHash),
 			UploadedAt: date,
-		})
+		}
+
+		if local != nil {
+			data.Checksum = local.Checksum
+			data.LinkTarget = local.LinkTarget
+			data.Encryption = local.Encryption
+			data.KeyId = local.KeyId
+			data.MimeType = local.MimeType
+
+			// The server only knows the rounded size of files with an encrypted name.
+			if local.FileSize > 0 {
+				data.FileSize = local.FileSize
+			}
+		}
+
+		c.SaveFileMetadata(data)
+
+		if local != nil && local.IpfsHash != "" {
+			c.checkIpfsHash(data.FileName, data.IpfsHash, local.IpfsHash)
+		}
 
 		c.Files = append(c.Files, file.Files.Filename)
 	}
+
+	c.loaded = true
+
+	return nil
 }
 
 // Listen for file changes realtime.
 // Take ID and store this upstream.
-func (c *IpfsClient) SubscribeFileStream() {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	for {
+// The subscription is long lived, it has no deadline and only ends when ctx is cancelled. A broken stream is subscribed again after 5 seconds.
+// The server sends every file it holds after each change, each is passed to RemoteChanges listeners and event handlers without waiting on them.
+func (c *IpfsClient) SubscribeFileStream(ctx context.Context) {
+	for ctx.Err() == nil {
 		stream, err := c.Client.ListFilesEventStream(ctx, &pufs_pb.FilesRequest{Id: c.Id})
 
-		if err != nil || stream == nil {
-			log.Println("Error or stream not empty, waiting for 5 seconds")
-			time.Sleep(time.Second * 5)
-
-			continue
-		} else {
-			file, err := stream.Recv()
+		for err == nil {
+			var file *pufs_pb.FilesResponse
 
-			if err == io.EOF {
-				log.Println("All files read, awaiting..")
-				stream = nil
-				break
+			if file, err = stream.Recv(); err == nil {
+				log.Printf("Pushing file.. Filename: %v", file.Files.Filename)
+				c.announced(file.Files.Filename, file.Files.IpfsHash)
 			}
+		}
 
-			if err != nil {
-				log.Println("error encountered, retrying..")
-				stream = nil
-				break
-			}
-			log.Printf("Pushing file.. Filename: %v", file.Files.Filename)
+		if ctx.Err() != nil {
+			return
+		}
 
-			del := <-c.FileDeleted
-			fa := <-c.FileUploadedInApp
+		log.Printf("File stream ended, retrying in 5 seconds. Error: %v", err)
 
-			if del {
-				c.FileDeleted <- false
-			} else if fa {
-				c.FileUploadedInApp <- false
-			} else {
-				c.FileUpload <- file.Files.Filename
-			}
+		select {
+		case <-time.After(time.Second * 5):
+		case <-ctx.Done():
+			return
 		}
 	}
 }
 
-func (c *IpfsClient) ChunkFile(fileName string) bool {
-	ctx, cancel := context.WithCancel(context.Background())
+func (c *IpfsClient) ChunkFile(ctx context.Context, fileName string) bool {
+	size, err := c.FileSize(ctx, fileName)
+
+	if err != nil {
+		log.Printf("Could not get file size. Error: %v", err)
+
+		return false
+	}
+
+	return size >= (2 << 20)
+}
+
+// FileSize returns the size of a file as reported by the server.
+func (c *IpfsClient) FileSize(ctx context.Context, fileName string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
 	defer cancel()
 
 	size, err := c.Client.FileSize(ctx, &pufs_pb.FileSizeRequest{FileName: fileName})
 
 	if err != nil {
-		log.Printf("Could not get file size. Error: %v", err)
+		return 0, err
 	}
 
-	return size.FileSize >= (2 << 20)
+	return size.FileSize, nil
 }
 
-func (c *IpfsClient) UnsubscribeClient() {
-	ctx, cancel := context.WithCancel(context.Background())
+func (c *IpfsClient) UnsubscribeClient(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
 	defer cancel()
 
 	c.Client.UnsubscribeFileStream(ctx, &pufs_pb.FilesRequest{Id: c.Id})
 }
 
+// Transfer deadlines scale with the amount of data being moved.
+// The configured minimum throughput is the slowest link we are willing to wait on, with the request timeout acting as a floor.
+func (c *IpfsClient) transferContext(ctx context.Context, size int64) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, c.transferTimeout(size))
+}
+
+func (c *IpfsClient) transferTimeout(size int64) time.Duration {
+	throughput := defaultMinThroughputKBps
+
+	if c.Settings != nil && c.Settings.MinThroughputKBps > 0 {
+		throughput = c.Settings.MinThroughputKBps
+	}
+
+	if size <= 0 {
+		return requestTimeout
+	}
+
+	return requestTimeout + time.Duration(size/int64(throughput<<10))*time.Second
+}
+
 func (c *IpfsClient) fileExists(fileName string) bool {
 	var void Empty
 
@@ -506,6 +1090,13 @@ func (c *IpfsClient) fileExists(fileName string) bool {
 }
 
 func (c *IpfsClient) createUniqueFileName(fileName string) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.uniqueFileName(fileName)
+}
+
+func (c *IpfsClient) uniqueFileName(fileName string) string {
 	if !c.fileExists(fileName) {
 		return fileName
 	} else {
@@ -516,7 +1107,7 @@ func (c *IpfsClient) createUniqueFileName(fileName string) string {
 		fileName = fmt.Sprintf("%v%v%v", file, c.nameInt, extension)
 
 		if c.fileExists(fileName) {
-			return c.createUniqueFileName(fileName)
+			return c.uniqueFileName(fileName)
 		} else {
 			c.nameInt = 0
 			return fileName
@@ -524,30 +1115,52 @@ func (c *IpfsClient) createUniqueFileName(fileName string) string {
 	}
 }
 
-func (c *IpfsClient) Download(fileName string) error {
-	var err error
-	if c.ChunkFile(fileName) {
-		err = c.DownloadCappedFile(fileName, c.Settings.DownloadPath)
-	} else {
-		err = c.DownloadFile(fileName, c.Settings.DownloadPath)
-	}
+func (c *IpfsClient) Download(ctx context.Context, fileName string) error {
+	err := c.DownloadTo(ctx, fileName, c.Settings.DownloadPath)
 
 	if err != nil {
-		notifications.SendErrorNotification(fmt.Sprintf("Error downloading file: %v", fileName))
+		c.NotifyError(fmt.Sprintf("Error downloading file: %v", fileName))
 		return err
 	} else {
-		notifications.SendSuccessNotification(fmt.Sprintf("File %v downloaded", fileName))
+		c.NotifySuccess(fmt.Sprintf("File %v downloaded", fileName))
 		return nil
 	}
 }
 
+// DownloadTo downloads a file into the folder at path, retrying interrupted streams.
+func (c *IpfsClient) DownloadTo(ctx context.Context, fileName, path string) error {
+	var err error
+	if c.ChunkFile(ctx, fileName) {
+		// The journal allows a broken stream to pick up from the last confirmed chunk, retry a few times before giving up.
+		for attempt := 1; ; attempt++ {
+			err = c.DownloadCappedFile(ctx, fileName, path)
+
+			if err == nil || attempt >= transferAttempts || !retryableError(err) {
+				break
+			}
+
+			log.Printf("Download of %v interrupted, resuming. Attempt: %v Error: %v", fileName, attempt, err)
+
+			select {
+			case <-time.After(time.Duration(attempt) * 2 * time.Second):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	} else {
+		err = c.DownloadFile(ctx, fileName, path)
+	}
+
+	return err
+}
+
 // Returns byte array of file content. Uses the file path for downloaded files.
 // Validates a given file is found with that name. (Note: this should be calld after "Download" has ran successfully)
 func (c *IpfsClient) DownloadedFileContent(fileName string) (*[]byte, error) {
-	fileData, err := os.ReadFile(fmt.Sprintf("%v/%v", c.Settings.DownloadPath, fileName))
+	fileData, err := os.ReadFile(filepath.Join(c.Settings.DownloadPath, c.LocalFileName(fileName)))
 
 	if err != nil && os.IsNotExist(err) {
-		notifications.SendErrorNotification("File not found locally, try to Download the file first")
+		c.NotifyError("File not found locally, try to Download the file first")
 
 		return nil, err
 	}
@@ -556,51 +1169,115 @@ func (c *IpfsClient) DownloadedFileContent(fileName string) (*[]byte, error) {
 		return nil, err
 	}
 
-	if c.validFileType(fileData) {
-		return &fileData, nil
-	} else {
-		notifications.SendErrorNotification("Can only edit non binary files!")
+	mimeType := sniff.Detect(c.DisplayName(fileName), fileData)
 
-		return nil, errors.New("can only edit non binary files")
-	}
-}
+	if !sniff.IsText(mimeType) {
+		c.NotifyError(fmt.Sprintf("Can only edit text files, %v is %v", c.DisplayName(fileName), sniff.MediaType(mimeType)))
 
-func (c *IpfsClient) validFileType(stream []byte) bool {
-	// If there is not stream of data and we got this far.. probably a bigger issue elsewhere.
-	if stream == nil {
-		return false
+		return nil, fmt.Errorf("can only edit text files, file is %v", mimeType)
 	}
 
-	// If data is some abstract stream of text, still encrypt.
-	// 4 is the magic byte number which will hold the header content denoting what the file is.
-	// i.e. the first 4 bytes would be ELF, EXE etc..
-	if len(stream) < 4 {
-		return true
+	// Text is handed to the editor as UTF-8.
+	text, err := sniff.ToUTF8(fileData, sniff.Charset(mimeType))
+
+	if err != nil {
+		return nil, err
 	}
 
-	// Parse first 4 bytes
-	fileHeader := stream[1:4]
+	return &text, nil
+}
 
-	for _, t := range c.InvalidFileTypes {
-		if t == string(fileHeader) {
-			return false
-		}
+// Executables are never encrypted, whatever the encryption settings are.
+func encryptable(mimeType string) bool {
+	return !sniff.IsExecutable(mimeType)
+}
+
+// Sniffs the content type of a file on disk.
+func detectFile(fileName, path string) string {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return ""
 	}
 
-	return true
+	defer file.Close()
+
+	head := make([]byte, sniff.HeadSize)
+	n, _ := io.ReadFull(file, head)
+
+	return sniff.Detect(fileName, head[:n])
 }
 
 func (c *IpfsClient) SaveFileMetadata(data FileData) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	fileName := data.FileName
 	c.FileMetadata[fileName] = FileData{
 		FileName:   fileName,
 		FileSize:   data.FileSize,
 		IpfsHash:   data.IpfsHash,
 		UploadedAt: data.UploadedAt,
+		Checksum:   data.Checksum,
+		LinkTarget: data.LinkTarget,
+		Encryption: data.Encryption,
+		KeyId:      data.KeyId,
+		MimeType:   data.MimeType,
+
+		IpfsHashVerified: data.IpfsHashVerified,
+	}
+
+	c.persistMetadata()
+}
+
+// Compares the IPFS hash reported by the server against the hash computed from the data we sent or received.
+// The SHA-256 checksum already guards the file content. A mismatch here means the server's claim about where the data lives cannot be trusted, so the user is warned rather than the transfer failed.
+func (c *IpfsClient) checkIpfsHash(fileName, reported, computed string) {
+	reported = cid.Normalize(reported)
+
+	if reported == "" {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	v, ok := c.FileMetadata[fileName]
+
+	if !ok {
+		return
+	}
+
+	v.IpfsHashVerified = reported == computed
+
+	if !v.IpfsHashVerified {
+		log.Printf("IPFS hash mismatch for %v. Server reported: %v Computed: %v", fileName, reported, computed)
+		c.NotifyError(fmt.Sprintf("IPFS hash of %v does not match its content", fileName))
+	}
+
+	c.FileMetadata[fileName] = v
+	c.persistMetadata()
+}
+
+// Records the verified checksum and content type of a downloaded file and how it was encrypted.
+func (c *IpfsClient) saveDownload(fileName, checksum, mimeType string, info payloadInfo) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if v, ok := c.FileMetadata[fileName]; ok {
+		v.Checksum = checksum
+		v.MimeType = mimeType
+		v.Encryption = info.Encryption
+		v.KeyId = info.KeyId
+		c.FileMetadata[fileName] = v
+		c.persistMetadata()
 	}
 }
 
 func (c *IpfsClient) GetFileMetadata(fileName string) *FileData {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
 	if v, ok := c.FileMetadata[fileName]; ok {
 		return &v
 	} else {
@@ -609,5 +1286,26 @@ func (c *IpfsClient) GetFileMetadata(fileName string) *FileData {
 }
 
 func (c *IpfsClient) DeleteFileMetadata(fileName string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	delete(c.FileMetadata, fileName)
+	c.persistMetadata()
+}
+
+// Wraps errors raised while writing downloaded data to disk.
+var errWrite = errors.New("error writing downloaded data to disk")
+
+// Errors caused by a broken connection are worth retrying, the journal keeps track of what already made it across.
+func retryableError(err error) bool {
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Aborted:
+		return true
+	}
+
+	return false
 }
@@ -0,0 +1,32 @@
+package pufs_client
+
+import (
+	"context"
+
+	"github.com/BitlyTwiser/throw/src/compression"
+)
+
+type compressionKey struct{}
+
+// WithCompression overrides the compression setting for uploads started with the returned context.
+func WithCompression(ctx context.Context, algorithm string) context.Context {
+	return context.WithValue(ctx, compressionKey{}, algorithm)
+}
+
+// Picks the compression algorithm for an upload, the head is the start of the file content.
+func (c *IpfsClient) compressionFor(ctx context.Context, fileName string, head []byte) string {
+	mode := c.Settings.Compression
+
+	if algorithm, ok := ctx.Value(compressionKey{}).(string); ok {
+		mode = algorithm
+	}
+
+	return compression.Choose(mode, fileName, head)
+}
+
+// Adapts a function to an io.Writer.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
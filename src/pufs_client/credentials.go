@@ -0,0 +1,35 @@
+package pufs_client
+
+import (
+	"context"
+	"encoding/base64"
+
+	"github.com/BitlyTwiser/throw/src/settings"
+)
+
+// HostCredentials attaches the credentials held within the vault to every request sent to the host.
+// They are read on every request, so credentials changed within the settings apply without reconnecting.
+type HostCredentials struct {
+	Settings *settings.Settings
+}
+
+func (h HostCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	s := h.Settings
+
+	if s.AuthToken != "" {
+		return map[string]string{"authorization": "Bearer " + s.AuthToken}, nil
+	}
+
+	if s.HostUsername != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(s.HostUsername + ":" + s.HostPassword))
+
+		return map[string]string{"authorization": "Basic " + credentials}, nil
+	}
+
+	return nil, nil
+}
+
+// Note: the connection to the host is not encrypted yet, credentials are sent as is.
+func (h HostCredentials) RequireTransportSecurity() bool {
+	return false
+}
@@ -0,0 +1,306 @@
+package pufs_client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	pufs_pb "github.com/BitlyTwiser/pufs-server/proto"
+	"github.com/BitlyTwiser/throw/src/cid"
+	"github.com/BitlyTwiser/throw/src/compression"
+	"github.com/BitlyTwiser/throw/src/sniff"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Link objects stand in for a duplicate upload. They hold the name of the file with the same content and are resolved transparently on download.
+// A link is the magic, a version byte, the length of the target name as two bytes, the target name, then the SHA-256 of everything before it.
+// Stored data is only taken for a link when all of it matches, so a file that happens to start with the magic is never mistaken for one.
+const (
+	linkMagic   = "\x00THRWLINK\x00"
+	linkVersion = 2
+)
+
+type linkResolvingKey struct{}
+
+type replaceKey struct{}
+
+// WithReplace marks deletes started with the returned context as the first half of replacing a file, it is uploaded again under the same name.
+// Links to a replaced file keep working, so the delete goes ahead even when other files link to it.
+func WithReplace(ctx context.Context) context.Context {
+	return context.WithValue(ctx, replaceKey{}, true)
+}
+
+// Duplicate describes an existing remote file with the same content as a local file.
+type Duplicate struct {
+	FileName string
+	Checksum string
+	// How the duplicate was found, either by "checksum" or "ipfs hash"
+	MatchedBy string
+}
+
+// FindDuplicate hashes the file at path and compares it against every file with a known checksum or IPFS hash.
+// A nil duplicate is returned when the content is unique.
+func (c *IpfsClient) FindDuplicate(path string) (*Duplicate, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	// The IPFS hash is only predictable for files stored as is. Encrypted files use a fresh nonce on every upload.
+	header := make([]byte, sniff.HeadSize)
+	n, _ := io.ReadFull(file, header)
+	encrypted := c.Settings.Encrypted && encryptable(sniff.Detect(filepath.Base(path), header[:n]))
+	compressed := compression.Choose(c.Settings.Compression, filepath.Base(path), header[:n]) != compression.None
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	checksum := sha256.New()
+	ipfsHash := cid.NewBuilder(cid.V0)
+
+	if _, err := io.Copy(io.MultiWriter(checksum, ipfsHash), file); err != nil {
+		return nil, err
+	}
+
+	sum := hex.EncodeToString(checksum.Sum(nil))
+	storedHash := ipfsHash.Sum()
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	for _, name := range c.Files {
+		m, ok := c.FileMetadata[name]
+
+		if !ok || m.LinkTarget != "" {
+			continue
+		}
+
+		if m.Checksum != "" && m.Checksum == sum {
+			return &Duplicate{FileName: name, Checksum: sum, MatchedBy: "checksum"}, nil
+		}
+
+		if !encrypted && !compressed && m.IpfsHash != "" && m.IpfsHash == storedHash {
+			return &Duplicate{FileName: name, Checksum: sum, MatchedBy: "ipfs hash"}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// LinkFile stores fileName as a link to an existing file instead of uploading the same content again.
+func (c *IpfsClient) LinkFile(ctx context.Context, fileName, target string) error {
+	if m := c.GetFileMetadata(target); m != nil && m.LinkTarget != "" {
+		target = m.LinkTarget
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	fileName, err := c.remoteFileName(ctx, fileName)
+
+	if err != nil {
+		return err
+	}
+
+	data, err := linkObject(target)
+
+	if err != nil {
+		return err
+	}
+
+	file := &pufs_pb.File{
+		Filename:   fileName,
+		FileSize:   storedFileSize(fileName, int64(len(data))),
+		IpfsHash:   cid.Bytes(data, cid.V0),
+		UploadedAt: timestamppb.New(time.Now()),
+	}
+
+	resp, err := c.Client.UploadFile(ctx, &pufs_pb.UploadFileRequest{FileData: data, FileMetadata: file})
+
+	if err != nil {
+		return err
+	}
+
+	if !resp.Sucessful {
+		return errors.New("something went wrong linking file")
+	}
+
+	var checksum, mimeType string
+
+	if m := c.GetFileMetadata(target); m != nil {
+		checksum = m.Checksum
+		mimeType = m.MimeType
+	}
+
+	c.SaveFileMetadata(FileData{
+		FileName:   fileName,
+		FileSize:   file.FileSize,
+		IpfsHash:   file.IpfsHash,
+		UploadedAt: time.Now().String(),
+		Checksum:   checksum,
+		LinkTarget: target,
+		MimeType:   mimeType,
+	})
+
+	c.uploaded(fileName)
+
+	return nil
+}
+
+// Encodes the link object pointing at target.
+func linkObject(target string) ([]byte, error) {
+	if len(target) > math.MaxUint16 {
+		return nil, fmt.Errorf("%v is too long a name to link to", target)
+	}
+
+	data := append([]byte(linkMagic), linkVersion, byte(len(target)>>8), byte(len(target)))
+	data = append(data, target...)
+	sum := sha256.Sum256(data)
+
+	return append(data, sum[:]...), nil
+}
+
+// Returns the target of a link object.
+func linkTarget(data []byte) (string, bool) {
+	header := len(linkMagic) + 3
+
+	if len(data) < header+sha256.Size || !bytes.HasPrefix(data, []byte(linkMagic)) || data[len(linkMagic)] != linkVersion {
+		return "", false
+	}
+
+	n := int(data[header-2])<<8 | int(data[header-1])
+
+	if len(data) != header+n+sha256.Size {
+		return "", false
+	}
+
+	if sum := sha256.Sum256(data[:header+n]); !bytes.Equal(sum[:], data[header+n:]) {
+		return "", false
+	}
+
+	return string(data[header : header+n]), true
+}
+
+// Returns the stored files linking to target.
+// Links made by this client are known from metadata. Links made by other clients are found by reading every stored object of the size of a link to target, they are small and rare.
+func (c *IpfsClient) linksTo(ctx context.Context, target string) ([]string, error) {
+	files, err := c.RemoteFiles(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not check for links to %v. Error: %w", c.DisplayName(target), err)
+	}
+
+	linkSize := int64(len(linkMagic) + 3 + len(target) + sha256.Size)
+	var links []string
+
+	for _, f := range files {
+		if f.Name == target {
+			continue
+		}
+
+		if m := c.GetFileMetadata(f.Name); m != nil && m.LinkTarget != "" {
+			if m.LinkTarget == target {
+				links = append(links, f.Name)
+			}
+
+			continue
+		}
+
+		if f.Size != storedFileSize(f.Name, linkSize) {
+			continue
+		}
+
+		data, err := c.storedObject(ctx, f.Name)
+
+		if err != nil {
+			return nil, fmt.Errorf("could not check if %v links to %v. Error: %w", c.DisplayName(f.Name), c.DisplayName(target), err)
+		}
+
+		if t, ok := linkTarget(data); ok && t == target {
+			links = append(links, f.Name)
+		}
+	}
+
+	sort.Strings(links)
+
+	return links, nil
+}
+
+// Returns a small stored object as it is held by the server.
+func (c *IpfsClient) storedObject(ctx context.Context, fileName string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	resp, err := c.Client.DownloadUncappedFile(ctx, &pufs_pb.DownloadFileRequest{FileName: fileName})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.FileData, nil
+}
+
+// Downloads the target of a link and stores it under the name of the link.
+func (c *IpfsClient) downloadLink(ctx context.Context, fileName, target, path string) error {
+	if ctx.Value(linkResolvingKey{}) != nil {
+		return fmt.Errorf("%v links to another link, links cannot be chained", fileName)
+	}
+
+	log.Printf("%v is a duplicate of %v, downloading %v", fileName, target, target)
+
+	ctx = context.WithValue(ctx, linkResolvingKey{}, true)
+
+	// Download into a scratch folder within path, so the final rename never crosses file systems.
+	scratch, err := os.MkdirTemp(path, ".throw-link-*")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(scratch)
+
+	if c.ChunkFile(ctx, target) {
+		err = c.DownloadCappedFile(ctx, target, scratch)
+	} else {
+		err = c.DownloadFile(ctx, target, scratch)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(filepath.Join(scratch, c.LocalFileName(target)), filepath.Join(path, c.LocalFileName(fileName))); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if v, ok := c.FileMetadata[fileName]; ok {
+		v.LinkTarget = target
+
+		if t, ok := c.FileMetadata[target]; ok {
+			v.Checksum = t.Checksum
+			v.MimeType = t.MimeType
+		}
+
+		c.FileMetadata[fileName] = v
+		c.persistMetadata()
+	}
+
+	return nil
+}
@@ -0,0 +1,350 @@
+package pufs_client
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/BitlyTwiser/throw/src/envelope"
+	"github.com/BitlyTwiser/throw/src/kdf"
+	"github.com/BitlyTwiser/throw/src/keyring"
+	"github.com/BitlyTwiser/throw/src/recipient"
+)
+
+// How a stored object is encrypted, recorded within FileData.Encryption.
+const (
+	EncryptionNone     = ""
+	EncryptionEnvelope = "envelope"
+	// Whole file tinycrypt encryption, used before envelopes existed.
+	EncryptionLegacy = "tinycrypt"
+	// Envelope with a random key wrapped for the public keys of its recipients.
+	EncryptionRecipients = "recipients"
+)
+
+type encryptionKeyKey struct{}
+
+type recipientsKey struct{}
+
+// WithEncryptionKey encrypts uploads started with the returned context under the given key, whatever the encryption settings are.
+func WithEncryptionKey(ctx context.Context, keyId string) context.Context {
+	return context.WithValue(ctx, encryptionKeyKey{}, keyId)
+}
+
+// WithRecipients encrypts uploads started with the returned context for the given public keys, whatever the encryption settings are.
+// The public key of this user is added, so the uploader can read the files as well.
+func WithRecipients(ctx context.Context, publicKeys []string) context.Context {
+	return context.WithValue(ctx, recipientsKey{}, publicKeys)
+}
+
+// Reports how an upload is encrypted and under which key.
+func (c *IpfsClient) encryptionFor(ctx context.Context, validFile bool) (string, string) {
+	if publicKeys, ok := ctx.Value(recipientsKey{}).([]string); ok && len(publicKeys) > 0 {
+		return EncryptionRecipients, keyring.DefaultKeyId
+	}
+
+	if keyId, ok := ctx.Value(encryptionKeyKey{}).(string); ok {
+		return EncryptionEnvelope, keyId
+	}
+
+	if c.Settings.Encrypted && validFile {
+		return EncryptionEnvelope, c.Settings.Keyring.Active
+	}
+
+	return EncryptionNone, keyring.DefaultKeyId
+}
+
+// Returns the password of a key within the keyring. The default key is the password within the settings.
+func (c *IpfsClient) password(keyId string) (string, error) {
+	if keyId == keyring.DefaultKeyId {
+		if c.Settings.Password == "" {
+			return "", fmt.Errorf("no encryption password is set: %w", ErrNoKey)
+		}
+
+		return c.Settings.Password, nil
+	}
+
+	key := c.Settings.Keyring.Find(keyId)
+
+	if key == nil {
+		return "", fmt.Errorf("key %v is not within the keyring: %w", keyId, ErrNoKey)
+	}
+
+	return key.Password, nil
+}
+
+// Returns the header and key used to encrypt a new file. Every file gets a fresh salt, so no two files share a key.
+func (c *IpfsClient) newEnvelope(ctx context.Context, keyId string) (*envelope.Header, []byte, error) {
+	if publicKeys, ok := ctx.Value(recipientsKey{}).([]string); ok && len(publicKeys) > 0 {
+		return c.newRecipientEnvelope(publicKeys)
+	}
+
+	password, err := c.password(keyId)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	params := c.Settings.KDF.OrDefault()
+	salt, err := kdf.NewSalt()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := kdf.Derive(password, salt, params)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h := &envelope.Header{
+		Version:   envelope.Version,
+		Cipher:    envelope.CipherAES256GCM,
+		KDF:       params.Id(),
+		KDFParams: params.Marshal(),
+		Salt:      salt,
+		KeyId:     keyId,
+	}
+
+	return h, key, nil
+}
+
+// Wraps a random file key for every recipient and this user.
+func (c *IpfsClient) newRecipientEnvelope(publicKeys []string) (*envelope.Header, []byte, error) {
+	var keys [][]byte
+
+	for _, publicKey := range publicKeys {
+		key, err := recipient.ParsePublicKey(publicKey)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid public key %v. Error: %v", publicKey, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	// The uploader must be able to open the file too, it is never sealed for the recipients alone.
+	identity, err := c.identity()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := recipient.ParsePublicKey(identity.PublicKey())
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys = append(keys, key)
+
+	fileKey, err := recipient.NewFileKey()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stanzas, err := recipient.Wrap(fileKey, keys)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h := &envelope.Header{
+		Version:   envelope.Version,
+		Cipher:    envelope.CipherAES256GCM,
+		KDF:       envelope.KDFX25519,
+		KDFParams: stanzas,
+	}
+
+	return h, fileKey, nil
+}
+
+// Returns the key pair of this user held within the vault.
+func (c *IpfsClient) identity() (*recipient.Identity, error) {
+	if c.Settings.Identity == "" {
+		return nil, fmt.Errorf("no key pair has been generated, generate one within the address book: %w", ErrNoKey)
+	}
+
+	return recipient.ParseIdentity(c.Settings.Identity)
+}
+
+// Derives the key of an envelope from the keyring entry named by the header, or unwraps it with the private key of this user.
+func (c *IpfsClient) fileKey(h *envelope.Header) ([]byte, error) {
+	if h.KDF == envelope.KDFX25519 {
+		identity, err := c.identity()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return identity.Unwrap(h.KDFParams)
+	}
+
+	password, err := c.password(h.KeyId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch h.KDF {
+	case envelope.KDFSHA256:
+		sum := sha256.Sum256([]byte(password))
+
+		return sum[:], nil
+	case envelope.KDFArgon2id, envelope.KDFScrypt:
+		// The parameters come from the file, not the settings. Files keep working after the cost is raised.
+		params, err := kdf.Unmarshal(h.KDF, h.KDFParams)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(h.Salt) == 0 {
+			return nil, errors.New("encrypted file has no key derivation salt")
+		}
+
+		return kdf.Derive(password, h.Salt, params)
+	}
+
+	return nil, fmt.Errorf("unsupported key derivation function: %v", h.KDF)
+}
+
+// Key of files written by tinycrypt before envelopes existed.
+func (c *IpfsClient) legacyKey() []byte {
+	sum := sha256.Sum256([]byte(c.Settings.Password))
+
+	return sum[:]
+}
+
+// Describes how a downloaded object was encrypted.
+type payloadInfo struct {
+	Encryption string
+	KeyId      string
+	key        []byte
+}
+
+func envelopeInfo(h *envelope.Header, key []byte) payloadInfo {
+	if h.KDF == envelope.KDFX25519 {
+		return payloadInfo{Encryption: EncryptionRecipients, key: key}
+	}
+
+	return payloadInfo{Encryption: EncryptionEnvelope, KeyId: h.KeyId, key: key}
+}
+
+// Decrypts a stored object held in memory.
+// Envelopes describe themselves. Older objects were encrypted by tinycrypt as a whole, the checksum trailer tells if that was the case.
+// Objects without a trailer, plaintext stored as is or from before checksum trailers, fall back to the current settings.
+func (c *IpfsClient) openPayload(body []byte, hasTrailer bool) ([]byte, payloadInfo, error) {
+	if envelope.IsEnvelope(body) {
+		var header *envelope.Header
+
+		plain, key, err := envelope.Open(body, func(h *envelope.Header) ([]byte, error) {
+			header = h
+
+			return c.fileKey(h)
+		})
+
+		if err != nil {
+			return nil, payloadInfo{}, err
+		}
+
+		return plain, envelopeInfo(header, key), nil
+	}
+
+	if !hasTrailer && !c.Settings.Encrypted {
+		return body, payloadInfo{}, nil
+	}
+
+	// tinycrypt prepends the nonce to the ciphertext, the same layout as a sealed block.
+	plain, err := envelope.OpenBlock(c.legacyKey(), body)
+
+	if err != nil {
+		// Binary files were never encrypted, even with encryption turned on.
+		if !hasTrailer {
+			log.Printf("Could not decrypt legacy file, treating it as plaintext. Error: %v", err)
+
+			return body, payloadInfo{}, nil
+		}
+
+		return nil, payloadInfo{}, err
+	}
+
+	return plain, payloadInfo{Encryption: EncryptionLegacy, key: c.legacyKey()}, nil
+}
+
+// Decodes a stored object written to it in pieces of any size, writing the plaintext to w.
+// The first bytes tell if the object is an envelope, anything else is passed through as is.
+// Note: streamed legacy objects were encrypted per transport chunk, the chunk boundaries are not recoverable so they are not decrypted.
+type payloadWriter struct {
+	client   *IpfsClient
+	w        io.Writer
+	head     []byte
+	out      io.Writer
+	envelope *envelope.DecryptWriter
+}
+
+func (c *IpfsClient) newPayloadWriter(w io.Writer) *payloadWriter {
+	return &payloadWriter{client: c, w: w}
+}
+
+func (p *payloadWriter) Write(data []byte) (int, error) {
+	if p.out == nil {
+		p.head = append(p.head, data...)
+
+		if len(p.head) < len(envelope.Magic) {
+			return len(data), nil
+		}
+
+		if err := p.start(); err != nil {
+			return 0, err
+		}
+
+		return len(data), nil
+	}
+
+	return p.out.Write(data)
+}
+
+// Picks the decoder and hands it the bytes held back so far.
+func (p *payloadWriter) start() error {
+	p.out = p.w
+
+	if envelope.IsEnvelope(p.head) {
+		p.envelope = envelope.NewDecryptWriter(p.w, p.client.fileKey)
+		p.out = p.envelope
+	}
+
+	head := p.head
+	p.head = nil
+
+	_, err := p.out.Write(head)
+
+	return err
+}
+
+// Info describes the encryption of the object, known once the header has been written.
+func (p *payloadWriter) Info() payloadInfo {
+	if p.envelope == nil || p.envelope.Header == nil {
+		return payloadInfo{}
+	}
+
+	return envelopeInfo(p.envelope.Header, p.envelope.Key())
+}
+
+func (p *payloadWriter) Close() error {
+	if p.out == nil {
+		if err := p.start(); err != nil {
+			return err
+		}
+	}
+
+	if p.envelope != nil {
+		return p.envelope.Close()
+	}
+
+	return nil
+}
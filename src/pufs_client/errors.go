@@ -0,0 +1,68 @@
+package pufs_client
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Errors to match with errors.Is. Uploads blocked by the upload policy return a *policy.Violation, corrupt downloads a *ChecksumError.
+var (
+	// No stored file has the name asked for.
+	ErrNotFound = errors.New("file not found")
+	// The host refused the credentials sent, or asked for credentials when none were set.
+	ErrUnauthenticated = errors.New("the host refused the credentials")
+	// The host could not be reached.
+	ErrUnavailable = errors.New("the host is unavailable")
+	// The key a file is encrypted with, or the key new files are to be encrypted with, is not held by this client.
+	ErrNoKey = errors.New("the key is not available")
+)
+
+// RequestError is returned when a request to the host fails. errors.Is matches it against ErrNotFound, ErrUnauthenticated and ErrUnavailable by the gRPC status of the failure.
+type RequestError struct {
+	Op       string
+	FileName string
+	Err      error
+}
+
+func (e *RequestError) Error() string {
+	if e.FileName == "" {
+		return fmt.Sprintf("%v: %v", e.Op, e.Err)
+	}
+
+	return fmt.Sprintf("%v %v: %v", e.Op, e.FileName, e.Err)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+func (e *RequestError) Is(target error) bool {
+	var grpcErr interface{ GRPCStatus() *status.Status }
+
+	if !errors.As(e.Err, &grpcErr) {
+		return false
+	}
+
+	switch grpcErr.GRPCStatus().Code() {
+	case codes.NotFound:
+		return target == ErrNotFound
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return target == ErrUnauthenticated
+	case codes.Unavailable:
+		return target == ErrUnavailable
+	}
+
+	return false
+}
+
+// Wraps err within a *RequestError, nil stays nil.
+func requestError(op, fileName string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &RequestError{Op: op, FileName: fileName, Err: err}
+}
@@ -0,0 +1,124 @@
+package pufs_client
+
+import (
+	"log"
+
+	"github.com/BitlyTwiser/throw/src/cid"
+)
+
+// EventKind says what happened to a file.
+type EventKind int
+
+const (
+	// The file was uploaded by this client.
+	EventUploaded EventKind = iota
+	// The file was deleted by this client.
+	EventDeleted
+	// The server announced a change made by another client.
+	EventRemoteChange
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventUploaded:
+		return "uploaded"
+	case EventDeleted:
+		return "deleted"
+	case EventRemoteChange:
+		return "remote change"
+	}
+
+	return "unknown"
+}
+
+// Event describes a change to a stored file. FileName is the stored name.
+type Event struct {
+	Kind     EventKind
+	FileName string
+}
+
+// EventHandler is called with every event, on the goroutine that caused it. Handlers must not block.
+type EventHandler func(Event)
+
+// OnEvent adds a handler called with every upload, delete and remote change seen by the client.
+func (c *IpfsClient) OnEvent(handler EventHandler) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.eventHandlers = append(c.eventHandlers, handler)
+}
+
+func (c *IpfsClient) emit(event Event) {
+	c.mutex.RLock()
+	handlers := c.eventHandlers
+	c.mutex.RUnlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// Records a file uploaded by this client. The file list is kept current here, the GUI refreshes from the events.
+func (c *IpfsClient) uploaded(fileName string) {
+	c.listFile(fileName)
+	c.emit(Event{Kind: EventUploaded, FileName: fileName})
+}
+
+func (c *IpfsClient) deleted(fileName string) {
+	c.mutex.Lock()
+
+	var files []string
+
+	for _, v := range c.Files {
+		if v != fileName {
+			files = append(files, v)
+		}
+	}
+
+	c.Files = files
+	c.mutex.Unlock()
+
+	c.emit(Event{Kind: EventDeleted, FileName: fileName})
+}
+
+// Records a file announced by the server event stream. Files uploaded by another client are added to the file list, those already listed are changes to known files.
+// A file announced under another IPFS hash than recorded was replaced, the checksum and link target recorded for its old content no longer apply.
+func (c *IpfsClient) announced(fileName, ipfsHash string) {
+	c.listFile(fileName)
+	c.forgetReplaced(fileName, cid.Normalize(ipfsHash))
+	c.publishRemoteChange(fileName)
+}
+
+func (c *IpfsClient) forgetReplaced(fileName, ipfsHash string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	m, ok := c.FileMetadata[fileName]
+
+	if !ok || ipfsHash == "" || m.IpfsHash == "" || m.IpfsHash == ipfsHash {
+		return
+	}
+
+	log.Printf("%v was replaced, its recorded checksum and link target are dropped", fileName)
+
+	m.IpfsHash = ipfsHash
+	m.Checksum = ""
+	m.LinkTarget = ""
+	m.IpfsHashVerified = false
+	c.FileMetadata[fileName] = m
+	c.persistMetadata()
+}
+
+// Adds a file to the file list unless it is listed. The server may announce an upload before the upload returns.
+func (c *IpfsClient) listFile(fileName string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, v := range c.Files {
+		if v == fileName {
+			return
+		}
+	}
+
+	c.Files = append(c.Files, fileName)
+}
@@ -0,0 +1,251 @@
+package pufs_client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	JournalUpload   = "upload"
+	JournalDownload = "download"
+	// Suffix used for downloads that have not finished yet. The file is renamed once all data is written.
+	partialSuffix = ".part"
+	// A journal is saved once this much data or time has passed since it was last saved, syncing and rewriting it for every chunk is slow.
+	checkpointBytes    = 16 << 20
+	checkpointInterval = 2 * time.Second
+)
+
+// TransferJournal is persisted to disk while a transfer is in flight so the transfer can be picked up after a crash or disconnect.
+// The checksum covers all bytes confirmed so far and is used to validate local data before resuming.
+type TransferJournal struct {
+	Direction       string
+	FileName        string
+	LocalPath       string
+	TotalSize       int64
+	ChunksConfirmed int64
+	BytesConfirmed  int64
+	Checksum        string
+	ModTime         int64
+	UpdatedAt       string
+	hash            hash.Hash
+	// Bytes confirmed when the journal was last saved, and when that was.
+	savedBytes int64
+	savedAt    time.Time
+}
+
+func journalDir() (string, error) {
+	cache, err := os.UserCacheDir()
+
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(cache, "throw", "journal")
+
+	return dir, os.MkdirAll(dir, 0700)
+}
+
+// Journals are keyed by direction and a key (the remote name for downloads, the local path for uploads)
+func journalPath(direction, key string) (string, error) {
+	dir, err := journalDir()
+
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(key))
+
+	return filepath.Join(dir, fmt.Sprintf("%v-%x.json", direction, sum[:8])), nil
+}
+
+func NewTransferJournal(direction, fileName, localPath string, totalSize int64) *TransferJournal {
+	return &TransferJournal{
+		Direction: direction,
+		FileName:  fileName,
+		LocalPath: localPath,
+		TotalSize: totalSize,
+		hash:      sha256.New(),
+	}
+}
+
+// LoadJournal returns the journal for the given key. A nil journal is returned if no transfer was interrupted.
+func LoadJournal(direction, key string) (*TransferJournal, error) {
+	path, err := journalPath(direction, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+
+	if err != nil && os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	journal := &TransferJournal{}
+
+	if err := json.Unmarshal(data, journal); err != nil {
+		return nil, err
+	}
+
+	return journal, nil
+}
+
+// PendingJournals returns every transfer that has not completed.
+func PendingJournals() ([]*TransferJournal, error) {
+	dir, err := journalDir()
+
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var journals []*TransferJournal
+
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+
+		if err != nil {
+			return nil, err
+		}
+
+		journal := &TransferJournal{}
+
+		if err := json.Unmarshal(data, journal); err != nil {
+			continue
+		}
+
+		journals = append(journals, journal)
+	}
+
+	return journals, nil
+}
+
+func (j *TransferJournal) key() string {
+	if j.Direction == JournalUpload {
+		return j.LocalPath
+	}
+
+	return j.FileName
+}
+
+// Save writes the journal to a temporary file then renames, a crash mid write will never leave a torn journal behind.
+func (j *TransferJournal) Save() error {
+	path, err := journalPath(j.Direction, j.key())
+
+	if err != nil {
+		return err
+	}
+
+	j.savedBytes = j.BytesConfirmed
+	j.savedAt = time.Now()
+	j.UpdatedAt = j.savedAt.Format(time.UnixDate)
+
+	data, err := json.MarshalIndent(j, "", "")
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+func (j *TransferJournal) Remove() error {
+	path, err := journalPath(j.Direction, j.key())
+
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Confirm records a chunk of data as safely transferred. Nothing is written to disk, see Due.
+func (j *TransferJournal) Confirm(data []byte) {
+	if j.hash == nil {
+		j.hash = sha256.New()
+	}
+
+	j.hash.Write(data)
+	j.ChunksConfirmed++
+	j.BytesConfirmed += int64(len(data))
+	j.Checksum = hex.EncodeToString(j.hash.Sum(nil))
+}
+
+// Due reports if enough data or time has passed since the journal was last saved for it to be saved again.
+// An interrupted transfer continues from the last save, the data confirmed after it is transferred again.
+func (j *TransferJournal) Due() bool {
+	return j.BytesConfirmed-j.savedBytes >= checkpointBytes || time.Since(j.savedAt) >= checkpointInterval
+}
+
+// Reset clears progress when previously confirmed data can no longer be trusted.
+func (j *TransferJournal) Reset() {
+	j.ChunksConfirmed = 0
+	j.BytesConfirmed = 0
+	j.Checksum = ""
+	j.hash = sha256.New()
+}
+
+// Verify re-hashes the first BytesConfirmed bytes of the local file and compares the result against the journal.
+// On success the running checksum continues from the confirmed data.
+func (j *TransferJournal) Verify(path string) error {
+	if j.BytesConfirmed == 0 {
+		j.hash = sha256.New()
+
+		return nil
+	}
+
+	file, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	h := sha256.New()
+
+	if _, err := io.CopyN(h, file, j.BytesConfirmed); err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(h.Sum(nil)) != j.Checksum {
+		return errors.New("local data does not match the transfer journal")
+	}
+
+	j.hash = h
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package pufs_client
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// The server only knows the name, size and IPFS hash of a file. Checksums and links are only known to the client, so they are kept on disk between runs.
+func metadataIndexPath() (string, error) {
+	cache, err := os.UserCacheDir()
+
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(cache, "throw")
+
+	return filepath.Join(dir, "metadata.json"), os.MkdirAll(dir, 0700)
+}
+
+// Reads the metadata stored by a previous run. A missing or unreadable index yields an empty index.
+func loadMetadataIndex() map[string]FileData {
+	index := make(map[string]FileData)
+	path, err := metadataIndexPath()
+
+	if err != nil {
+		log.Printf("Error locating metadata index. Error: %v", err)
+
+		return index
+	}
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading metadata index. Error: %v", err)
+		}
+
+		return index
+	}
+
+	if err := json.Unmarshal(data, &index); err != nil {
+		log.Printf("Error parsing metadata index. Error: %v", err)
+
+		return make(map[string]FileData)
+	}
+
+	return index
+}
+
+// Holds back metadata writes until the returned function is called, so an operation touching many files writes the index once.
+// Batches may nest, the index is written once the outermost ends.
+func (c *IpfsClient) batchMetadata() func() {
+	c.mutex.Lock()
+	c.metadataBatches++
+	c.mutex.Unlock()
+
+	return func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		c.metadataBatches--
+
+		if c.metadataBatches == 0 && c.metadataDirty {
+			c.persistMetadata()
+		}
+	}
+}
+
+// Writes FileMetadata to disk, or marks it to be written once the running batch ends. The caller must hold the mutex.
+func (c *IpfsClient) persistMetadata() {
+	if c.metadataBatches > 0 {
+		c.metadataDirty = true
+
+		return
+	}
+
+	c.metadataDirty = false
+
+	path, err := metadataIndexPath()
+
+	if err != nil {
+		log.Printf("Error locating metadata index. Error: %v", err)
+
+		return
+	}
+
+	data, err := json.Marshal(c.FileMetadata)
+
+	if err != nil {
+		log.Printf("Error encoding metadata index. Error: %v", err)
+
+		return
+	}
+
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		log.Printf("Error writing metadata index. Error: %v", err)
+
+		return
+	}
+
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Printf("Error writing metadata index. Error: %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package pufs_client
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/BitlyTwiser/throw/src/filename"
+	"github.com/BitlyTwiser/throw/src/sniff"
+)
+
+// Deriving the name key is slow, it is kept for as long as the password does not change.
+type nameCipher struct {
+	mutex    sync.Mutex
+	password string
+	cipher   *filename.Cipher
+}
+
+type exactNameKey struct{}
+
+type storedNameKey struct{}
+
+// WithExactName stores uploads started with the returned context under the given name as is, even when a file by that name is listed.
+// The file list is updated asynchronously, a file that was just deleted may still be within it.
+func WithExactName(ctx context.Context) context.Context {
+	return context.WithValue(ctx, exactNameKey{}, true)
+}
+
+// WithStoredName calls stored with the name uploads started with the returned context are stored under, once it is decided.
+func WithStoredName(ctx context.Context, stored func(string)) context.Context {
+	return context.WithValue(ctx, storedNameKey{}, stored)
+}
+
+func (c *IpfsClient) nameCipher() (*filename.Cipher, error) {
+	c.names.mutex.Lock()
+	defer c.names.mutex.Unlock()
+
+	if c.names.cipher != nil && c.names.password == c.Settings.Password {
+		return c.names.cipher, nil
+	}
+
+	cipher, err := filename.NewCipher(c.Settings.Password)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.names.password = c.Settings.Password
+	c.names.cipher = cipher
+
+	return cipher, nil
+}
+
+// Returns the name a new upload is stored under. Names are encrypted when the settings ask for it, a number is added to names already taken.
+func (c *IpfsClient) remoteFileName(ctx context.Context, fileName string) (string, error) {
+	name, err := c.newRemoteFileName(ctx, fileName)
+
+	if stored, ok := ctx.Value(storedNameKey{}).(func(string)); ok && err == nil {
+		stored(name)
+	}
+
+	return name, err
+}
+
+func (c *IpfsClient) newRemoteFileName(ctx context.Context, fileName string) (string, error) {
+	if exact, ok := ctx.Value(exactNameKey{}).(bool); ok && exact {
+		return fileName, nil
+	}
+
+	if !c.Settings.EncryptFileNames {
+		return c.createUniqueFileName(fileName), nil
+	}
+
+	cipher, err := c.nameCipher()
+
+	if err != nil {
+		return "", err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// Stored names are opaque, uniqueness is decided on the plain name.
+	extension := filepath.Ext(fileName)
+	candidate := fileName
+
+	for i := 1; c.fileExists(cipher.Encrypt(candidate)); i++ {
+		candidate = fmt.Sprintf("%v%v%v", strings.TrimSuffix(fileName, extension), i, extension)
+	}
+
+	return cipher.Encrypt(candidate), nil
+}
+
+// Returns the stored name of a new file shown as name, without a number added. Uploads started WithExactName are stored under it as is.
+func (c *IpfsClient) exactFileName(name string) (string, error) {
+	if !c.Settings.EncryptFileNames {
+		return name, nil
+	}
+
+	cipher, err := c.nameCipher()
+
+	if err != nil {
+		return "", err
+	}
+
+	return cipher.Encrypt(name), nil
+}
+
+// DisplayName returns the name shown for a stored file. Names that cannot be decrypted with the current password are shown as a placeholder.
+func (c *IpfsClient) DisplayName(stored string) string {
+	if !filename.IsEncrypted(stored) {
+		return stored
+	}
+
+	cipher, err := c.nameCipher()
+
+	if err != nil {
+		return filename.Placeholder(stored)
+	}
+
+	name, err := cipher.Decrypt(stored)
+
+	if err != nil {
+		return filename.Placeholder(stored)
+	}
+
+	return name
+}
+
+// LocalFileName returns the name a downloaded file is saved under. Names that cannot be decrypted get a short name derived from the stored name.
+// A decrypted name could hold a path, only its last element is used.
+func (c *IpfsClient) LocalFileName(stored string) string {
+	name := filepath.Base(c.DisplayName(stored))
+
+	if filename.IsEncrypted(stored) && (name == filename.Placeholder(stored) || name == "." || name == ".." || name == string(filepath.Separator)) {
+		sum := sha256.Sum256([]byte(stored))
+
+		return fmt.Sprintf("encrypted-%x", sum[:8])
+	}
+
+	return name
+}
+
+// Size reported to the server. The size of files with an encrypted name is rounded up to a power of two, so only its magnitude is visible.
+func storedFileSize(stored string, size int64) int64 {
+	if !filename.IsEncrypted(stored) || size <= 0 {
+		return size
+	}
+
+	rounded := int64(1)
+
+	for rounded < size {
+		rounded <<= 1
+	}
+
+	return rounded
+}
+
+// MimeType returns the content type sniffed when the file was last transferred, or a guess from its name for files this client has not seen.
+func (c *IpfsClient) MimeType(fileName string) string {
+	if m := c.GetFileMetadata(fileName); m != nil && m.MimeType != "" {
+		return m.MimeType
+	}
+
+	return sniff.ByName(c.DisplayName(fileName))
+}
@@ -0,0 +1,33 @@
+package pufs_client
+
+import (
+	"log"
+)
+
+// Notifier is told of outcomes worth showing to the user. The GUI shows them as desktop notifications.
+type Notifier interface {
+	Success(message string)
+	Error(message string)
+}
+
+// NotifySuccess passes a message to the Notifier. Packages built on the client report through it as well, messages are logged when no notifier is set.
+func (c *IpfsClient) NotifySuccess(message string) {
+	if c.Notifier == nil {
+		log.Println(message)
+
+		return
+	}
+
+	c.Notifier.Success(message)
+}
+
+// NotifyError passes an error message to the Notifier, or logs it.
+func (c *IpfsClient) NotifyError(message string) {
+	if c.Notifier == nil {
+		log.Printf("Error: %v", message)
+
+		return
+	}
+
+	c.Notifier.Error(message)
+}
@@ -0,0 +1,95 @@
+package pufs_client
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	pufs_pb "github.com/BitlyTwiser/pufs-server/proto"
+
+	"github.com/BitlyTwiser/throw/src/settings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Option configures a client made by New.
+type Option func(*IpfsClient)
+
+// WithSettings sets the encryption keys, credentials and transfer settings used. Without it files are sent as is, with no credentials.
+func WithSettings(s *settings.Settings) Option {
+	return func(c *IpfsClient) {
+		c.Settings = s
+	}
+}
+
+// WithNotifier sets where finished uploads, downloads and errors are reported. Without it they are logged.
+func WithNotifier(n Notifier) Option {
+	return func(c *IpfsClient) {
+		c.Notifier = n
+	}
+}
+
+// WithEventHandler adds a handler called with every upload, delete and remote change, see OnEvent.
+func WithEventHandler(handler EventHandler) Option {
+	return func(c *IpfsClient) {
+		c.eventHandlers = append(c.eventHandlers, handler)
+	}
+}
+
+// WithId sets the id the server event stream is subscribed under. A random id is used otherwise.
+func WithId(id int64) Option {
+	return func(c *IpfsClient) {
+		c.Id = id
+	}
+}
+
+// WithConnection sends requests through client rather than dialing the address given to New, for connections made with other dial options or shared between clients.
+func WithConnection(client pufs_pb.IpfsFileSystemClient) Option {
+	return func(c *IpfsClient) {
+		c.Client = client
+	}
+}
+
+// New returns a client of the pufs host at address, like localhost:9000. The connection is made once the first request is sent.
+// Host credentials are read from the settings on every request, so they may be filled in after New returns, as when the vault is unlocked later.
+func New(address string, opts ...Option) (*IpfsClient, error) {
+	c := &IpfsClient{
+		Id:           rand.New(rand.NewSource(time.Now().UnixNano())).Int63(),
+		Files:        []string{},
+		Settings:     &settings.Settings{},
+		FileMetadata: make(map[string]FileData),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.Client != nil {
+		return c, nil
+	}
+
+	conn, err := grpc.Dial(
+		address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithPerRPCCredentials(HostCredentials{Settings: c.Settings}),
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %v. Error: %w", address, err)
+	}
+
+	c.conn = conn
+	c.Client = pufs_pb.NewIpfsFileSystemClient(conn)
+
+	return c, nil
+}
+
+// Close closes the connection made by New.
+func (c *IpfsClient) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+
+	return c.conn.Close()
+}
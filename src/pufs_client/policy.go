@@ -0,0 +1,51 @@
+package pufs_client
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+
+	"github.com/BitlyTwiser/throw/src/policy"
+)
+
+// CheckPolicy checks an upload of the file at path against the upload policy within the settings.
+// Rules set to warn are returned, a rule set to block is returned as a *policy.Violation error.
+func (c *IpfsClient) CheckPolicy(ctx context.Context, path, fileName string) ([]*policy.Violation, error) {
+	fileInfo, err := os.Stat(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The same decisions the upload makes, taken from the same content type. Replacing a stored file passes its stored name, the rules apply to the plain name.
+	name := c.DisplayName(fileName)
+	mimeType := detectFile(name, path)
+	encryption, _ := c.encryptionFor(ctx, encryptable(mimeType))
+
+	violations := c.Settings.Policy.Check(policy.Upload{
+		FileName:  name,
+		Size:      fileInfo.Size(),
+		MimeType:  mimeType,
+		Encrypted: encryption != EncryptionNone,
+	})
+
+	if blocked := policy.Blocked(violations); blocked != nil {
+		log.Printf("Upload of %v blocked by policy. Rule: %v", fileName, blocked.Rule)
+
+		return nil, blocked
+	}
+
+	return violations, nil
+}
+
+// Stops reading a stream one byte past a blocking size limit, enough for CheckPolicy to refuse it without spooling the rest.
+func (c *IpfsClient) policyLimit(r io.Reader) io.Reader {
+	p := c.Settings.Policy
+
+	if p.MaxFileSize > 0 && (p.SizeAction == "" || p.SizeAction == policy.Block) {
+		return io.LimitReader(r, p.MaxFileSize+1)
+	}
+
+	return r
+}
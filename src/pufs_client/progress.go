@@ -0,0 +1,20 @@
+package pufs_client
+
+import "context"
+
+// ProgressFunc receives the amount of bytes transferred so far and the total size of the transfer.
+// The total will be 0 or below when the size is not known.
+type ProgressFunc func(transferred, total int64)
+
+type progressKey struct{}
+
+// WithProgress attaches a progress callback to a context. Uploads and downloads started with the returned context report through it.
+func WithProgress(ctx context.Context, progress ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, progress)
+}
+
+func reportProgress(ctx context.Context, transferred, total int64) {
+	if progress, ok := ctx.Value(progressKey{}).(ProgressFunc); ok && progress != nil {
+		progress(transferred, total)
+	}
+}
@@ -0,0 +1,154 @@
+package pufs_client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	pufs_pb "github.com/BitlyTwiser/pufs-server/proto"
+
+	"github.com/BitlyTwiser/throw/src/compression"
+)
+
+// OpenFile streams the plaintext of a stored file without writing it to disk. Links are followed.
+// Files below the stream threshold are decoded in memory. Larger files are decoded as they arrive, their checksum is verified once the end is reached and a mismatch is returned by Read in place of io.EOF.
+func (c *IpfsClient) OpenFile(ctx context.Context, fileName string) (io.ReadCloser, error) {
+	if !c.ChunkFile(ctx, fileName) {
+		data, err := c.fileContent(ctx, fileName)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	size, err := c.FileSize(ctx, fileName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := c.transferContext(ctx, size)
+
+	download, err := c.Client.DownloadFile(ctx, &pufs_pb.DownloadFileRequest{FileName: fileName})
+
+	if err != nil {
+		cancel()
+
+		return nil, err
+	}
+
+	r, w := io.Pipe()
+
+	go func() {
+		defer cancel()
+
+		w.CloseWithError(c.streamObject(fileName, download, w))
+	}()
+
+	return &streamReader{PipeReader: r, cancel: cancel}, nil
+}
+
+// Returns the plaintext of a file below the stream threshold.
+func (c *IpfsClient) fileContent(ctx context.Context, fileName string) ([]byte, error) {
+	downloadCtx, cancel := c.transferContext(ctx, streamThreshold)
+	defer cancel()
+
+	fileResp, err := c.Client.DownloadUncappedFile(downloadCtx, &pufs_pb.DownloadFileRequest{FileName: fileName})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if target, ok := linkTarget(fileResp.FileData); ok {
+		if ctx.Value(linkResolvingKey{}) != nil {
+			return nil, fmt.Errorf("%v links to another link, links cannot be chained", fileName)
+		}
+
+		r, err := c.OpenFile(context.WithValue(ctx, linkResolvingKey{}, true), target)
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer r.Close()
+
+		return io.ReadAll(r)
+	}
+
+	data, _, _, err := c.decodeObject(fileName, fileResp.FileData)
+
+	return data, err
+}
+
+// Decodes a download stream into w. The tail of the stream is held back until the checksum trailer has been found.
+func (c *IpfsClient) streamObject(fileName string, download pufs_pb.IpfsFileSystem_DownloadFileClient, w io.Writer) error {
+	h := sha256.New()
+
+	plaintext := compression.NewDecompressWriter(io.MultiWriter(h, w))
+	defer plaintext.Close()
+
+	payload := c.newPayloadWriter(plaintext)
+
+	var trailer trailerSplitter
+
+	for {
+		fileChunk, err := download.Recv()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := payload.Write(trailer.Next(fileChunk.GetFileData())); err != nil {
+			return err
+		}
+	}
+
+	body, digest := trailer.End()
+
+	if _, err := payload.Write(body); err != nil {
+		return err
+	}
+
+	if err := payload.Close(); err != nil {
+		return err
+	}
+
+	if err := plaintext.Close(); err != nil {
+		return err
+	}
+
+	info := payload.Info()
+
+	if digest != nil && info.key == nil {
+		return fmt.Errorf("%v has an encrypted checksum but no encryption envelope, it is either corrupt or was encrypted per chunk by an older client", fileName)
+	}
+
+	expected, err := c.expectedChecksum(fileName, digest, info.key)
+
+	if err != nil {
+		return err
+	}
+
+	return verifyChecksum(fileName, expected, hex.EncodeToString(h.Sum(nil)))
+}
+
+// Closing a stream stops the download.
+type streamReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (s *streamReader) Close() error {
+	s.cancel()
+
+	return s.PipeReader.Close()
+}
@@ -0,0 +1,120 @@
+package pufs_client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	pufs_pb "github.com/BitlyTwiser/pufs-server/proto"
+
+	"github.com/BitlyTwiser/throw/src/cid"
+)
+
+// RemoteFile describes a file as listed by the server.
+type RemoteFile struct {
+	Name       string
+	Size       int64
+	IpfsHash   string
+	UploadedAt time.Time
+}
+
+// Version changes whenever the file is uploaded again.
+func (r RemoteFile) Version() string {
+	return fmt.Sprintf("%v/%v/%v", r.IpfsHash, r.Size, r.UploadedAt.UnixNano())
+}
+
+// RemoteFiles lists the files held by the server, the file list and metadata are left untouched.
+func (c *IpfsClient) RemoteFiles(ctx context.Context) ([]RemoteFile, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	stream, err := c.Client.ListFiles(ctx, &pufs_pb.FilesRequest{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var files []RemoteFile
+
+	for {
+		file, err := stream.Recv()
+
+		if err == io.EOF {
+			return files, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, remoteFile(file.Files))
+	}
+}
+
+// WatchRemote calls handle with every file announced by the server event stream, until ctx is cancelled or the stream ends.
+// Each file is also sent to RemoteChanges listeners and event handlers, handle may be nil.
+// Unlike SubscribeFileStream, the file list and recorded metadata are left alone and a broken stream is not subscribed again. The subscription is made under the client id, UnsubscribeClient ends it.
+func (c *IpfsClient) WatchRemote(ctx context.Context, handle func(RemoteFile)) error {
+	stream, err := c.Client.ListFilesEventStream(ctx, &pufs_pb.FilesRequest{Id: c.Id})
+
+	if err != nil {
+		return err
+	}
+
+	for {
+		file, err := stream.Recv()
+
+		if err == io.EOF || ctx.Err() != nil {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if handle != nil {
+			handle(remoteFile(file.Files))
+		}
+
+		c.publishRemoteChange(file.Files.Filename)
+	}
+}
+
+func remoteFile(file *pufs_pb.File) RemoteFile {
+	return RemoteFile{
+		Name:       file.Filename,
+		Size:       file.FileSize,
+		IpfsHash:   cid.Normalize(file.IpfsHash),
+		UploadedAt: file.UploadedAt.AsTime(),
+	}
+}
+
+// RemoteChanges returns a channel receiving the name of every file announced by the server event stream.
+// Sends never block, a slow reader may miss names and should list the remote files instead.
+func (c *IpfsClient) RemoteChanges() <-chan string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	changes := make(chan string, 16)
+	c.remoteListeners = append(c.remoteListeners, changes)
+
+	return changes
+}
+
+func (c *IpfsClient) publishRemoteChange(fileName string) {
+	c.mutex.RLock()
+
+	for _, changes := range c.remoteListeners {
+		select {
+		case changes <- fileName:
+		default:
+			log.Printf("Remote change listener is full, dropping %v", fileName)
+		}
+	}
+
+	c.mutex.RUnlock()
+
+	c.emit(Event{Kind: EventRemoteChange, FileName: fileName})
+}
@@ -0,0 +1,414 @@
+package pufs_client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BitlyTwiser/throw/src/keyring"
+)
+
+// States of a file within a key rotation.
+const (
+	rotationPending    = "pending"
+	rotationDownloaded = "downloaded"
+	rotationDeleted    = "deleted"
+	rotationDone       = "done"
+	rotationSkipped    = "skipped"
+	rotationFailed     = "failed"
+)
+
+// Rotation is persisted to disk while files are re-encrypted under a new key, so an interrupted rotation picks up where it stopped.
+// pufs has no way to replace a file, so each file is downloaded, deleted, then uploaded again under the same name.
+// The local copy is only removed once the upload completes, so every file is always held by either the server or the disk.
+type Rotation struct {
+	KeyId string
+	// Also encrypt files that are currently stored as plaintext.
+	IncludePlaintext bool
+	Files            []RotationFile
+	StartedAt        string
+	UpdatedAt        string
+}
+
+type RotationFile struct {
+	FileName string
+	State    string
+	// Checksum of the local copy, verified before the remote file is deleted.
+	Checksum string
+	Err      string
+}
+
+func rotationDir() (string, error) {
+	cache, err := os.UserCacheDir()
+
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(cache, "throw", "rotation")
+
+	return dir, os.MkdirAll(dir, 0700)
+}
+
+func rotationPath() (string, error) {
+	dir, err := rotationDir()
+
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(filepath.Dir(dir), "rotation.json"), nil
+}
+
+// Reports if a local path belongs to a key rotation. Transfers within it are resumed by the rotation, not on their own.
+func inRotationDir(path string) bool {
+	dir, err := rotationDir()
+
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// PendingRotation returns the key rotation that has not completed. A nil rotation is returned when there is none.
+func PendingRotation() (*Rotation, error) {
+	path, err := rotationPath()
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+
+	if err != nil && os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	rotation := &Rotation{}
+
+	if err := json.Unmarshal(data, rotation); err != nil {
+		return nil, err
+	}
+
+	return rotation, nil
+}
+
+// Save writes the rotation to a temporary file then renames, a crash mid write will never leave a torn journal behind.
+func (r *Rotation) Save() error {
+	path, err := rotationPath()
+
+	if err != nil {
+		return err
+	}
+
+	r.UpdatedAt = time.Now().Format(time.UnixDate)
+
+	data, err := json.MarshalIndent(r, "", "")
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// Remove deletes the journal and any local copies left behind.
+func (r *Rotation) Remove() error {
+	path, err := rotationPath()
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	dir, err := rotationDir()
+
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(dir)
+}
+
+// Completed returns the amount of files that need no more work.
+func (r *Rotation) Completed() int {
+	var n int
+
+	for _, f := range r.Files {
+		if f.State == rotationDone || f.State == rotationSkipped {
+			n++
+		}
+	}
+
+	return n
+}
+
+// RotateKeys re-encrypts every file under the given key. Progress is reported in files rather than bytes.
+// Files already encrypted under the key and links are skipped. Files sealed for recipients keep their encryption, they are reported once the rotation completes. Plaintext files are only encrypted when includePlaintext is set.
+// An interrupted rotation to the same key is resumed. Files that fail are reported once every other file has been rotated, running the rotation again retries them.
+func (c *IpfsClient) RotateKeys(ctx context.Context, keyId string, includePlaintext bool) error {
+	if keyId != keyring.DefaultKeyId && c.Settings.Keyring.Find(keyId) == nil {
+		return fmt.Errorf("key %v is not within the keyring", keyId)
+	}
+
+	rotation, err := PendingRotation()
+
+	if err != nil {
+		return err
+	}
+
+	if rotation != nil && rotation.KeyId != keyId {
+		return fmt.Errorf("a rotation to %v has not completed, finish it first", c.Settings.Keyring.Name(rotation.KeyId))
+	}
+
+	if rotation == nil {
+		rotation = &Rotation{KeyId: keyId, IncludePlaintext: includePlaintext, StartedAt: time.Now().Format(time.UnixDate)}
+
+		c.mutex.RLock()
+		seen := make(map[string]Empty)
+
+		for _, name := range c.Files {
+			if _, ok := seen[name]; ok {
+				continue
+			}
+
+			seen[name] = Empty{}
+			rotation.Files = append(rotation.Files, RotationFile{FileName: name, State: rotationPending})
+		}
+		c.mutex.RUnlock()
+	} else {
+		log.Printf("Resuming key rotation to %v", c.Settings.Keyring.Name(keyId))
+	}
+
+	if err := rotation.Save(); err != nil {
+		return err
+	}
+
+	dir, err := rotationDir()
+
+	if err != nil {
+		return err
+	}
+
+	total := int64(len(rotation.Files))
+	reportProgress(ctx, int64(rotation.Completed()), total)
+
+	// Downloads and uploads report in bytes, keep them from overwriting the file count.
+	fileCtx := WithProgress(ctx, nil)
+	var failed int
+
+	for i := range rotation.Files {
+		f := &rotation.Files[i]
+
+		if f.State == rotationDone || f.State == rotationSkipped {
+			continue
+		}
+
+		if err := c.rotateFile(fileCtx, rotation, f, dir); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			// The remote file is gone, only the local copy is left. Stop rather than risk it.
+			if f.State == rotationDeleted {
+				return fmt.Errorf("error uploading %v, the rotation can be resumed from the local copy. Error: %v", f.FileName, err)
+			}
+
+			log.Printf("Error rotating %v. Error: %v", f.FileName, err)
+			f.State = rotationFailed
+			f.Err = err.Error()
+			failed++
+
+			if err := rotation.Save(); err != nil {
+				return err
+			}
+		}
+
+		reportProgress(ctx, int64(rotation.Completed()), total)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%v of %v files could not be rotated, run the rotation again to retry them", failed, total)
+	}
+
+	var sealed []string
+
+	for _, f := range rotation.Files {
+		if f.State == rotationSkipped && f.Err != "" {
+			sealed = append(sealed, c.DisplayName(f.FileName))
+		}
+	}
+
+	if err := rotation.Remove(); err != nil {
+		return err
+	}
+
+	if len(sealed) > 0 {
+		c.NotifySuccess(fmt.Sprintf("Every file is now encrypted with %v, except %v sealed for recipients: %v", c.Settings.Keyring.Name(keyId), len(sealed), strings.Join(sealed, ", ")))
+
+		return nil
+	}
+
+	c.NotifySuccess(fmt.Sprintf("Every file is now encrypted with %v", c.Settings.Keyring.Name(keyId)))
+
+	return nil
+}
+
+// Moves a single file through the rotation, saving the journal after every step.
+func (c *IpfsClient) rotateFile(ctx context.Context, rotation *Rotation, f *RotationFile, dir string) error {
+	// Downloads are saved under their local name, encrypted names and names within folders differ from the stored name.
+	local := filepath.Join(dir, c.LocalFileName(f.FileName))
+
+	// A local copy that no longer matches is downloaded again, the remote file still exists at this point.
+	if f.State == rotationDownloaded && f.Checksum != fileChecksum(local) {
+		log.Printf("Local copy of %v does not match, downloading it again", f.FileName)
+		f.State = rotationPending
+	}
+
+	if f.State == rotationPending || f.State == rotationFailed {
+		skip, err := c.rotationDownload(ctx, rotation, f, dir)
+
+		if err != nil {
+			return err
+		}
+
+		if skip {
+			os.Remove(local)
+			f.State = rotationSkipped
+
+			// Only the recipients can open a sealed file again, it cannot be moved under a key of this keyring.
+			if m := c.GetFileMetadata(f.FileName); m != nil && m.Encryption == EncryptionRecipients {
+				log.Printf("%v is sealed for recipients, it keeps its encryption", c.DisplayName(f.FileName))
+				f.Err = "sealed for recipients"
+			}
+
+			return rotation.Save()
+		}
+
+		if f.Checksum = fileChecksum(local); f.Checksum == "" {
+			return fmt.Errorf("%v was downloaded, but its local copy at %v cannot be read", f.FileName, local)
+		}
+
+		f.State = rotationDownloaded
+		f.Err = ""
+
+		if err := rotation.Save(); err != nil {
+			return err
+		}
+	}
+
+	if f.State == rotationDownloaded {
+		// The stored file is the only other copy, never delete it without a local copy to upload in its place.
+		if sum := fileChecksum(local); sum == "" || sum != f.Checksum {
+			return fmt.Errorf("local copy of %v is missing or does not match, the stored file is kept", f.FileName)
+		}
+
+		if err := c.DeleteFile(WithReplace(ctx), f.FileName, false); err != nil {
+			return err
+		}
+
+		f.State = rotationDeleted
+
+		if err := rotation.Save(); err != nil {
+			return err
+		}
+	}
+
+	if f.State == rotationDeleted {
+		if fileChecksum(local) != f.Checksum {
+			return fmt.Errorf("local copy of %v is missing or does not match", f.FileName)
+		}
+
+		uploadCtx := WithEncryptionKey(WithExactName(ctx), rotation.KeyId)
+
+		if err := c.uploadFile(uploadCtx, local, f.FileName); err != nil {
+			return err
+		}
+
+		f.State = rotationDone
+
+		if err := rotation.Save(); err != nil {
+			return err
+		}
+
+		os.Remove(local)
+	}
+
+	return nil
+}
+
+// Downloads a file into the rotation directory. Reports if the file needs no rotation, the stored object tells how it is encrypted.
+func (c *IpfsClient) rotationDownload(ctx context.Context, rotation *Rotation, f *RotationFile, dir string) (bool, error) {
+	if m := c.GetFileMetadata(f.FileName); m != nil && m.LinkTarget != "" {
+		return true, nil
+	}
+
+	var err error
+
+	if c.ChunkFile(ctx, f.FileName) {
+		err = c.DownloadCappedFile(ctx, f.FileName, dir)
+	} else {
+		err = c.DownloadFile(ctx, f.FileName, dir)
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	m := c.GetFileMetadata(f.FileName)
+
+	if m == nil {
+		return false, errors.New("no metadata found for downloaded file")
+	}
+
+	switch m.Encryption {
+	case EncryptionEnvelope:
+		return m.KeyId == rotation.KeyId, nil
+	case EncryptionNone:
+		return !rotation.IncludePlaintext, nil
+	case EncryptionRecipients:
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// Hex encoded SHA-256 of a local file, empty when the file cannot be read.
+func fileChecksum(path string) string {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return ""
+	}
+
+	defer file.Close()
+
+	h := sha256.New()
+
+	if _, err := io.Copy(h, file); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,256 @@
+package pufs_client
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/BitlyTwiser/throw/src/tree"
+)
+
+// TreeFile is the outcome of transferring a single file of a folder.
+type TreeFile struct {
+	Path string
+	// Name the file is shown under, the folder name joined with its relative path.
+	Name       string
+	StoredName string
+	Size       int64
+	// Set when the file is already stored with the same content and was not uploaded again.
+	Skipped bool
+	Err     error
+}
+
+// TreeError lists the files of a folder that could not be transferred, the rest were.
+type TreeError struct {
+	Failed []TreeFile
+	Total  int
+}
+
+func (e *TreeError) Error() string {
+	var failures []string
+
+	for _, f := range e.Failed {
+		failures = append(failures, fmt.Sprintf("%v: %v", f.Name, f.Err))
+	}
+
+	return fmt.Sprintf("%v of %v files failed. %v", len(e.Failed), e.Total, strings.Join(failures, "; "))
+}
+
+// UploadTree uploads the files of the folder at root selected by the filter and ignore files, each stored under name joined with its path relative to root.
+// A file already stored under its name with the same content is skipped, so an interrupted upload can be started again.
+// Files refused by the upload policy or failing to upload do not stop the rest, they are listed within a *TreeError once every file has been tried.
+// Progress is reported in bytes over the whole folder.
+func (c *IpfsClient) UploadTree(ctx context.Context, root, name string, filter tree.Filter) ([]TreeFile, error) {
+	name = strings.Trim(path.Clean("/"+filepath.ToSlash(name)), "/")
+
+	if name == "" {
+		return nil, fmt.Errorf("a folder name is needed to upload %v", root)
+	}
+
+	files, err := tree.Walk(root, filter)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files within %v are selected", root)
+	}
+
+	var total, done int64
+
+	for _, f := range files {
+		total += f.Size
+	}
+
+	results := make([]TreeFile, len(files))
+	reportProgress(ctx, 0, total)
+
+	defer c.batchMetadata()()
+
+	for i, f := range files {
+		if ctx.Err() != nil {
+			return results[:i], ctx.Err()
+		}
+
+		results[i] = TreeFile{Path: f.Path, Name: path.Join(name, f.Name), Size: f.Size}
+		result := &results[i]
+
+		// Bytes of the current file are added to those of the files already done.
+		fileCtx := WithProgress(ctx, func(transferred, _ int64) {
+			reportProgress(ctx, done+transferred, total)
+		})
+		fileCtx = WithStoredName(fileCtx, func(stored string) { result.StoredName = stored })
+
+		result.Err = c.uploadTreeFile(fileCtx, result)
+
+		done += f.Size
+		reportProgress(ctx, done, total)
+	}
+
+	return results, c.treeResult("uploaded", results)
+}
+
+func (c *IpfsClient) uploadTreeFile(ctx context.Context, f *TreeFile) error {
+	if stored, ok := c.storedCopy(f.Name, f.Path); ok {
+		log.Printf("Skipping %v, it is already stored as %v", f.Path, stored)
+		f.StoredName = stored
+		f.Skipped = true
+
+		return nil
+	}
+
+	warnings, err := c.CheckPolicy(ctx, f.Path, f.Name)
+
+	if err != nil {
+		return err
+	}
+
+	for _, w := range warnings {
+		log.Printf("Uploading %v. Warning: %v", f.Path, w)
+	}
+
+	return c.uploadFile(ctx, f.Path, f.Name)
+}
+
+// Returns the stored name of a file shown as name whose recorded checksum matches the file at path.
+func (c *IpfsClient) storedCopy(name, local string) (string, bool) {
+	var candidates []FileData
+
+	c.mutex.RLock()
+	for _, stored := range c.Files {
+		if m, ok := c.FileMetadata[stored]; ok && m.Checksum != "" && m.LinkTarget == "" && c.DisplayName(stored) == name {
+			candidates = append(candidates, m)
+		}
+	}
+	c.mutex.RUnlock()
+
+	if len(candidates) == 0 {
+		return "", false
+	}
+
+	checksum := fileChecksum(local)
+
+	for _, m := range candidates {
+		if checksum != "" && m.Checksum == checksum {
+			return m.FileName, true
+		}
+	}
+
+	return "", false
+}
+
+// DownloadTree downloads every stored file shown under the folder name and selected by the filter into dest, rebuilding the folder and those within it.
+// The filter is matched against paths relative to the folder. Names that would leave dest are refused.
+// Files failing to download do not stop the rest, they are listed within a *TreeError once every file has been tried.
+// Progress is reported in bytes over the whole folder.
+func (c *IpfsClient) DownloadTree(ctx context.Context, name, dest string, filter tree.Filter) ([]TreeFile, error) {
+	name = strings.Trim(path.Clean("/"+filepath.ToSlash(name)), "/")
+
+	if name == "" {
+		return nil, fmt.Errorf("a folder name is needed to download into %v", dest)
+	}
+
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+
+	remote, err := c.RemoteFiles(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var results []TreeFile
+	var total, done int64
+	seen := make(map[string]bool)
+
+	defer c.batchMetadata()()
+
+	for _, f := range remote {
+		shown := c.DisplayName(f.Name)
+		rel := strings.TrimPrefix(shown, name+"/")
+
+		if seen[f.Name] || rel == shown || !filter.Match(rel) {
+			continue
+		}
+
+		seen[f.Name] = true
+		local := path.Join(path.Base(name), rel)
+		result := TreeFile{Name: shown, StoredName: f.Name, Size: f.Size, Path: filepath.Join(dest, filepath.FromSlash(local))}
+
+		// Names are chosen by whoever uploaded them, they may climb out of the destination.
+		if !fs.ValidPath(rel) || strings.Contains(rel, "\\") {
+			result.Path = ""
+			result.Err = fmt.Errorf("%v is not a safe relative path", rel)
+		}
+
+		results = append(results, result)
+		total += f.Size
+	}
+
+	if len(results) == 0 {
+		return nil, &RequestError{Op: "download", FileName: name, Err: ErrNotFound}
+	}
+
+	reportProgress(ctx, 0, total)
+
+	for i := range results {
+		f := &results[i]
+
+		if ctx.Err() != nil {
+			return results[:i], ctx.Err()
+		}
+
+		if f.Err == nil {
+			fileCtx := WithProgress(ctx, func(transferred, _ int64) {
+				reportProgress(ctx, done+transferred, total)
+			})
+
+			f.Err = c.downloadTreeFile(fileCtx, f)
+		}
+
+		done += f.Size
+		reportProgress(ctx, done, total)
+	}
+
+	return results, c.treeResult("downloaded", results)
+}
+
+func (c *IpfsClient) downloadTreeFile(ctx context.Context, f *TreeFile) error {
+	dir := filepath.Dir(f.Path)
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	return c.DownloadTo(ctx, f.StoredName, dir)
+}
+
+// Notifies once every file of a folder has been tried, failures are returned instead.
+func (c *IpfsClient) treeResult(done string, results []TreeFile) error {
+	var failed []TreeFile
+
+	for _, f := range results {
+		if f.Err != nil {
+			log.Printf("Error transferring %v. Error: %v", f.Name, f.Err)
+			failed = append(failed, f)
+		}
+	}
+
+	if len(failed) > 0 {
+		err := &TreeError{Failed: failed, Total: len(results)}
+		c.NotifyError(err.Error())
+
+		return err
+	}
+
+	c.NotifySuccess(fmt.Sprintf("%v files %v", len(results), done))
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package pufsfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+)
+
+// A stored file. The download starts with the first read, seeking backwards starts it over.
+type file struct {
+	fs   *FS
+	info *fileInfo
+	// Open download, nil until the first read.
+	r io.ReadCloser
+	// Position of the download and position of the next read, they differ after a seek.
+	offset int64
+	seek   int64
+	closed bool
+	// Set when Stat must report the exact size.
+	exactStat bool
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	if !f.exactStat || f.info.exact {
+		return f.info, nil
+	}
+
+	size, err := f.fs.exactSize(f.info)
+
+	if err != nil {
+		return nil, pathError("stat", f.info, err)
+	}
+
+	info := *f.info
+	info.size = size
+	info.data.FileSize = size
+	info.exact = true
+
+	return &info, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, pathError("read", f.info, fs.ErrClosed)
+	}
+
+	if f.r != nil && f.seek < f.offset {
+		f.r.Close()
+		f.r = nil
+	}
+
+	if f.r == nil {
+		r, err := f.fs.client.OpenFile(f.fs.ctx, f.info.stored)
+
+		if err != nil {
+			return 0, pathError("read", f.info, err)
+		}
+
+		f.r = r
+		f.offset = 0
+	}
+
+	// pufs always streams from the start of a file, skipped data is still downloaded.
+	if f.seek > f.offset {
+		n, err := io.CopyN(io.Discard, f.r, f.seek-f.offset)
+		f.offset += n
+
+		if err != nil {
+			return 0, pathError("read", f.info, err)
+		}
+	}
+
+	n, err := f.r.Read(p)
+	f.offset += int64(n)
+	f.seek = f.offset
+
+	if err == io.EOF && !f.info.exact {
+		f.fs.measured(f.info, f.offset)
+	}
+
+	return n, pathError("read", f.info, err)
+}
+
+// Seek only moves the position, the download catches up on the next read. Seeking from the end of a file of unknown size reads it to the end first.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, pathError("seek", f.info, fs.ErrClosed)
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.seek
+	case io.SeekEnd:
+		size, err := f.fs.exactSize(f.info)
+
+		if err != nil {
+			return 0, pathError("seek", f.info, err)
+		}
+
+		offset += size
+	default:
+		return 0, pathError("seek", f.info, fs.ErrInvalid)
+	}
+
+	if offset < 0 {
+		return 0, pathError("seek", f.info, errors.New("negative position"))
+	}
+
+	f.seek = offset
+
+	return offset, nil
+}
+
+func (f *file) Close() error {
+	if f.closed {
+		return pathError("close", f.info, fs.ErrClosed)
+	}
+
+	f.closed = true
+
+	if f.r != nil {
+		return f.r.Close()
+	}
+
+	return nil
+}
+
+func pathError(op string, info *fileInfo, err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+
+	return &fs.PathError{Op: op, Path: info.path, Err: err}
+}
@@ -0,0 +1,360 @@
+// Package pufsfs presents the files stored on pufs as an io/fs file system, so they can be read by fs.WalkDir, template.ParseFS, http.FileServer and the like.
+// Files are listed under their display name, a name holding slashes places the file within folders. Folders only exist through the files within them.
+// The file system is read only, file content is streamed and decrypted as it is read.
+package pufsfs
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/BitlyTwiser/throw/src/filename"
+	"github.com/BitlyTwiser/throw/src/pufs_client"
+)
+
+// The server is listed at most once within this window, walking a tree does not list it once per folder.
+const listingTTL = 2 * time.Second
+
+// FS implements fs.FS, fs.StatFS and fs.ReadDirFS over the files held by the server.
+type FS struct {
+	ctx    context.Context
+	client *pufs_client.IpfsClient
+	mutex  sync.Mutex
+	index  *index
+	listed time.Time
+	// Sizes measured by reading files whose size the listing does not know, keyed by version. The listing keeps the upper bound, so Stat and ReadDir agree.
+	sizes map[string]int64
+}
+
+var (
+	_ fs.FS        = (*FS)(nil)
+	_ fs.StatFS    = (*FS)(nil)
+	_ fs.ReadDirFS = (*FS)(nil)
+)
+
+// New returns the file system of the files held by the server. Requests made by it end once ctx is cancelled.
+func New(ctx context.Context, client *pufs_client.IpfsClient) *FS {
+	return &FS{ctx: ctx, client: client, sizes: make(map[string]int64)}
+}
+
+// HTTP returns the file system as an http.FileSystem for http.FileServer.
+func (f *FS) HTTP() http.FileSystem {
+	return http.FS(exactFS{f})
+}
+
+// http.FileServer sends the size from Stat as the content length, files opened through it measure their size when the listing does not know it.
+type exactFS struct {
+	*FS
+}
+
+func (e exactFS) Open(name string) (fs.File, error) {
+	return e.open(name, true)
+}
+
+// Open opens the named file or folder. File content is only downloaded once it is read.
+func (f *FS) Open(name string) (fs.File, error) {
+	return f.open(name, false)
+}
+
+func (f *FS) open(name string, exactStat bool) (fs.File, error) {
+	info, ix, err := f.lookup("open", name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return &dir{info: info, entries: ix.entries(name)}, nil
+	}
+
+	return &file{fs: f, info: info, exactStat: exactStat}, nil
+}
+
+// Stat describes the named file or folder without downloading it.
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	info, _, err := f.lookup("stat", name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// ReadDir lists the named folder, ordered by name.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	info, ix, err := f.lookup("readdir", name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	return ix.entries(name), nil
+}
+
+// Refresh drops the cached listing, the next call lists the server again. Writers call it so their changes show up at once.
+func (f *FS) Refresh() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.index = nil
+}
+
+// Returns the named entry from a fresh enough listing of the server, along with the listing.
+func (f *FS) lookup(op, name string) (*fileInfo, *index, error) {
+	if !fs.ValidPath(name) {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.index == nil || time.Since(f.listed) > listingTTL {
+		files, err := f.client.RemoteFiles(f.ctx)
+
+		if err != nil {
+			return nil, nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+
+		f.index = newIndex(f.client, files)
+		f.listed = time.Now()
+	}
+
+	info, ok := f.index.infos[name]
+
+	if !ok {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	return info, f.index, nil
+}
+
+// A listing of the server arranged into folders. Never modified once built.
+type index struct {
+	// Keyed by path, the root folder is ".".
+	infos map[string]*fileInfo
+	// Names of the entries within each folder.
+	children map[string][]string
+}
+
+func newIndex(client *pufs_client.IpfsClient, files []pufs_client.RemoteFile) *index {
+	ix := &index{
+		infos:    map[string]*fileInfo{".": {name: ".", dir: true}},
+		children: make(map[string][]string),
+	}
+
+	for _, remote := range files {
+		name := client.DisplayName(remote.Name)
+
+		if !fs.ValidPath(name) || name == "." {
+			log.Printf("Not listing %v, %v is not a valid path", remote.Name, name)
+
+			continue
+		}
+
+		if !ix.addFolders(path.Dir(name)) {
+			log.Printf("Not listing %v, a file is stored under one of its folders", name)
+
+			continue
+		}
+
+		if _, ok := ix.infos[name]; ok {
+			log.Printf("Not listing %v, %v is already listed", remote.Name, name)
+
+			continue
+		}
+
+		ix.add(name, newFileInfo(client, remote, path.Base(name)))
+	}
+
+	for _, names := range ix.children {
+		sort.Strings(names)
+	}
+
+	return ix
+}
+
+// Adds a folder and every folder above it, failing when one of them is a file.
+func (ix *index) addFolders(name string) bool {
+	info, ok := ix.infos[name]
+
+	if ok {
+		return info.dir
+	}
+
+	if !ix.addFolders(path.Dir(name)) {
+		return false
+	}
+
+	ix.add(name, &fileInfo{name: path.Base(name), dir: true})
+
+	return true
+}
+
+func (ix *index) add(name string, info *fileInfo) {
+	info.path = name
+	ix.infos[name] = info
+	ix.children[path.Dir(name)] = append(ix.children[path.Dir(name)], info.name)
+}
+
+func (ix *index) entries(name string) []fs.DirEntry {
+	var entries []fs.DirEntry
+
+	for _, child := range ix.children[name] {
+		entries = append(entries, ix.infos[path.Join(name, child)])
+	}
+
+	return entries
+}
+
+// fileInfo describes a stored file or a folder. It is both the fs.FileInfo and the fs.DirEntry of the entry.
+type fileInfo struct {
+	name    string
+	path    string
+	dir     bool
+	stored  string
+	version string
+	size    int64
+	// Unset when size is only an upper bound.
+	exact   bool
+	modTime time.Time
+	data    pufs_client.FileData
+}
+
+// The size and content type recorded by this client are preferred, MimeType is empty for files it has not transferred.
+// The server only knows the rounded size of files with an encrypted name, their listed size is an upper bound.
+func newFileInfo(client *pufs_client.IpfsClient, remote pufs_client.RemoteFile, name string) *fileInfo {
+	exact := !filename.IsEncrypted(remote.Name)
+
+	data := pufs_client.FileData{
+		FileName:   remote.Name,
+		FileSize:   remote.Size,
+		IpfsHash:   remote.IpfsHash,
+		UploadedAt: remote.UploadedAt.Format(time.UnixDate),
+	}
+
+	if m := client.GetFileMetadata(remote.Name); m != nil && m.IpfsHash == remote.IpfsHash {
+		data = *m
+
+		if data.FileSize > 0 {
+			exact = true
+		} else {
+			data.FileSize = remote.Size
+		}
+	}
+
+	return &fileInfo{name: name, stored: remote.Name, version: remote.Version(), size: data.FileSize, exact: exact, modTime: remote.UploadedAt, data: data}
+}
+
+func (i *fileInfo) Name() string       { return i.name }
+func (i *fileInfo) Size() int64        { return i.size }
+func (i *fileInfo) ModTime() time.Time { return i.modTime }
+func (i *fileInfo) IsDir() bool        { return i.dir }
+
+func (i *fileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+
+	return 0444
+}
+
+// Sys returns the pufs_client.FileData of a file, nil for a folder.
+func (i *fileInfo) Sys() interface{} {
+	if i.dir {
+		return nil
+	}
+
+	return i.data
+}
+
+func (i *fileInfo) Type() fs.FileMode          { return i.Mode().Type() }
+func (i *fileInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+// Folders, listed as they were when opened.
+type dir struct {
+	info    *fileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dir) Close() error               { return nil }
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.path, Err: fs.ErrInvalid}
+}
+
+// ReadDir follows fs.ReadDirFile, n <= 0 returns every remaining entry.
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+
+	if n <= 0 {
+		d.offset = len(d.entries)
+
+		return remaining, nil
+	}
+
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+
+	d.offset += n
+
+	return remaining[:n], nil
+}
+
+// Records the size of a file found by reading it to the end.
+func (f *FS) measured(info *fileInfo, size int64) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.sizes[info.version] = size
+}
+
+// Returns the exact size of a file, reading it to the end when the listing does not know it.
+func (f *FS) exactSize(info *fileInfo) (int64, error) {
+	if info.exact {
+		return info.size, nil
+	}
+
+	f.mutex.Lock()
+	size, ok := f.sizes[info.version]
+	f.mutex.Unlock()
+
+	if ok {
+		return size, nil
+	}
+
+	r, err := f.client.OpenFile(f.ctx, info.stored)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer r.Close()
+
+	size, err = io.Copy(io.Discard, r)
+
+	if err != nil {
+		return 0, err
+	}
+
+	f.measured(info, size)
+
+	return size, nil
+}
@@ -0,0 +1,200 @@
+// Package recipient wraps file keys for one or more X25519 public keys, similar to age.
+// Every file gets a random key. The key is wrapped once per recipient using a fresh ephemeral key pair, any one of the recipients can unwrap it with their private key.
+// The wrapped keys are stored within the envelope header in place of key derivation parameters:
+//
+//	stanza: ephemeral public key(32) nonce(12) wrapped key(32) tag(16)
+package recipient
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/BitlyTwiser/throw/src/envelope"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	PublicKeyPrefix  = "throw-pub-"
+	PrivateKeyPrefix = "throw-sec-"
+
+	KeySize = 32
+
+	stanzaSize = curve25519.PointSize + envelope.NonceSize + KeySize + envelope.TagSize
+	wrapInfo   = "throw x25519 file key"
+)
+
+// Contact is an entry of the address book.
+type Contact struct {
+	Name      string
+	PublicKey string
+}
+
+// Identity is the key pair of the local user.
+type Identity struct {
+	private []byte
+	public  []byte
+}
+
+// Generate returns a new encoded private key.
+func Generate() (string, error) {
+	private := make([]byte, curve25519.ScalarSize)
+
+	if _, err := io.ReadFull(rand.Reader, private); err != nil {
+		return "", err
+	}
+
+	return PrivateKeyPrefix + base64.RawURLEncoding.EncodeToString(private), nil
+}
+
+// ParseIdentity decodes a private key created by Generate.
+func ParseIdentity(s string) (*Identity, error) {
+	private, err := decode(s, PrivateKeyPrefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{private: private, public: public}, nil
+}
+
+// PublicKey returns the encoded public key to share with others.
+func (i *Identity) PublicKey() string {
+	return PublicKeyPrefix + base64.RawURLEncoding.EncodeToString(i.public)
+}
+
+// ParsePublicKey decodes a public key shared by a recipient.
+func ParsePublicKey(s string) ([]byte, error) {
+	return decode(s, PublicKeyPrefix)
+}
+
+func decode(s, prefix string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+
+	if !strings.HasPrefix(s, prefix) {
+		return nil, fmt.Errorf("key must start with %v", prefix)
+	}
+
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, prefix))
+
+	if err != nil || len(key) != KeySize {
+		return nil, errors.New("invalid key encoding")
+	}
+
+	return key, nil
+}
+
+// NewFileKey returns a random key for a single file.
+func NewFileKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// Wrap encrypts fileKey for every public key, returning the stanzas to store within the envelope header.
+func Wrap(fileKey []byte, publicKeys [][]byte) ([]byte, error) {
+	if len(publicKeys) == 0 {
+		return nil, errors.New("no recipients given")
+	}
+
+	if len(publicKeys)*stanzaSize > 0xffff {
+		return nil, errors.New("too many recipients")
+	}
+
+	var stanzas []byte
+
+	for _, public := range publicKeys {
+		ephemeral := make([]byte, curve25519.ScalarSize)
+
+		if _, err := io.ReadFull(rand.Reader, ephemeral); err != nil {
+			return nil, err
+		}
+
+		share, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// X25519 rejects low order points, a malformed public key fails here.
+		shared, err := curve25519.X25519(ephemeral, public)
+
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := wrapKey(shared, share, public)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sealed, err := envelope.SealBlock(key, fileKey)
+
+		if err != nil {
+			return nil, err
+		}
+
+		stanzas = append(stanzas, share...)
+		stanzas = append(stanzas, sealed...)
+	}
+
+	return stanzas, nil
+}
+
+// Unwrap returns the file key from the first stanza the identity can open.
+func (i *Identity) Unwrap(stanzas []byte) ([]byte, error) {
+	if len(stanzas) == 0 || len(stanzas)%stanzaSize != 0 {
+		return nil, errors.New("invalid recipient stanzas")
+	}
+
+	// Stanzas do not name their recipient, every one of them is tried.
+	for ; len(stanzas) > 0; stanzas = stanzas[stanzaSize:] {
+		share := stanzas[:curve25519.PointSize]
+
+		shared, err := curve25519.X25519(i.private, share)
+
+		if err != nil {
+			continue
+		}
+
+		key, err := wrapKey(shared, share, i.public)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if fileKey, err := envelope.OpenBlock(key, stanzas[curve25519.PointSize:stanzaSize]); err == nil {
+			return fileKey, nil
+		}
+	}
+
+	return nil, errors.New("file was not encrypted for your public key")
+}
+
+// Derives the key wrapping a file key from the shared secret, bound to the ephemeral share and the recipient's public key.
+func wrapKey(shared, share, public []byte) ([]byte, error) {
+	salt := append(append([]byte{}, share...), public...)
+	key := make([]byte, KeySize)
+
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(wrapInfo)), key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
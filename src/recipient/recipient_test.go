@@ -0,0 +1,175 @@
+package recipient
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/BitlyTwiser/throw/src/envelope"
+)
+
+// Key pairs and shared secret of RFC 7748, section 6.1.
+const (
+	alicePrivate = "77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a"
+	alicePublic  = "8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a"
+	bobPrivate   = "5dab087e624a8a4b79e17f8b83800ee66f3bb1292618b6fd1c2f8b27ff88e0eb"
+	bobPublic    = "de9edb7d7b7dc1b4d35b61c2ece435373f8343c85b78674dadfc7e146f882b4f"
+	sharedSecret = "4a5d9d5ba4ce2de1728e3bf480350f25e07e21c947d19e3376f09b3c1e161742"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func identity(t *testing.T, private string) *Identity {
+	i, err := ParseIdentity(PrivateKeyPrefix + base64.RawURLEncoding.EncodeToString(unhex(t, private)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return i
+}
+
+func TestParseIdentityKnownAnswers(t *testing.T) {
+	tests := []struct {
+		private string
+		public  string
+	}{
+		{alicePrivate, alicePublic},
+		{bobPrivate, bobPublic},
+	}
+
+	for _, test := range tests {
+		want := PublicKeyPrefix + base64.RawURLEncoding.EncodeToString(unhex(t, test.public))
+
+		if got := identity(t, test.private).PublicKey(); got != want {
+			t.Errorf("public key of %v = %v, want %v", test.private, got, want)
+		}
+
+		if public, err := ParsePublicKey(" " + want + "\n"); err != nil || !bytes.Equal(public, unhex(t, test.public)) {
+			t.Errorf("ParsePublicKey of %v = %x, %v", want, public, err)
+		}
+	}
+}
+
+// A stanza built by hand from the RFC 7748 exchange, with Alice's key as the ephemeral one, must open with Bob's identity.
+func TestUnwrapStanzaLayout(t *testing.T) {
+	fileKey := bytes.Repeat([]byte{0x5a}, KeySize)
+	share := unhex(t, alicePublic)
+
+	key, err := wrapKey(unhex(t, sharedSecret), share, unhex(t, bobPublic))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := envelope.SealBlock(key, fileKey)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stanza := append(append([]byte{}, share...), sealed...)
+
+	if len(stanza) != stanzaSize {
+		t.Fatalf("stanza is %v bytes, want %v", len(stanza), stanzaSize)
+	}
+
+	if got, err := identity(t, bobPrivate).Unwrap(stanza); err != nil || !bytes.Equal(got, fileKey) {
+		t.Fatalf("Unwrap = %x, %v", got, err)
+	}
+
+	if _, err := identity(t, alicePrivate).Unwrap(stanza); err == nil {
+		t.Error("the stanza opened for a key it was not wrapped for")
+	}
+}
+
+func TestWrapRoundTrip(t *testing.T) {
+	var identities []*Identity
+	var publicKeys [][]byte
+
+	for i := 0; i < 3; i++ {
+		encoded, err := Generate()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		id, err := ParseIdentity(encoded)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		public, _ := ParsePublicKey(id.PublicKey())
+		identities = append(identities, id)
+		publicKeys = append(publicKeys, public)
+	}
+
+	fileKey, err := NewFileKey()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for n := 1; n <= len(publicKeys); n++ {
+		stanzas, err := Wrap(fileKey, publicKeys[:n])
+
+		if err != nil || len(stanzas) != n*stanzaSize {
+			t.Fatalf("Wrap for %v recipients = %v bytes, %v", n, len(stanzas), err)
+		}
+
+		for i, id := range identities {
+			got, err := id.Unwrap(stanzas)
+
+			if recipient := i < n; recipient != (err == nil) || (recipient && !bytes.Equal(got, fileKey)) {
+				t.Errorf("%v recipients: Unwrap by identity %v = %x, %v", n, i, got, err)
+			}
+		}
+	}
+}
+
+func TestRejects(t *testing.T) {
+	fileKey := bytes.Repeat([]byte{1}, KeySize)
+
+	if _, err := Wrap(fileKey, nil); err == nil {
+		t.Error("Wrap accepted no recipients")
+	}
+
+	// The all zero point has a low order, the shared secret would not depend on the ephemeral key.
+	if _, err := Wrap(fileKey, [][]byte{make([]byte, KeySize)}); err == nil {
+		t.Error("Wrap accepted a low order public key")
+	}
+
+	bob := identity(t, bobPrivate)
+
+	for _, size := range []int{0, 1, stanzaSize - 1, stanzaSize + 1} {
+		if _, err := bob.Unwrap(make([]byte, size)); err == nil {
+			t.Errorf("Unwrap accepted %v bytes of stanzas", size)
+		}
+	}
+
+	keys := []struct {
+		name string
+		key  string
+	}{
+		{"wrong prefix", PrivateKeyPrefix + base64.RawURLEncoding.EncodeToString(make([]byte, KeySize))},
+		{"short", PublicKeyPrefix + base64.RawURLEncoding.EncodeToString(make([]byte, KeySize-1))},
+		{"not base64", PublicKeyPrefix + "!!!"},
+		{"empty", ""},
+	}
+
+	for _, test := range keys {
+		if _, err := ParsePublicKey(test.key); err == nil {
+			t.Errorf("%v: ParsePublicKey accepted %q", test.name, test.key)
+		}
+	}
+}
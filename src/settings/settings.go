@@ -2,51 +2,119 @@ package settings
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
-	"github.com/BitlyTwiser/throw/src/notifications"
+	"github.com/BitlyTwiser/throw/src/kdf"
+	"github.com/BitlyTwiser/throw/src/keyring"
+	"github.com/BitlyTwiser/throw/src/policy"
+	"github.com/BitlyTwiser/throw/src/recipient"
+	"github.com/BitlyTwiser/throw/src/vault"
 )
 
-const settingsFilePath string = "../../settings.json"
+// Relative to the working directory unless UseFile is called.
+var settingsFilePath string = "../../settings.json"
+
+// Secrets are kept apart from the settings, encrypted under a master passphrase.
+var vaultFilePath string = "../../settings.vault"
+
+// UseFile reads and writes the settings at path instead of the default location, the vault is kept next to it.
+// It must be called before the settings are loaded.
+func UseFile(path string) {
+	settingsFilePath = path
+	vaultFilePath = strings.TrimSuffix(path, filepath.Ext(path)) + ".vault"
+}
 
 type Settings struct {
 	Host         string
 	Port         string
 	DownloadPath string
 	Encrypted    bool
-	Password     string
+	// Held within the vault, never written to the settings file.
+	Password string `json:"-"`
+	// Amount of uploads/downloads the transfer manager runs at once.
+	ConcurrentTransfers int
+	// Slowest expected link speed, transfer deadlines are derived from this and the file size.
+	MinThroughputKBps int
+	// Store file names encrypted under the encryption password, the server only sees opaque names.
+	EncryptFileNames bool
+	// Compression applied before encryption. One of: none, auto, gzip, zstd. Empty disables compression.
+	Compression string
+	// Key derivation function and cost used for newly encrypted files. Files record their own parameters.
+	KDF kdf.Params
+	// Named keys, Password remains the default key.
+	Keyring keyring.Keyring `json:"-"`
+	// Credentials sent to the host with every request, held within the vault.
+	HostUsername string `json:"-"`
+	HostPassword string `json:"-"`
+	AuthToken    string `json:"-"`
+	// X25519 private key of this user, held within the vault.
+	Identity string `json:"-"`
+	// Public keys of teammates files can be encrypted for.
+	AddressBook []recipient.Contact
+	// Rules every upload is checked against.
+	Policy policy.Policy
+	// Local folder whose files are uploaded as they change, empty to not watch any folder.
+	WatchFolder string
+	// upload or two-way, empty for upload. Two-way applies changes made on the server to the watched folder.
+	SyncMode string
+	// How files changed on both sides are resolved. One of: keep-both, prefer-local, prefer-remote, ask. Empty for keep-both.
+	ConflictStrategy string
+	// localhost address a WebDAV server of the store listens on, like localhost:8090. Empty to not serve WebDAV.
+	WebDAVAddress string
+	// localhost address the HTTP gateway streaming files to players and browsers listens on, like localhost:8091. Empty to not serve it.
+	GatewayAddress string
+	// Token the WebDAV server and gateway require from every request, held within the vault. Empty to serve them without one.
+	LocalToken string `json:"-"`
+	// Unlocked once per session, nil until then.
+	vault *vault.Vault
+	// Set when the settings file still holds the password written by an older version.
+	legacy bool
 }
 
 func (s Settings) CurrentSettings() Settings {
 	return s
 }
 
-func (s *Settings) SaveSettings(settings Settings) bool {
-	s.SaveSettingsMemory(&settings)
+// SaveSettings writes settings to the settings file and their secrets to the vault, replacing the settings held in memory.
+func (s *Settings) SaveSettings(settings Settings) error {
+	// Secrets only exist within the vault, saving while it is locked would lose them.
+	if settings.vault == nil {
+		return errors.New("unlock the vault before saving settings")
+	}
 
-	settings.Password = Base64EncodeString([]byte(settings.Password))
+	if err := settings.vault.Save(settings.secrets()); err != nil {
+		return fmt.Errorf("error saving secrets to the vault. Error: %w", err)
+	}
+
+	settings.legacy = false
+	s.SaveSettingsMemory(&settings)
 
 	file, err := os.OpenFile(settingsFilePath, os.O_TRUNC|os.O_RDWR, 0600)
 
 	if err != nil {
-		notifications.SendErrorNotification(fmt.Sprintf("error saving settings. Error: %v", err))
+		return fmt.Errorf("error saving settings. Error: %w", err)
 	}
 
+	defer file.Close()
+
 	j, err := json.MarshalIndent(&settings, "", "")
 
 	if err != nil {
-		notifications.SendErrorNotification(fmt.Sprintf("error saving settings. Error: %v", err))
+		return fmt.Errorf("error saving settings. Error: %w", err)
 	}
 
 	_, err = file.Write(j)
 
 	if err != nil {
-		notifications.SendErrorNotification(fmt.Sprintf("error writing settings to file. Error: %v", err))
+		return fmt.Errorf("error writing settings to file. Error: %w", err)
 	}
 
-	return true
+	return nil
 }
 
 func (s *Settings) SaveSettingsMemory(settings *Settings) {
@@ -63,7 +131,7 @@ func LoadSettings() *Settings {
 		file, err := os.OpenFile(settingsFilePath, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0600)
 
 		if err != nil {
-			notifications.SendErrorNotification(fmt.Sprintf("error loading settings. Error: %v", err))
+			log.Printf("Error loading settings. Error: %v", err)
 		}
 
 		defer file.Close()
@@ -71,13 +139,13 @@ func LoadSettings() *Settings {
 		j, err := json.MarshalIndent(Settings{}, "", "")
 
 		if err != nil {
-			notifications.SendErrorNotification(fmt.Sprintf("error loading settings. Error: %v", err))
+			log.Printf("Error loading settings. Error: %v", err)
 		}
 
 		n, err := file.Write(j)
 
 		if n == 0 || err != nil {
-			notifications.SendErrorNotification("Zero bytes written or error")
+			log.Printf("Error writing the settings file. Error: %v", err)
 		}
 
 		// At the end of it all, we return empty settings after making the file
@@ -96,14 +164,94 @@ func LoadSettings() *Settings {
 		log.Printf("Error unmarshalling data. Error: %v", err)
 	}
 
-	// Decode password here.
-	if s.Password != "" {
-		pass, err := DecodeString(s.Password)
+	// Older versions stored the password base64 encoded within the settings file. It is moved into the vault once it is unlocked.
+	var legacy struct {
+		Password string
+	}
+
+	if err := json.Unmarshal(fileData, &legacy); err == nil && legacy.Password != "" {
+		s.legacy = true
 
-		if err == nil {
+		if pass, err := DecodeString(legacy.Password); err == nil {
 			s.Password = pass
 		}
 	}
 
 	return s
 }
+
+// VaultExists reports if a vault has been created. Otherwise CreateVault must be called before secrets can be saved.
+func (s *Settings) VaultExists() bool {
+	return vault.Exists(vaultFilePath)
+}
+
+// Locked reports if the vault has not been unlocked this session.
+func (s *Settings) Locked() bool {
+	return s.vault == nil
+}
+
+// CreateVault creates the vault under passphrase, moving any secrets held within the settings file into it.
+func (s *Settings) CreateVault(passphrase string) error {
+	v, err := vault.Create(vaultFilePath, passphrase, s.KDF, s.secrets())
+
+	if err != nil {
+		return err
+	}
+
+	s.vault = v
+
+	if s.legacy {
+		if err := s.SaveSettings(*s); err != nil {
+			return fmt.Errorf("error removing secrets from the settings file. Error: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// UnlockVault opens the vault, the secrets are only kept in memory.
+func (s *Settings) UnlockVault(passphrase string) error {
+	v, secrets, err := vault.Unlock(vaultFilePath, passphrase)
+
+	if err != nil {
+		return err
+	}
+
+	// A password left within the settings file fills in the vault when it does not hold one yet.
+	if s.legacy && secrets.Password == "" {
+		secrets.Password = s.Password
+	}
+
+	s.vault = v
+	s.setSecrets(secrets)
+
+	if s.legacy {
+		if err := s.SaveSettings(*s); err != nil {
+			return fmt.Errorf("error removing secrets from the settings file. Error: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Settings) secrets() vault.Secrets {
+	return vault.Secrets{
+		Password:     s.Password,
+		Keyring:      s.Keyring,
+		HostUsername: s.HostUsername,
+		HostPassword: s.HostPassword,
+		AuthToken:    s.AuthToken,
+		Identity:     s.Identity,
+		LocalToken:   s.LocalToken,
+	}
+}
+
+func (s *Settings) setSecrets(secrets vault.Secrets) {
+	s.Password = secrets.Password
+	s.Keyring = secrets.Keyring
+	s.HostUsername = secrets.HostUsername
+	s.HostPassword = secrets.HostPassword
+	s.AuthToken = secrets.AuthToken
+	s.Identity = secrets.Identity
+	s.LocalToken = secrets.LocalToken
+}
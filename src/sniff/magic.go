@@ -0,0 +1,101 @@
+package sniff
+
+// A signature matches when the bytes at offset equal magic. A zero byte within mask skips the byte at that position.
+type signature struct {
+	offset int
+	magic  string
+	mask   string
+	mime   string
+}
+
+// Signatures are checked in order, more specific entries come first.
+var signatures = []signature{
+	// Executables
+	{magic: "\x7fELF", mime: "application/x-executable"},
+	{magic: "MZ", mime: "application/vnd.microsoft.portable-executable"},
+	{magic: "\xfe\xed\xfa\xce", mime: "application/x-mach-binary"},
+	{magic: "\xfe\xed\xfa\xcf", mime: "application/x-mach-binary"},
+	{magic: "\xce\xfa\xed\xfe", mime: "application/x-mach-binary"},
+	{magic: "\xcf\xfa\xed\xfe", mime: "application/x-mach-binary"},
+	// Universal Mach-O binaries share their magic with Java class files, the check below tells them apart.
+	{magic: "\xca\xfe\xba\xbe", mime: "application/x-mach-binary"},
+	{magic: "\x00asm", mime: "application/wasm"},
+
+	// Images
+	{magic: "\x89PNG\r\n\x1a\n", mime: "image/png"},
+	{magic: "\xff\xd8\xff", mime: "image/jpeg"},
+	{magic: "GIF87a", mime: "image/gif"},
+	{magic: "GIF89a", mime: "image/gif"},
+	{magic: "RIFF\x00\x00\x00\x00WEBP", mask: "\xff\xff\xff\xff\x00\x00\x00\x00\xff\xff\xff\xff", mime: "image/webp"},
+	{magic: "BM", mime: "image/bmp"},
+	{magic: "II*\x00", mime: "image/tiff"},
+	{magic: "MM\x00*", mime: "image/tiff"},
+	{magic: "\x00\x00\x01\x00", mime: "image/x-icon"},
+	{offset: 4, magic: "ftypheic", mime: "image/heic"},
+	{offset: 4, magic: "ftypavif", mime: "image/avif"},
+
+	// Audio and video
+	{magic: "ID3", mime: "audio/mpeg"},
+	{magic: "\xff\xfb", mime: "audio/mpeg"},
+	{magic: "OggS", mime: "audio/ogg"},
+	{magic: "fLaC", mime: "audio/flac"},
+	{magic: "RIFF\x00\x00\x00\x00WAVE", mask: "\xff\xff\xff\xff\x00\x00\x00\x00\xff\xff\xff\xff", mime: "audio/wav"},
+	{magic: "RIFF\x00\x00\x00\x00AVI ", mask: "\xff\xff\xff\xff\x00\x00\x00\x00\xff\xff\xff\xff", mime: "video/x-msvideo"},
+	{offset: 4, magic: "ftypM4A", mime: "audio/mp4"},
+	{offset: 4, magic: "ftypqt", mime: "video/quicktime"},
+	{offset: 4, magic: "ftyp", mime: "video/mp4"},
+	{magic: "\x1a\x45\xdf\xa3", mime: "video/x-matroska"},
+
+	// Documents
+	{magic: "%PDF-", mime: "application/pdf"},
+	{magic: "\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1", mime: "application/x-ole-storage"},
+	{magic: "SQLite format 3\x00", mime: "application/vnd.sqlite3"},
+
+	// Archives
+	{magic: "PK\x03\x04", mime: "application/zip"},
+	{magic: "PK\x05\x06", mime: "application/zip"},
+	{magic: "\x1f\x8b", mime: "application/gzip"},
+	{magic: "BZh", mime: "application/x-bzip2"},
+	{magic: "\xfd7zXZ\x00", mime: "application/x-xz"},
+	{magic: "7z\xbc\xaf\x27\x1c", mime: "application/x-7z-compressed"},
+	{magic: "Rar!\x1a\x07", mime: "application/vnd.rar"},
+	{magic: "\x28\xb5\x2f\xfd", mime: "application/zstd"},
+	{offset: 257, magic: "ustar", mime: "application/x-tar"},
+}
+
+// Zip based formats are told apart by their extension.
+var zipExtensions = map[string]string{
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".odt":  "application/vnd.oasis.opendocument.text",
+	".ods":  "application/vnd.oasis.opendocument.spreadsheet",
+	".jar":  "application/java-archive",
+	".apk":  "application/vnd.android.package-archive",
+	".epub": "application/epub+zip",
+}
+
+// Native code, files of these types are not encrypted.
+var executableTypes = map[string]bool{
+	"application/x-executable":                      true,
+	"application/vnd.microsoft.portable-executable": true,
+	"application/x-mach-binary":                     true,
+}
+
+func (s signature) match(head []byte) bool {
+	if len(head) < s.offset+len(s.magic) {
+		return false
+	}
+
+	for i := 0; i < len(s.magic); i++ {
+		if s.mask != "" && s.mask[i] == 0 {
+			continue
+		}
+
+		if head[s.offset+i] != s.magic[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,359 @@
+// Package sniff detects the content type of a file from its first bytes.
+// Binary formats are recognised by their magic numbers, anything else is checked for UTF-8 or UTF-16 text.
+// The file name is only used to tell apart formats sharing a magic number, or to name the kind of text.
+package sniff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// HeadSize is the number of leading bytes Detect looks at.
+const HeadSize = 512
+
+const (
+	UTF8    = "utf-8"
+	UTF16LE = "utf-16le"
+	UTF16BE = "utf-16be"
+)
+
+const (
+	Binary      = "application/octet-stream"
+	PlainText   = "text/plain"
+	ClassFile   = "application/java-vm"
+	mimeCharset = "charset"
+)
+
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16LE = []byte{0xff, 0xfe}
+	bomUTF16BE = []byte{0xfe, 0xff}
+)
+
+// Kinds of text told apart by extension. Text without a known extension is plain text.
+var textExtensions = map[string]string{
+	".txt":  "text/plain",
+	".log":  "text/plain",
+	".md":   "text/markdown",
+	".csv":  "text/csv",
+	".tsv":  "text/tab-separated-values",
+	".html": "text/html",
+	".htm":  "text/html",
+	".css":  "text/css",
+	".js":   "text/javascript",
+	".json": "application/json",
+	".xml":  "application/xml",
+	".svg":  "image/svg+xml",
+	".yaml": "application/yaml",
+	".yml":  "application/yaml",
+	".toml": "application/toml",
+	".sql":  "application/sql",
+	".sh":   "application/x-sh",
+	".go":   "text/x-go",
+	".py":   "text/x-python",
+}
+
+// Types outside of text/ that hold text.
+var textTypes = map[string]bool{
+	"application/json": true,
+	"application/xml":  true,
+	"image/svg+xml":    true,
+	"application/yaml": true,
+	"application/toml": true,
+	"application/sql":  true,
+	"application/x-sh": true,
+}
+
+var ErrNotText = errors.New("content is not text")
+
+// Detect returns the MIME type of a file, text types carry their charset. An empty file is plain text.
+func Detect(fileName string, head []byte) string {
+	if len(head) > HeadSize {
+		head = head[:HeadSize]
+	}
+
+	charset := textCharset(head)
+
+	for _, s := range signatures {
+		if !s.match(head) {
+			continue
+		}
+
+		// Two byte magics like "MZ" or "BM" are common at the start of text, a real file of that type is not valid text.
+		if len(s.magic) <= 2 && charset != "" {
+			continue
+		}
+
+		return refine(s.mime, fileName, head)
+	}
+
+	if charset == "" {
+		return Binary
+	}
+
+	return mime.FormatMediaType(textType(fileName, head, charset), map[string]string{mimeCharset: charset})
+}
+
+// ByName guesses the MIME type of a file from its extension alone. Used for files whose content was never seen by this client.
+func ByName(fileName string) string {
+	ext := strings.ToLower(filepath.Ext(fileName))
+
+	if t, ok := textExtensions[ext]; ok {
+		return t
+	}
+
+	if t, ok := zipExtensions[ext]; ok {
+		return t
+	}
+
+	if t := mime.TypeByExtension(ext); t != "" {
+		return MediaType(t)
+	}
+
+	return Binary
+}
+
+// MediaType strips the parameters from a MIME type.
+func MediaType(mimeType string) string {
+	t, _, _ := strings.Cut(mimeType, ";")
+
+	return strings.ToLower(strings.TrimSpace(t))
+}
+
+// Charset returns the charset parameter of a MIME type, or an empty string.
+func Charset(mimeType string) string {
+	_, params, err := mime.ParseMediaType(mimeType)
+
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(params[mimeCharset])
+}
+
+// IsText reports if a MIME type denotes text.
+func IsText(mimeType string) bool {
+	t := MediaType(mimeType)
+
+	return strings.HasPrefix(t, "text/") || textTypes[t]
+}
+
+// IsExecutable reports if a MIME type denotes native code.
+func IsExecutable(mimeType string) bool {
+	return executableTypes[MediaType(mimeType)]
+}
+
+// Category returns the top level kind of a MIME type, used to pick icons: text, image, audio, video, or application.
+func Category(mimeType string) string {
+	if IsText(mimeType) {
+		return "text"
+	}
+
+	t := MediaType(mimeType)
+
+	if t == "" || t == Binary {
+		return ""
+	}
+
+	category, _, _ := strings.Cut(t, "/")
+
+	return category
+}
+
+// ToUTF8 returns text of the given charset as UTF-8 without a byte order mark.
+func ToUTF8(data []byte, charset string) ([]byte, error) {
+	switch charset {
+	case UTF16LE, UTF16BE:
+		var order binary.ByteOrder = binary.LittleEndian
+		bom := bomUTF16LE
+
+		if charset == UTF16BE {
+			order = binary.BigEndian
+			bom = bomUTF16BE
+		}
+
+		data = bytes.TrimPrefix(data, bom)
+		units := make([]uint16, len(data)/2)
+
+		for i := range units {
+			units[i] = order.Uint16(data[i*2:])
+		}
+
+		return []byte(string(utf16.Decode(units))), nil
+	case UTF8, "":
+		data = bytes.TrimPrefix(data, bomUTF8)
+
+		if !utf8.Valid(data) {
+			return nil, ErrNotText
+		}
+
+		return data, nil
+	}
+
+	return nil, ErrNotText
+}
+
+// FromUTF8 encodes UTF-8 text in the given charset. UTF-16 text is written with a byte order mark.
+func FromUTF8(text []byte, charset string) []byte {
+	switch charset {
+	case UTF16LE, UTF16BE:
+		var order binary.ByteOrder = binary.LittleEndian
+		bom := bomUTF16LE
+
+		if charset == UTF16BE {
+			order = binary.BigEndian
+			bom = bomUTF16BE
+		}
+
+		units := utf16.Encode([]rune(string(text)))
+		out := make([]byte, len(bom)+len(units)*2)
+		copy(out, bom)
+
+		for i, unit := range units {
+			order.PutUint16(out[len(bom)+i*2:], unit)
+		}
+
+		return out
+	}
+
+	return text
+}
+
+// Returns the charset of the head, or an empty string when it is not text.
+func textCharset(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, bomUTF8):
+		return validUTF8(head[len(bomUTF8):])
+	case bytes.HasPrefix(head, bomUTF16LE):
+		return validUTF16(head[len(bomUTF16LE):], binary.LittleEndian, UTF16LE)
+	case bytes.HasPrefix(head, bomUTF16BE):
+		return validUTF16(head[len(bomUTF16BE):], binary.BigEndian, UTF16BE)
+	}
+
+	// UTF-16 without a byte order mark, ASCII within it leaves every other byte zero.
+	if len(head) >= 4 {
+		var even, odd int
+
+		for i := 0; i+1 < len(head); i += 2 {
+			if head[i] == 0 {
+				even++
+			}
+
+			if head[i+1] == 0 {
+				odd++
+			}
+		}
+
+		pairs := len(head) / 2
+
+		if odd*2 > pairs && even == 0 {
+			return validUTF16(head, binary.LittleEndian, UTF16LE)
+		}
+
+		if even*2 > pairs && odd == 0 {
+			return validUTF16(head, binary.BigEndian, UTF16BE)
+		}
+	}
+
+	return validUTF8(head)
+}
+
+func validUTF8(head []byte) string {
+	// The head may end within a multi byte rune.
+	for i := 0; i < utf8.UTFMax-1 && len(head) > 0; i++ {
+		if utf8.Valid(head) {
+			break
+		}
+
+		if r, _ := utf8.DecodeLastRune(head); r != utf8.RuneError {
+			break
+		}
+
+		head = head[:len(head)-1]
+	}
+
+	if !utf8.Valid(head) {
+		return ""
+	}
+
+	for _, r := range string(head) {
+		if !textRune(r) {
+			return ""
+		}
+	}
+
+	return UTF8
+}
+
+func validUTF16(head []byte, order binary.ByteOrder, charset string) string {
+	units := make([]uint16, len(head)/2)
+
+	for i := range units {
+		units[i] = order.Uint16(head[i*2:])
+	}
+
+	// The head may end between the halves of a surrogate pair.
+	if n := len(units); n > 0 && utf16.IsSurrogate(rune(units[n-1])) {
+		units = units[:n-1]
+	}
+
+	for _, r := range utf16.Decode(units) {
+		if r == utf8.RuneError || !textRune(r) {
+			return ""
+		}
+	}
+
+	return charset
+}
+
+// Control characters other than whitespace and escape sequences do not appear in text.
+func textRune(r rune) bool {
+	if r >= 0x20 && r != 0x7f {
+		return true
+	}
+
+	switch r {
+	case '\t', '\n', '\r', '\f', '\v', '\b', 0x1b:
+		return true
+	}
+
+	return false
+}
+
+func textType(fileName string, head []byte, charset string) string {
+	if t, ok := textExtensions[strings.ToLower(filepath.Ext(fileName))]; ok {
+		return t
+	}
+
+	if charset == UTF8 {
+		if t := MediaType(http.DetectContentType(head)); IsText(t) {
+			return t
+		}
+	}
+
+	return PlainText
+}
+
+// Tells apart formats sharing a magic number.
+func refine(mimeType, fileName string, head []byte) string {
+	switch mimeType {
+	case "application/zip":
+		if t, ok := zipExtensions[strings.ToLower(filepath.Ext(fileName))]; ok {
+			return t
+		}
+	case "application/x-mach-binary":
+		// Universal binaries hold a small architecture count where class files hold their version, 45 or above.
+		if bytes.HasPrefix(head, []byte("\xca\xfe\xba\xbe")) && len(head) >= 8 && binary.BigEndian.Uint32(head[4:8]) >= 45 {
+			return ClassFile
+		}
+	}
+
+	return mimeType
+}
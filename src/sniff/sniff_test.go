@@ -0,0 +1,143 @@
+package sniff
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	tar := make([]byte, 512)
+	copy(tar[257:], "ustar\x0000")
+
+	tests := []struct {
+		name     string
+		fileName string
+		head     string
+		want     string
+	}{
+		{"empty", "", "", "text/plain; charset=utf-8"},
+		{"plain text", "notes", "hello world\n", "text/plain; charset=utf-8"},
+		{"text by extension", "data.json", `{"a": 1}`, "application/json; charset=utf-8"},
+		{"html without extension", "page", "<!DOCTYPE html><html></html>", "text/html; charset=utf-8"},
+		{"control characters", "data", "abc\x00\x01\x02def", Binary},
+		{"utf-8 bom", "a.txt", "\xef\xbb\xbfhello", "text/plain; charset=utf-8"},
+		{"utf-16le bom", "a.txt", "\xff\xfeh\x00i\x00", "text/plain; charset=utf-16le"},
+		{"utf-16be bom", "a.txt", "\xfe\xff\x00h\x00i", "text/plain; charset=utf-16be"},
+		{"utf-16le without bom", "a.txt", "h\x00e\x00l\x00l\x00o\x00", "text/plain; charset=utf-16le"},
+		{"utf-16be without bom", "a.txt", "\x00h\x00e\x00l\x00l\x00o", "text/plain; charset=utf-16be"},
+
+		// Two byte magics at the start of text are text.
+		{"MZ in text", "notes.txt", "MZ is a postcode area\n", "text/plain; charset=utf-8"},
+		{"BM in text", "cars", "BMW and Audi\n", "text/plain; charset=utf-8"},
+		{"PE executable", "setup.exe", "MZ\x90\x00\x03\x00\x00\x00", "application/vnd.microsoft.portable-executable"},
+		{"bitmap", "a.bmp", "BM\x36\x00\x0c\x00\x00\x00", "image/bmp"},
+
+		// Universal Mach-O binaries and class files share their magic.
+		{"universal mach-o", "tool", "\xca\xfe\xba\xbe\x00\x00\x00\x02", "application/x-mach-binary"},
+		{"class file", "Main.class", "\xca\xfe\xba\xbe\x00\x00\x00\x34", ClassFile},
+		{"mach-o 64", "tool", "\xcf\xfa\xed\xfe\x07\x00\x00\x01", "application/x-mach-binary"},
+		{"elf", "tool", "\x7fELF\x02\x01\x01", "application/x-executable"},
+
+		{"png", "photo", "\x89PNG\r\n\x1a\n\x00\x00", "image/png"},
+		{"jpeg", "photo", "\xff\xd8\xff\xe0\x00\x10JFIF", "image/jpeg"},
+		{"webp", "photo", "RIFF\x10\x20\x00\x00WEBPVP8 ", "image/webp"},
+		{"wav", "sound", "RIFF\x10\x20\x00\x00WAVEfmt ", "audio/wav"},
+		{"heic", "photo", "\x00\x00\x00\x18ftypheic", "image/heic"},
+		{"mp4", "video", "\x00\x00\x00\x18ftypisom", "video/mp4"},
+		{"pdf", "doc", "%PDF-1.7\n", "application/pdf"},
+		{"zip", "files.zip", "PK\x03\x04\x14\x00", "application/zip"},
+		{"docx", "Report.DOCX", "PK\x03\x04\x14\x00", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{"tar", "files.tar", string(tar), "application/x-tar"},
+		{"head shorter than the magic", "photo", "\x89PN", Binary},
+	}
+
+	for _, test := range tests {
+		if got := Detect(test.fileName, []byte(test.head)); got != test.want {
+			t.Errorf("%v: Detect = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+// Only HeadSize bytes are looked at, a rune cut at its end does not make the text binary.
+func TestDetectLongHead(t *testing.T) {
+	text := strings.Repeat("a", HeadSize-1) + "é and more"
+
+	if got := Detect("a.txt", []byte(text)); got != "text/plain; charset=utf-8" {
+		t.Errorf("Detect of text with a rune across the head = %q", got)
+	}
+
+	binary := append(bytes.Repeat([]byte("a"), HeadSize), 0, 1, 2)
+
+	if got := Detect("a.txt", binary); got != "text/plain; charset=utf-8" {
+		t.Errorf("Detect looked past the head, got %q", got)
+	}
+}
+
+func TestMIMEHelpers(t *testing.T) {
+	tests := []struct {
+		mimeType   string
+		mediaType  string
+		charset    string
+		text       bool
+		executable bool
+		category   string
+	}{
+		{"text/plain; charset=UTF-16LE", "text/plain", "utf-16le", true, false, "text"},
+		{"application/json; charset=utf-8", "application/json", "utf-8", true, false, "text"},
+		{"image/svg+xml", "image/svg+xml", "", true, false, "text"},
+		{"image/png", "image/png", "", false, false, "image"},
+		{"application/x-mach-binary", "application/x-mach-binary", "", false, true, "application"},
+		{Binary, Binary, "", false, false, ""},
+	}
+
+	for _, test := range tests {
+		if got := MediaType(test.mimeType); got != test.mediaType {
+			t.Errorf("MediaType(%q) = %q", test.mimeType, got)
+		}
+
+		if got := Charset(test.mimeType); got != test.charset {
+			t.Errorf("Charset(%q) = %q", test.mimeType, got)
+		}
+
+		if IsText(test.mimeType) != test.text || IsExecutable(test.mimeType) != test.executable {
+			t.Errorf("%q: IsText = %v, IsExecutable = %v", test.mimeType, IsText(test.mimeType), IsExecutable(test.mimeType))
+		}
+
+		if got := Category(test.mimeType); got != test.category {
+			t.Errorf("Category(%q) = %q", test.mimeType, got)
+		}
+	}
+
+	if got := ByName("report.docx"); got != zipExtensions[".docx"] {
+		t.Errorf("ByName of a docx = %q", got)
+	}
+
+	if got := ByName("notes.MD"); got != "text/markdown" {
+		t.Errorf("ByName of a markdown file = %q", got)
+	}
+
+	if got := ByName("unknown.xyz123"); got != Binary {
+		t.Errorf("ByName of an unknown extension = %q", got)
+	}
+}
+
+func TestUTF16RoundTrip(t *testing.T) {
+	text := []byte("naïve café, 𝄞 clef")
+
+	for _, charset := range []string{UTF8, UTF16LE, UTF16BE} {
+		encoded := FromUTF8(text, charset)
+
+		if Charset(Detect("a.txt", encoded)) != charset {
+			t.Errorf("%v: Detect = %q", charset, Detect("a.txt", encoded))
+		}
+
+		if got, err := ToUTF8(encoded, charset); err != nil || !bytes.Equal(got, text) {
+			t.Errorf("%v: ToUTF8 = %q, %v", charset, got, err)
+		}
+	}
+
+	if _, err := ToUTF8([]byte{0xff, 0xfe, 0xfd}, UTF8); err != ErrNotText {
+		t.Errorf("ToUTF8 of invalid UTF-8 = %v, want ErrNotText", err)
+	}
+}
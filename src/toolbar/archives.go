@@ -0,0 +1,117 @@
+package toolbar
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/BitlyTwiser/throw/src/archive"
+	"github.com/BitlyTwiser/throw/src/notifications"
+	"github.com/BitlyTwiser/throw/src/pufs_client"
+)
+
+// Asks for a packed archive, then lists the files within it from its index.
+func BrowseArchive(ctx context.Context, window fyne.Window, client *pufs_client.IpfsClient) {
+	archives, err := client.Archives(ctx)
+
+	if err != nil {
+		notifications.SendErrorNotification(fmt.Sprintf("Error listing archives. Error: %v", err))
+
+		return
+	}
+
+	if len(archives) == 0 {
+		notifications.SendErrorNotification("No archives are stored, pack a folder when uploading it first")
+
+		return
+	}
+
+	name := widget.NewSelect(archives, nil)
+	name.SetSelected(archives[0])
+
+	dialog.NewForm("Browse archive", "Open", "Cancel", []*widget.FormItem{widget.NewFormItem("Archive", name)}, func(submitted bool) {
+		if !submitted {
+			return
+		}
+
+		go func() {
+			index, err := client.ArchiveIndex(ctx, name.Selected)
+
+			if err != nil {
+				notifications.SendErrorNotification(fmt.Sprintf("Error reading the index of %v. Error: %v", name.Selected, err))
+
+				return
+			}
+
+			archiveWindow(ctx, client, name.Selected, index)
+		}()
+	}, window).Show()
+}
+
+// Lists the files of an archive, each can be extracted into the download path on its own. Files within the download path are only replaced when asked to.
+// Only the archive up to the end of the file is downloaded, files early within it are extracted fastest.
+func archiveWindow(ctx context.Context, client *pufs_client.IpfsClient, name string, index *archive.Index) {
+	archiveWindow := fyne.CurrentApp().NewWindow(name)
+	archiveWindow.Resize(fyne.NewSize(700, 400))
+
+	entries := index.Entries
+
+	filter := widget.NewEntry()
+	filter.SetPlaceHolder("Filter by path")
+
+	overwrite := widget.NewCheck("Overwrite existing files", nil)
+
+	entryList := widget.NewList(
+		func() int { return len(entries) },
+		func() fyne.CanvasObject {
+			extractButton := widget.NewButtonWithIcon("Extract", theme.DownloadIcon(), nil)
+
+			return container.NewBorder(nil, nil, nil, extractButton, widget.NewLabel(""))
+		},
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			if i >= len(entries) {
+				return
+			}
+
+			e := entries[i]
+			row := o.(*fyne.Container)
+
+			row.Objects[0].(*widget.Label).SetText(fmt.Sprintf("%v (%v bytes, %v)", e.Name, e.Size, e.ModTime.Local().Format("2006-01-02 15:04")))
+			row.Objects[1].(*widget.Button).OnTapped = func() {
+				go func() {
+					path, err := client.ExtractArchiveEntry(ctx, name, index, e.Name, client.Settings.DownloadPath, overwrite.Checked)
+
+					if err != nil {
+						notifications.SendErrorNotification(fmt.Sprintf("Error extracting %v. Error: %v", e.Name, err))
+
+						return
+					}
+
+					notifications.SendSuccessNotification(fmt.Sprintf("%v extracted to %v", e.Name, path))
+				}()
+			}
+		},
+	)
+
+	filter.OnChanged = func(text string) {
+		entries = nil
+
+		for _, e := range index.Entries {
+			if strings.Contains(e.Name, text) {
+				entries = append(entries, e)
+			}
+		}
+
+		entryList.Refresh()
+	}
+
+	summary := widget.NewLabel(fmt.Sprintf("%v files, %v bytes packed on %v", len(index.Entries), index.Size, index.CreatedAt.Local().Format("2006-01-02 15:04")))
+
+	archiveWindow.SetContent(container.NewBorder(container.NewVBox(summary, filter, overwrite), nil, nil, nil, entryList))
+	archiveWindow.Show()
+}
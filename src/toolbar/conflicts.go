@@ -0,0 +1,98 @@
+package toolbar
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/BitlyTwiser/throw/src/notifications"
+	"github.com/BitlyTwiser/throw/src/watcher"
+)
+
+// Lists the files changed on both sides of the watch folder, waiting for the user to pick a side.
+// Only the ask conflict strategy leaves conflicts for the user.
+func ConflictsWindow(w *watcher.Watcher) {
+	conflictsWindow := fyne.CurrentApp().NewWindow("Sync Conflicts")
+	conflictsWindow.Resize(fyne.NewSize(700, 400))
+
+	var mutex sync.Mutex
+	conflicts := w.Conflicts()
+
+	conflictList := widget.NewList(
+		func() int {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			return len(conflicts)
+		},
+		func() fyne.CanvasObject {
+			localButton := widget.NewButtonWithIcon("Keep local", theme.ComputerIcon(), nil)
+			remoteButton := widget.NewButtonWithIcon("Keep remote", theme.StorageIcon(), nil)
+			bothButton := widget.NewButtonWithIcon("Keep both", theme.ContentCopyIcon(), nil)
+
+			return container.NewBorder(nil, nil, nil, container.NewHBox(localButton, remoteButton, bothButton), widget.NewLabel(""))
+		},
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			mutex.Lock()
+			if i >= len(conflicts) {
+				mutex.Unlock()
+
+				return
+			}
+			c := conflicts[i]
+			mutex.Unlock()
+
+			row := o.(*fyne.Container)
+			row.Objects[0].(*widget.Label).SetText(fmt.Sprintf("%v (%v)", c, c.DetectedAt.Format("2006-01-02 15:04")))
+
+			buttons := row.Objects[1].(*fyne.Container).Objects
+			buttons[0].(*widget.Button).OnTapped = func() { resolve(w, c.Path, watcher.PreferLocal) }
+			buttons[1].(*widget.Button).OnTapped = func() { resolve(w, c.Path, watcher.PreferRemote) }
+			buttons[2].(*widget.Button).OnTapped = func() { resolve(w, c.Path, watcher.KeepBoth) }
+		},
+	)
+
+	refresh := func() {
+		mutex.Lock()
+		conflicts = w.Conflicts()
+		mutex.Unlock()
+
+		conflictList.Refresh()
+	}
+
+	// Resolving runs in the background, the list catches up once the watcher publishes the result.
+	done := make(chan struct{})
+	conflictsWindow.SetOnClosed(func() { close(done) })
+
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-w.Updates:
+				refresh()
+			case <-ticker.C:
+				refresh()
+			}
+		}
+	}()
+
+	help := widget.NewLabel("Keep local uploads the local file, keep remote downloads the stored copy, keep both saves the stored copy next to the local file under a conflict name.")
+	help.Wrapping = fyne.TextWrapWord
+
+	conflictsWindow.SetContent(container.NewBorder(help, nil, nil, nil, conflictList))
+	conflictsWindow.Show()
+}
+
+func resolve(w *watcher.Watcher, path, strategy string) {
+	if err := w.Resolve(path, strategy); err != nil {
+		notifications.SendErrorNotification(err.Error())
+	}
+}
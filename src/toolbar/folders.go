@@ -0,0 +1,170 @@
+package toolbar
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"github.com/BitlyTwiser/throw/src/compression"
+	"github.com/BitlyTwiser/throw/src/notifications"
+	"github.com/BitlyTwiser/throw/src/pufs_client"
+	"github.com/BitlyTwiser/throw/src/transfers"
+	"github.com/BitlyTwiser/throw/src/tree"
+)
+
+// Uploads every file of a chosen folder, sub folders included, keeping their relative paths within the stored names.
+// The folder can be packed into a single tar instead, far faster for many small files. Its files are browsed with BrowseArchive.
+// The transfers panel shows the progress of the whole folder, and lists the files that failed once it is done.
+func UploadFolder(window fyne.Window, manager *transfers.TransferManager) {
+	dialog.NewFolderOpen(func(f fyne.ListableURI, _ error) {
+		if f == nil {
+			log.Println("No folder selected")
+
+			return
+		}
+
+		name := widget.NewEntry()
+		name.SetText(f.Name())
+
+		include, exclude := patternEntries()
+
+		compressionSelect := widget.NewSelect([]string{compression.None, compression.Gzip, compression.Zstd}, nil)
+		compressionSelect.SetSelected(compression.None)
+		compressionSelect.Disable()
+
+		pack := widget.NewCheck("", func(checked bool) {
+			if checked {
+				compressionSelect.Enable()
+			} else {
+				compressionSelect.Disable()
+			}
+		})
+
+		items := []*widget.FormItem{
+			widget.NewFormItem("Stored As", name),
+			widget.NewFormItem("Include", include),
+			widget.NewFormItem("Exclude", exclude),
+			widget.NewFormItem("Pack Into Archive", pack),
+			widget.NewFormItem("Archive Compression", compressionSelect),
+		}
+
+		dialog.NewForm("Upload "+f.Name(), "Upload", "Cancel", items, func(submitted bool) {
+			if !submitted {
+				return
+			}
+
+			filter, ok := folderFilter(include, exclude)
+
+			if !ok {
+				return
+			}
+
+			if !pack.Checked {
+				manager.UploadFolder(f.Path(), name.Text, filter)
+
+				return
+			}
+
+			archiveName := name.Text
+
+			if !strings.HasSuffix(archiveName, ".tar") {
+				archiveName += ".tar"
+			}
+
+			manager.Pack(f.Path(), archiveName, filter, compressionSelect.Selected)
+		}, window).Show()
+	}, window).Show()
+}
+
+// Downloads every file stored under a folder into the download path, rebuilding the folders within it.
+func DownloadFolder(ctx context.Context, window fyne.Window, client *pufs_client.IpfsClient, manager *transfers.TransferManager) {
+	files, err := client.List(ctx)
+
+	if err != nil {
+		notifications.SendErrorNotification(fmt.Sprintf("Error listing files. Error: %v", err))
+
+		return
+	}
+
+	folders := remoteFolders(client, files)
+
+	if len(folders) == 0 {
+		notifications.SendErrorNotification("No folders are stored, upload a folder first")
+
+		return
+	}
+
+	name := widget.NewSelectEntry(folders)
+	name.SetText(folders[0])
+
+	include, exclude := patternEntries()
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("Folder", name),
+		widget.NewFormItem("Include", include),
+		widget.NewFormItem("Exclude", exclude),
+	}
+
+	dialog.NewForm("Download folder", "Download", "Cancel", items, func(submitted bool) {
+		if !submitted {
+			return
+		}
+
+		filter, ok := folderFilter(include, exclude)
+
+		if !ok {
+			return
+		}
+
+		manager.DownloadFolder(name.Text, filter)
+	}, window).Show()
+}
+
+func patternEntries() (*widget.Entry, *widget.Entry) {
+	include := widget.NewEntry()
+	include.SetPlaceHolder("Everything, or patterns like *.go, docs/")
+
+	exclude := widget.NewEntry()
+	exclude.SetPlaceHolder("Patterns like node_modules, *.log")
+
+	return include, exclude
+}
+
+func folderFilter(include, exclude *widget.Entry) (tree.Filter, bool) {
+	filter := tree.Filter{Include: tree.ParsePatterns(include.Text), Exclude: tree.ParsePatterns(exclude.Text)}
+
+	if err := filter.Validate(); err != nil {
+		notifications.SendErrorNotification(err.Error())
+
+		return filter, false
+	}
+
+	return filter, true
+}
+
+// Returns every folder holding stored files, those within other folders included, ordered by name.
+func remoteFolders(client *pufs_client.IpfsClient, files []pufs_client.FileData) []string {
+	seen := make(map[string]bool)
+
+	for _, f := range files {
+		for dir := path.Dir(client.DisplayName(f.FileName)); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			seen[dir] = true
+		}
+	}
+
+	var folders []string
+
+	for folder := range seen {
+		folders = append(folders, folder)
+	}
+
+	sort.Strings(folders)
+
+	return folders
+}
@@ -0,0 +1,172 @@
+package toolbar
+
+import (
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/BitlyTwiser/throw/src/keyring"
+	"github.com/BitlyTwiser/throw/src/notifications"
+	"github.com/BitlyTwiser/throw/src/settings"
+	"github.com/BitlyTwiser/throw/src/transfers"
+)
+
+// Lists the keys within the keyring. New files are encrypted with the active key, rotating re-encrypts every stored file with a key.
+// The default key is the encryption password from the settings.
+func KeyringWindow(s *settings.Settings, manager *transfers.TransferManager) {
+	keyringWindow := fyne.CurrentApp().NewWindow("Keys")
+	keyringWindow.Resize(fyne.NewSize(600, 400))
+
+	// The default key is listed first, it has no entry within the keyring.
+	keys := func() []keyring.Key {
+		return append([]keyring.Key{{Id: keyring.DefaultKeyId, Name: s.Keyring.Name(keyring.DefaultKeyId)}}, s.Keyring.Keys...)
+	}
+
+	var keyList *widget.List
+
+	save := func(k keyring.Keyring) {
+		newSettings := *s
+		newSettings.Keyring = k
+
+		if err := s.SaveSettings(newSettings); err != nil {
+			notifications.SendErrorNotification(fmt.Sprintf("Error saving keys. Error: %v", err))
+		}
+
+		keyList.Refresh()
+	}
+
+	keyList = widget.NewList(
+		func() int { return len(keys()) },
+		func() fyne.CanvasObject {
+			activeButton := widget.NewButtonWithIcon("", theme.ConfirmIcon(), nil)
+			rotateButton := widget.NewButtonWithIcon("", theme.ViewRefreshIcon(), nil)
+			removeButton := widget.NewButtonWithIcon("", theme.DeleteIcon(), nil)
+
+			return container.NewGridWithColumns(
+				3,
+				widget.NewLabel(""),
+				widget.NewLabel(""),
+				container.NewHBox(activeButton, rotateButton, removeButton),
+			)
+		},
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			all := keys()
+
+			if i >= len(all) {
+				return
+			}
+
+			key := all[i]
+			row := o.(*fyne.Container)
+
+			name := key.Name
+
+			if key.Id == s.Keyring.Active {
+				name = fmt.Sprintf("%v (active)", name)
+			}
+
+			row.Objects[0].(*widget.Label).SetText(name)
+			row.Objects[1].(*widget.Label).SetText(key.CreatedAt)
+
+			buttons := row.Objects[2].(*fyne.Container).Objects
+			activeButton := buttons[0].(*widget.Button)
+			rotateButton := buttons[1].(*widget.Button)
+			removeButton := buttons[2].(*widget.Button)
+
+			activeButton.OnTapped = func() {
+				k := s.Keyring.Copy()
+
+				if err := k.SetActive(key.Id); err != nil {
+					notifications.SendErrorNotification(err.Error())
+
+					return
+				}
+
+				save(k)
+			}
+
+			rotateButton.OnTapped = func() {
+				rotateDialog(keyringWindow, manager, key)
+			}
+
+			removeButton.OnTapped = func() {
+				message := fmt.Sprintf("Files encrypted with %v can no longer be downloaded once it is removed.\nRotate them to another key first. Remove the key?", key.Name)
+
+				dialog.NewConfirm("Remove key", message, func(confirmed bool) {
+					if !confirmed {
+						return
+					}
+
+					k := s.Keyring.Copy()
+					k.Remove(key.Id)
+					save(k)
+				}, keyringWindow).Show()
+			}
+
+			setEnabled(activeButton, key.Id != s.Keyring.Active)
+			setEnabled(removeButton, key.Id != keyring.DefaultKeyId)
+		},
+	)
+
+	name := widget.NewEntry()
+	name.SetPlaceHolder("Key name...")
+
+	password := widget.NewPasswordEntry()
+	password.SetPlaceHolder("password...")
+
+	addButton := widget.NewButtonWithIcon("Add key", theme.ContentAddIcon(), func() {
+		key, err := keyring.NewKey(name.Text, password.Text)
+
+		if err != nil {
+			notifications.SendErrorNotification(err.Error())
+
+			return
+		}
+
+		k := s.Keyring.Copy()
+
+		if err := k.Add(key); err != nil {
+			notifications.SendErrorNotification(err.Error())
+
+			return
+		}
+
+		save(k)
+		name.SetText("")
+		password.SetText("")
+	})
+
+	add := container.NewGridWithColumns(3, name, password, addButton)
+	help := widget.NewLabel("Set active: encrypt new files with the key. Rotate: re-encrypt every stored file with the key.")
+	help.Wrapping = fyne.TextWrapWord
+
+	keyringWindow.SetContent(container.NewBorder(help, add, nil, nil, keyList))
+	keyringWindow.Show()
+}
+
+// Confirms a key rotation, optionally encrypting files currently stored as plaintext as well.
+func rotateDialog(window fyne.Window, manager *transfers.TransferManager, key keyring.Key) {
+	includePlaintext := widget.NewCheck("Also encrypt files stored as plaintext", nil)
+
+	message := widget.NewLabel(fmt.Sprintf("Every file will be downloaded, encrypted with %v and uploaded again.\nAn interrupted rotation continues when it is started again.", key.Name))
+	message.Wrapping = fyne.TextWrapWord
+
+	dialog.NewCustomConfirm("Rotate key", "Rotate", "Cancel", container.NewVBox(message, includePlaintext), func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+
+		manager.Rotate(key.Id, key.Name, includePlaintext.Checked)
+	}, window).Show()
+}
+
+func setEnabled(b *widget.Button, enabled bool) {
+	if enabled {
+		b.Enable()
+	} else {
+		b.Disable()
+	}
+}
@@ -0,0 +1,216 @@
+package toolbar
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/BitlyTwiser/throw/src/notifications"
+	"github.com/BitlyTwiser/throw/src/recipient"
+	"github.com/BitlyTwiser/throw/src/settings"
+	"github.com/BitlyTwiser/throw/src/transfers"
+)
+
+// Lists the public keys of teammates files can be encrypted for, along with the public key of this user to share with them.
+func AddressBookWindow(s *settings.Settings) {
+	addressBookWindow := fyne.CurrentApp().NewWindow("Address Book")
+	addressBookWindow.Resize(fyne.NewSize(600, 400))
+
+	var contactList *widget.List
+
+	save := func(newSettings settings.Settings) {
+		if err := s.SaveSettings(newSettings); err != nil {
+			notifications.SendErrorNotification(fmt.Sprintf("Error saving address book. Error: %v", err))
+		}
+
+		contactList.Refresh()
+	}
+
+	contactList = widget.NewList(
+		func() int { return len(s.AddressBook) },
+		func() fyne.CanvasObject {
+			return container.NewBorder(nil, nil, widget.NewLabel(""), widget.NewButtonWithIcon("", theme.DeleteIcon(), nil), widget.NewLabel(""))
+		},
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			if i >= len(s.AddressBook) {
+				return
+			}
+
+			contact := s.AddressBook[i]
+			row := o.(*fyne.Container)
+
+			row.Objects[0].(*widget.Label).SetText(contact.PublicKey)
+			row.Objects[1].(*widget.Label).SetText(contact.Name)
+			row.Objects[2].(*widget.Button).OnTapped = func() {
+				newSettings := *s
+				newSettings.AddressBook = nil
+
+				for _, c := range s.AddressBook {
+					if c.Name != contact.Name {
+						newSettings.AddressBook = append(newSettings.AddressBook, c)
+					}
+				}
+
+				save(newSettings)
+			}
+		},
+	)
+
+	name := widget.NewEntry()
+	name.SetPlaceHolder("Name...")
+
+	publicKey := widget.NewEntry()
+	publicKey.SetPlaceHolder(fmt.Sprintf("Public key, starts with %v...", recipient.PublicKeyPrefix))
+
+	addButton := widget.NewButtonWithIcon("Add", theme.ContentAddIcon(), func() {
+		if name.Text == "" {
+			notifications.SendErrorNotification("A recipient needs a name")
+
+			return
+		}
+
+		if _, err := recipient.ParsePublicKey(publicKey.Text); err != nil {
+			notifications.SendErrorNotification(fmt.Sprintf("Invalid public key. Error: %v", err))
+
+			return
+		}
+
+		for _, c := range s.AddressBook {
+			if c.Name == name.Text {
+				notifications.SendErrorNotification(fmt.Sprintf("A recipient named %v already exists", name.Text))
+
+				return
+			}
+		}
+
+		newSettings := *s
+		newSettings.AddressBook = append(append([]recipient.Contact{}, s.AddressBook...), recipient.Contact{Name: name.Text, PublicKey: publicKey.Text})
+
+		save(newSettings)
+		name.SetText("")
+		publicKey.SetText("")
+	})
+
+	// The private key stays within the vault, only the public key is shown.
+	ownKey := widget.NewEntry()
+	ownKey.Disable()
+
+	copyButton := widget.NewButtonWithIcon("Copy", theme.ContentCopyIcon(), func() {
+		addressBookWindow.Clipboard().SetContent(ownKey.Text)
+	})
+
+	var generateButton *widget.Button
+	generateButton = widget.NewButtonWithIcon("Generate key pair", theme.ContentAddIcon(), func() {
+		private, err := recipient.Generate()
+
+		if err != nil {
+			notifications.SendErrorNotification(fmt.Sprintf("Error generating key pair. Error: %v", err))
+
+			return
+		}
+
+		newSettings := *s
+		newSettings.Identity = private
+
+		if err := s.SaveSettings(newSettings); err != nil {
+			notifications.SendErrorNotification(fmt.Sprintf("Error saving key pair. Error: %v", err))
+
+			return
+		}
+
+		showOwnKey(s, ownKey, copyButton, generateButton)
+	})
+
+	showOwnKey(s, ownKey, copyButton, generateButton)
+
+	own := container.NewBorder(nil, nil, widget.NewLabel("Your public key"), container.NewHBox(copyButton, generateButton), ownKey)
+	add := container.NewBorder(nil, nil, name, addButton, publicKey)
+
+	addressBookWindow.SetContent(container.NewBorder(own, add, nil, nil, contactList))
+	addressBookWindow.Show()
+}
+
+// A key pair is only generated once, replacing it would lock this user out of every file shared with them.
+func showOwnKey(s *settings.Settings, ownKey *widget.Entry, copyButton, generateButton *widget.Button) {
+	if s.Identity == "" {
+		ownKey.SetText("No key pair yet")
+		copyButton.Hide()
+		generateButton.Show()
+
+		return
+	}
+
+	identity, err := recipient.ParseIdentity(s.Identity)
+
+	if err != nil {
+		ownKey.SetText(fmt.Sprintf("Invalid key pair: %v", err))
+
+		return
+	}
+
+	ownKey.SetText(identity.PublicKey())
+	copyButton.Show()
+	generateButton.Hide()
+}
+
+// Uploads a file encrypted for a chosen set of teammates from the address book.
+// The duplicate check is skipped, linking to a file the recipients cannot decrypt would not share it.
+func UploadToRecipients(ctx context.Context, window fyne.Window, s *settings.Settings, manager *transfers.TransferManager) {
+	if len(s.AddressBook) == 0 {
+		notifications.SendErrorNotification("The address book is empty, add recipients within the settings first")
+
+		return
+	}
+
+	// Files are sealed for the uploader as well, so they stay readable by whoever sent them.
+	if s.Identity == "" {
+		notifications.SendErrorNotification("Generate your key pair within the address book first, files sent to recipients are sealed for you as well")
+
+		return
+	}
+
+	var names []string
+	keys := make(map[string]string)
+
+	for _, c := range s.AddressBook {
+		names = append(names, c.Name)
+		keys[c.Name] = c.PublicKey
+	}
+
+	recipients := widget.NewCheckGroup(names, nil)
+
+	dialog.NewCustomConfirm("Upload to recipients", "Choose file", "Cancel", container.NewVScroll(recipients), func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+
+		if len(recipients.Selected) == 0 {
+			notifications.SendErrorNotification("No recipients selected")
+
+			return
+		}
+
+		var publicKeys []string
+
+		for _, name := range recipients.Selected {
+			publicKeys = append(publicKeys, keys[name])
+		}
+
+		dialog.NewFileOpen(func(f fyne.URIReadCloser, _ error) {
+			if f == nil {
+				log.Println("No file selected")
+
+				return
+			}
+
+			defer f.Close()
+
+			manager.UploadTo(f.URI().Path(), f.URI().Name(), publicKeys)
+		}, window).Show()
+	}, window).Show()
+}
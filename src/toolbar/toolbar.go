@@ -1,9 +1,13 @@
 package toolbar
 
 import (
+	"context"
 	"fmt"
 	"image/color"
 	"log"
+	"strconv"
+	"sync"
+	"time"
 
 	"fyne.io/fyne/v2"
 
@@ -11,12 +15,19 @@ import (
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
+	"github.com/BitlyTwiser/throw/src/compression"
+	"github.com/BitlyTwiser/throw/src/kdf"
 	"github.com/BitlyTwiser/throw/src/notifications"
+	"github.com/BitlyTwiser/throw/src/policy"
 	"github.com/BitlyTwiser/throw/src/pufs_client"
 	"github.com/BitlyTwiser/throw/src/settings"
+	"github.com/BitlyTwiser/throw/src/transfers"
+	"github.com/BitlyTwiser/throw/src/watcher"
 )
 
-func UploadFile(window fyne.Window, client pufs_client.IpfsClient) {
+// Uploads are handed to the transfer manager, progress is displayed within the transfers panel.
+// The file is hashed first, content already stored under another name can be skipped or linked instead of uploaded again.
+func UploadFile(ctx context.Context, window fyne.Window, client *pufs_client.IpfsClient, manager *transfers.TransferManager) {
 	dialog.NewFileOpen(func(f fyne.URIReadCloser, _ error) {
 		if f == nil {
 			log.Println("No file selected")
@@ -24,17 +35,84 @@ func UploadFile(window fyne.Window, client pufs_client.IpfsClient) {
 			return
 		}
 
-		err := client.UploadFile(f.URI().Path(), f.URI().Name())
+		defer f.Close()
 
-		if err != nil {
-			notifications.SendErrorNotification(fmt.Sprintf("Error uploading File. Error: %v", err))
+		path, fileName := f.URI().Path(), f.URI().Name()
 
-			return
-		}
+		// Hashing a large file takes a while, keep the UI responsive.
+		go func() {
+			if _, err := client.CheckPolicy(ctx, path, fileName); err != nil {
+				policyDialog(window, fileName, err)
+
+				return
+			}
+
+			duplicate, err := client.FindDuplicate(path)
+
+			if err != nil {
+				log.Printf("Error checking %v for duplicates. Error: %v", fileName, err)
+			}
+
+			if duplicate == nil {
+				manager.Upload(path, fileName)
 
+				return
+			}
+
+			duplicateDialog(ctx, window, client, manager, duplicate, path, fileName)
+		}()
 	}, window).Show()
 }
 
+// Explains why the upload policy refused a file.
+func policyDialog(window fyne.Window, fileName string, err error) {
+	violation, ok := policy.IsViolation(err)
+
+	if !ok {
+		notifications.SendErrorNotification(fmt.Sprintf("Error checking %v against the upload policy. Error: %v", fileName, err))
+
+		return
+	}
+
+	message := widget.NewLabel(fmt.Sprintf("%v cannot be uploaded, %v.\nThe upload policy can be changed within the settings.", fileName, violation.Reason))
+	message.Wrapping = fyne.TextWrapWord
+
+	dialog.NewCustom("Upload blocked", "Ok", message, window).Show()
+}
+
+// Offers to skip, link or upload a file whose content is already stored.
+func duplicateDialog(ctx context.Context, window fyne.Window, client *pufs_client.IpfsClient, manager *transfers.TransferManager, duplicate *pufs_client.Duplicate, path, fileName string) {
+	var d dialog.Dialog
+	existing := client.DisplayName(duplicate.FileName)
+
+	message := widget.NewLabel(fmt.Sprintf("%v is a duplicate of %v.\nThe content is already stored, what would you like to do?", fileName, existing))
+	message.Wrapping = fyne.TextWrapWord
+
+	linkButton := widget.NewButtonWithIcon("Link to existing", theme.ContentCopyIcon(), func() {
+		d.Hide()
+
+		go func() {
+			if err := client.LinkFile(ctx, fileName, duplicate.FileName); err != nil {
+				notifications.SendErrorNotification(fmt.Sprintf("Error linking %v. Error: %v", fileName, err))
+
+				return
+			}
+
+			notifications.SendSuccessNotification(fmt.Sprintf("%v linked to %v", fileName, existing))
+		}()
+	})
+
+	uploadButton := widget.NewButtonWithIcon("Upload anyway", theme.UploadIcon(), func() {
+		d.Hide()
+		manager.Upload(path, fileName)
+	})
+
+	content := container.NewVBox(message, container.NewHBox(linkButton, uploadButton))
+
+	d = dialog.NewCustom(fmt.Sprintf("Duplicate of %v", existing), "Skip", content, window)
+	d.Show()
+}
+
 func EditFileWindow(data []byte) {
 	result := fyne.NewStaticResource("File", data)
 
@@ -61,12 +139,76 @@ func HelpWindow() {
 		One can upload files using the Pencil Icon from the main page that is initially loaded upon start of the application.
 		All files adde to the application, will be displayed in real time, when upload/delete actions commence.
 	--------------------------------------------------------------------------------------------------------------------
+	Folders:
+		Whole folders are uploaded with the Folder icon, sub folders included. Files are stored under the folder name joined with their path within it, like photos/2023/beach.jpg.
+		Include and Exclude take comma separated patterns like *.go or node_modules. Patterns with a slash match from the top of the folder, a trailing slash only matches folders.
+		A .throwignore file within any folder lists more patterns to exclude, one per line, for that folder and those below it.
+		Files already stored with the same content are skipped, so a failed folder upload can be resumed. The transfers panel lists the files that failed.
+		The Download icon downloads a stored folder into the download path, rebuilding the folders within it.
+	--------------------------------------------------------------------------------------------------------------------
+	Archives:
+		Check Pack Into Archive when uploading a folder to send it as a single tar, optionally compressed. Thousands of small files upload far faster this way.
+		An index of the files within it is stored next to the archive as name.tar.index. The Storage icon browses an archive from its index, without downloading it.
+		Single files are extracted into the download path. pufs sends files from their start, so the archive is only read up to the end of the file extracted.
+	--------------------------------------------------------------------------------------------------------------------
 	Settings:
 		Using the Gear icon from within the toolbar, the user can set adjust server settings, download path, and if the data is to be encrypted in transit.
 	--------------------------------------------------------------------------------------------------------------------
 	Encryption:
-		Data is encrypted using the tinycrypt golang library. https://github.com/BitlyTwiser/tinycrypt	
-		Tinycrypt uses AES enryption algorithms to protect your data while the files are stored on IPFS.
+		Data is encrypted with AES-256-GCM to protect your data while the files are stored on IPFS.
+		Every encrypted file records how it was encrypted, so files are decrypted on download whatever the current setting is.
+		Additional named keys can be added under Manage Keys, new files are encrypted with the active key. The encryption password is the default key.
+		Every file records the id of its key, keep a key within the keyring for as long as files are encrypted with it.
+		Rotating re-encrypts every stored file with a key, and can encrypt plaintext files at the same time. An interrupted rotation continues when it is started again.
+		Files encrypted by older versions using tinycrypt (https://github.com/BitlyTwiser/tinycrypt) can still be downloaded.
+		Executables (ELF, Windows PE and Mach-O) are stored unencrypted.
+	--------------------------------------------------------------------------------------------------------------------
+	File Names:
+		With Encrypt File Names on, names are encrypted with the encryption password before they reach the server. The same name always encrypts the same way.
+		The file list, metadata window and editor show the decrypted name. Clients without the password see placeholders like "Encrypted file (1a2b3c4d)".
+		The server only sees the size of these files rounded up to a power of two.
+	--------------------------------------------------------------------------------------------------------------------
+	Recipients:
+		Files can be shared with teammates without passing a password around. Generate a key pair within the Address Book and share your public key.
+		Uploading to recipients encrypts the file with a random key, wrapped for the public key of every chosen teammate and your own (X25519, similar to age).
+		Downloads unwrap the key with your private key, which is kept within the vault.
+	--------------------------------------------------------------------------------------------------------------------
+	Vault:
+		Encryption keys and host credentials are kept in a vault file encrypted under a master passphrase, settings.json holds no secrets.
+		The vault is unlocked once when Throw starts, the secrets are only kept in memory. The passphrase cannot be recovered, keep it safe.
+	--------------------------------------------------------------------------------------------------------------------
+	File Types:
+		The type of every file is detected from its content when it is uploaded or downloaded, and shown within the metadata window and as the file list icon.
+		Only text files can be edited. UTF-8 and UTF-16 text are both supported, edited text is saved in the encoding it was read in.
+	--------------------------------------------------------------------------------------------------------------------
+	Watch Folder:
+		Choose a watch folder within the settings to use Throw as a drop box. New and changed files within it, sub folders included, are uploaded once they stop changing for a couple of seconds.
+		Files deleted locally are deleted from the server. Temporary, lock and partially downloaded files (.swp, .tmp, .part, .lock, ~$...) are ignored.
+		Files are uploaded under their path within the folder. Changes made while Throw was closed are synced on the next start.
+		With the two-way sync mode, files uploaded, changed or deleted on the server by other clients are applied to the folder as well.
+		A file changed on both sides since the last sync is a conflict, resolved by the sync conflicts setting:
+			keep-both saves the stored copy next to the local file as "name (conflict date).ext", prefer-local or prefer-remote keep one side.
+			ask leaves the file alone until a side is picked within the conflicts view, opened with the Warning icon.
+	--------------------------------------------------------------------------------------------------------------------
+	WebDAV:
+		Set a WebDAV address within the settings, like localhost:8090, to mount the store with any WebDAV capable file manager (http://localhost:8090).
+		Files open in desktop applications, saving uploads them again. Uploads are encrypted and checked against the upload policy as they are within Throw.
+		Only localhost addresses are accepted. Set a local server token to require it as the password, any user name will do. Empty folders are forgotten once Throw closes, moving a file downloads and uploads it.
+	--------------------------------------------------------------------------------------------------------------------
+	HTTP Gateway:
+		Set a gateway address within the settings, like localhost:8091, to stream files to any local player or browser from http://localhost:8091/files/<name>.
+		Files are decrypted as they are sent and seeking is supported. Seeking far into a large file takes a while, pufs sends every file from its start.
+		Web pages, SVG images and scripts are downloaded rather than shown, so they cannot run on the gateway.
+		Requests must name localhost as their host. With a local server token set, send it as a bearer token or as the password of basic authentication.
+	--------------------------------------------------------------------------------------------------------------------
+	Upload Policy:
+		Every upload is checked against the upload policy within the settings. Each rule has an action: allow ignores it, warn uploads after a notification, block refuses the upload.
+		Types are comma separated MIME types (image/png), families (image/*) or extensions (.exe). Files outside of the allowed types or within the denied types get the types action.
+		Require Encryption applies to files that would be stored unencrypted, because encryption is off or the file is an executable.
+	--------------------------------------------------------------------------------------------------------------------
+	Compression:
+		Files can be compressed with gzip or zstd before they are encrypted. Auto only compresses text, logs, JSON and the like.
+		Compressed files are decompressed automatically on download, whatever the current setting is.
 	--------------------------------------------------------------------------------------------------------------------
 	IPFS:
 		IPFS is an amazing project known as the InterPlanetary File system. I do reccomend reading the docs to know more about this project.
@@ -85,7 +227,7 @@ func HelpWindow() {
 }
 
 // Set the values from the settings on load
-func Settings(s *settings.Settings) {
+func Settings(s *settings.Settings, manager *transfers.TransferManager) {
 	var downloadPath string
 	settingsWindow := fyne.CurrentApp().NewWindow("Settings")
 
@@ -107,6 +249,19 @@ func Settings(s *settings.Settings) {
 	}
 	port.SetPlaceHolder("Enter Host Port...")
 
+	// Host credentials are stored within the vault along with the encryption keys.
+	hostUsername := widget.NewEntry()
+	hostUsername.SetText(s.HostUsername)
+	hostUsername.SetPlaceHolder("Host username...")
+
+	hostPassword := widget.NewPasswordEntry()
+	hostPassword.SetText(s.HostPassword)
+	hostPassword.SetPlaceHolder("Host password...")
+
+	authToken := widget.NewPasswordEntry()
+	authToken.SetText(s.AuthToken)
+	authToken.SetPlaceHolder("Auth token, used instead of a username and password...")
+
 	password := widget.NewPasswordEntry()
 	if s.Password != "" {
 		password.SetText(s.Password)
@@ -150,23 +305,196 @@ func Settings(s *settings.Settings) {
 	downloadFolderButton := widget.NewButtonWithIcon("Download Path", theme.FolderIcon(), nil)
 	downloadFolderButton.OnTapped = func() { downloadFolder.Show() }
 
+	// Files within the watch folder are uploaded as they change, deleting them locally deletes them from the server.
+	watchFolder := widget.NewEntry()
+	watchFolder.SetText(s.WatchFolder)
+	watchFolder.SetPlaceHolder("Folder to sync, empty to not sync any folder...")
+
+	watchFolderDialog := dialog.NewFolderOpen(func(f fyne.ListableURI, _ error) {
+		if f == nil {
+			return
+		}
+
+		watchFolder.SetText(f.Path())
+	}, settingsWindow)
+
+	watchFolderButton := widget.NewButtonWithIcon("", theme.FolderOpenIcon(), func() { watchFolderDialog.Show() })
+
+	// Two-way sync also applies changes made on the server to the watch folder.
+	syncMode := widget.NewSelect(watcher.Modes, nil)
+	if s.SyncMode != "" {
+		syncMode.SetSelected(s.SyncMode)
+	} else {
+		syncMode.SetSelected(watcher.ModeUpload)
+	}
+
+	conflictStrategy := widget.NewSelect(watcher.Strategies, nil)
+	if s.ConflictStrategy != "" {
+		conflictStrategy.SetSelected(s.ConflictStrategy)
+	} else {
+		conflictStrategy.SetSelected(watcher.KeepBoth)
+	}
+
+	// Desktop applications open files through the WebDAV server, it only listens on localhost.
+	webDAVAddress := widget.NewEntry()
+	webDAVAddress.SetText(s.WebDAVAddress)
+	webDAVAddress.SetPlaceHolder("localhost:8090, empty to not serve WebDAV...")
+
+	gatewayAddress := widget.NewEntry()
+	gatewayAddress.SetText(s.GatewayAddress)
+	gatewayAddress.SetPlaceHolder("localhost:8091, empty to not serve the HTTP gateway...")
+
+	localToken := widget.NewPasswordEntry()
+	localToken.SetText(s.LocalToken)
+	localToken.SetPlaceHolder("Token WebDAV and the gateway ask for, empty to not ask...")
+
+	concurrentTransfers := widget.NewEntry()
+	if s.ConcurrentTransfers > 0 {
+		concurrentTransfers.SetText(strconv.Itoa(s.ConcurrentTransfers))
+	}
+	concurrentTransfers.SetPlaceHolder("Transfers running at once...")
+
+	minThroughput := widget.NewEntry()
+	if s.MinThroughputKBps > 0 {
+		minThroughput.SetText(strconv.Itoa(s.MinThroughputKBps))
+	}
+	minThroughput.SetPlaceHolder("Slowest expected link speed in KB/s...")
+
+	// Names are encrypted with the encryption password, clients without it see placeholders.
+	encryptFileNames := widget.NewCheck("", nil)
+	encryptFileNames.SetChecked(s.EncryptFileNames)
+
+	// Compression runs before encryption, auto only compresses text like content.
+	compressionSelect := widget.NewSelect([]string{compression.None, compression.Auto, compression.Gzip, compression.Zstd}, nil)
+	if s.Compression != "" {
+		compressionSelect.SetSelected(s.Compression)
+	} else {
+		compressionSelect.SetSelected(compression.None)
+	}
+
+	// Key derivation applies to files encrypted from now on, existing files keep the parameters they were encrypted with.
+	var kdfMutex sync.Mutex
+	kdfParams := s.KDF.OrDefault()
+	kdfLabel := widget.NewLabel(kdfParams.String())
+
+	kdfSelect := widget.NewSelect([]string{kdf.Argon2id, kdf.Scrypt}, func(algorithm string) {
+		kdfMutex.Lock()
+		defer kdfMutex.Unlock()
+
+		if kdfParams.Algorithm != algorithm {
+			kdfParams = kdf.Default(algorithm)
+			kdfLabel.SetText(kdfParams.String())
+		}
+	})
+	kdfSelect.SetSelected(kdfParams.Algorithm)
+
+	var benchmarkButton *widget.Button
+	benchmarkButton = widget.NewButtonWithIcon("Benchmark and choose parameters", theme.ViewRefreshIcon(), func() {
+		benchmarkButton.Disable()
+		kdfLabel.SetText("Benchmarking...")
+
+		go func() {
+			defer benchmarkButton.Enable()
+
+			params, err := kdf.Benchmark(kdfSelect.Selected, kdfBenchmarkTarget)
+
+			kdfMutex.Lock()
+			defer kdfMutex.Unlock()
+
+			if err != nil {
+				notifications.SendErrorNotification(fmt.Sprintf("Error benchmarking key derivation. Error: %v", err))
+			} else {
+				kdfParams = params
+			}
+
+			kdfLabel.SetText(kdfParams.String())
+		}()
+	})
+
+	// Upload policy, patterns are comma separated MIME types (image/png, text/*) or extensions (.exe).
+	maxFileSize := widget.NewEntry()
+	if s.Policy.MaxFileSize > 0 {
+		maxFileSize.SetText(strconv.FormatInt(s.Policy.MaxFileSize>>20, 10))
+	}
+	maxFileSize.SetPlaceHolder("Largest upload in MB, empty for no limit...")
+
+	sizeAction := actionSelect(s.Policy.SizeAction, policy.Block)
+
+	allowedTypes := widget.NewEntry()
+	allowedTypes.SetText(policy.FormatPatterns(s.Policy.Allowed))
+	allowedTypes.SetPlaceHolder("Only allow these types, empty allows all...")
+
+	deniedTypes := widget.NewEntry()
+	deniedTypes.SetText(policy.FormatPatterns(s.Policy.Denied))
+	deniedTypes.SetPlaceHolder("application/x-executable, .exe...")
+
+	typeAction := actionSelect(s.Policy.TypeAction, policy.Block)
+
+	requireEncryption := actionSelect(s.Policy.RequireEncryption, policy.Allow)
+
+	keysButton := widget.NewButtonWithIcon("Manage Keys", theme.AccountIcon(), func() {
+		KeyringWindow(s, manager)
+	})
+
+	addressBookButton := widget.NewButtonWithIcon("Address Book", theme.AccountIcon(), func() {
+		AddressBookWindow(s)
+	})
+
 	form := &widget.Form{
 		Items: []*widget.FormItem{},
 		OnSubmit: func() {
-			newSettings := settings.Settings{
-				Host:         host.Text,
-				Port:         port.Text,
-				Encrypted:    checkBox.Checked,
-				Password:     password.Text,
-				DownloadPath: downloadPath,
+			// Start from the current settings so values not present on this form are kept.
+			newSettings := *s
+			newSettings.Host = host.Text
+			newSettings.Port = port.Text
+			newSettings.HostUsername = hostUsername.Text
+			newSettings.HostPassword = hostPassword.Text
+			newSettings.AuthToken = authToken.Text
+			newSettings.Encrypted = checkBox.Checked
+			newSettings.Password = password.Text
+			newSettings.DownloadPath = downloadPath
+			newSettings.Compression = compressionSelect.Selected
+			newSettings.EncryptFileNames = encryptFileNames.Checked
+			newSettings.WatchFolder = watchFolder.Text
+			newSettings.SyncMode = syncMode.Selected
+			newSettings.ConflictStrategy = conflictStrategy.Selected
+			newSettings.WebDAVAddress = webDAVAddress.Text
+			newSettings.GatewayAddress = gatewayAddress.Text
+			newSettings.LocalToken = localToken.Text
+
+			kdfMutex.Lock()
+			newSettings.KDF = kdfParams
+			kdfMutex.Unlock()
+
+			var ok bool
+
+			if newSettings.ConcurrentTransfers, ok = positiveNumber(concurrentTransfers, "Concurrent transfers"); !ok {
+				return
 			}
 
-			saved := s.SaveSettings(newSettings)
+			if newSettings.MinThroughputKBps, ok = positiveNumber(minThroughput, "Minimum throughput"); !ok {
+				return
+			}
 
-			if saved {
-				notifications.SendSuccessNotification("Settings saved")
+			maxSizeMB, ok := positiveNumber(maxFileSize, "Max upload size")
+
+			if !ok {
+				return
+			}
+
+			newSettings.Policy = policy.Policy{
+				MaxFileSize:       int64(maxSizeMB) << 20,
+				SizeAction:        sizeAction.Selected,
+				Allowed:           policy.ParsePatterns(allowedTypes.Text),
+				Denied:            policy.ParsePatterns(deniedTypes.Text),
+				TypeAction:        typeAction.Selected,
+				RequireEncryption: requireEncryption.Selected,
+			}
+
+			if err := s.SaveSettings(newSettings); err != nil {
+				notifications.SendErrorNotification(fmt.Sprintf("Error saving settings. Error: %v", err))
 			} else {
-				notifications.SendErrorNotification("Error saving settings")
+				notifications.SendSuccessNotification("Settings saved")
 			}
 		},
 		OnCancel: func() {
@@ -176,15 +504,36 @@ func Settings(s *settings.Settings) {
 
 	tg := widget.NewTextGrid()
 	tg.Resize(fyne.NewSize(100, 200))
-	tg.SetText("Warning: Any Host/IP changes will apply after the client has been restarted")
-	tg.SetStyleRange(0, 0, 0, len(tg.Text()), &widget.CustomTextGridStyle{color.White, color.RGBA{255, 0, 0, 0}})
+	tg.SetText("Warning: Any Host/IP, Watch Folder, Sync, WebDAV or Gateway changes will apply after the client has been restarted")
+	tg.SetStyleRange(0, 0, 0, len(tg.Text()), &widget.CustomTextGridStyle{FGColor: color.White, BGColor: color.RGBA{255, 0, 0, 0}})
 
 	// Append form elements
 	form.Append("Host Address", host)
 	form.Append("Host Port", port)
+	form.Append("Host Username", hostUsername)
+	form.Append("Host Password", hostPassword)
+	form.Append("Auth Token", authToken)
 	form.Append("Encrypt Files", checkBox)
 	form.Append("Encryption Password", password)
+	form.Append("Encrypt File Names", encryptFileNames)
+	form.Append("Encryption Keys", keysButton)
+	form.Append("Recipients", addressBookButton)
 	form.Append("File Download Path", downloadFolderButton)
+	form.Append("Watch Folder", container.NewBorder(nil, nil, nil, watchFolderButton, watchFolder))
+	form.Append("Sync Mode", syncMode)
+	form.Append("Sync Conflicts", conflictStrategy)
+	form.Append("WebDAV Address", webDAVAddress)
+	form.Append("Gateway Address", gatewayAddress)
+	form.Append("Local Server Token", localToken)
+	form.Append("Concurrent Transfers", concurrentTransfers)
+	form.Append("Minimum Throughput (KB/s)", minThroughput)
+	form.Append("Compression", compressionSelect)
+	form.Append("Key Derivation", kdfSelect)
+	form.Append("Key Derivation Cost", container.NewVBox(kdfLabel, benchmarkButton))
+	form.Append("Max Upload Size (MB)", container.NewBorder(nil, nil, nil, sizeAction, maxFileSize))
+	form.Append("Allowed Types", allowedTypes)
+	form.Append("Denied Types", container.NewBorder(nil, nil, nil, typeAction, deniedTypes))
+	form.Append("Require Encryption", requireEncryption)
 	if downloadPath != "" {
 		form.Append("Curent Download Path", selectedFolder)
 	}
@@ -194,3 +543,36 @@ func Settings(s *settings.Settings) {
 
 	settingsWindow.Show()
 }
+
+// Time a single key derivation may take on this machine, when choosing parameters by benchmark.
+const kdfBenchmarkTarget = 500 * time.Millisecond
+
+// Parses an optional numeric entry. An empty entry is 0, meaning the default is used.
+func positiveNumber(entry *widget.Entry, name string) (int, bool) {
+	if entry.Text == "" {
+		return 0, true
+	}
+
+	n, err := strconv.Atoi(entry.Text)
+
+	if err != nil || n <= 0 {
+		notifications.SendErrorNotification(fmt.Sprintf("%v must be a positive number", name))
+
+		return 0, false
+	}
+
+	return n, true
+}
+
+// Select of a policy action, an unset action shows the action it falls back to.
+func actionSelect(action, fallback string) *widget.Select {
+	actions := widget.NewSelect(policy.Actions, nil)
+
+	if action == "" {
+		action = fallback
+	}
+
+	actions.SetSelected(action)
+
+	return actions
+}
@@ -0,0 +1,68 @@
+package toolbar
+
+import (
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"github.com/BitlyTwiser/throw/src/notifications"
+	"github.com/BitlyTwiser/throw/src/settings"
+)
+
+// Prompts for the master passphrase until the vault is unlocked, onUnlock is called once it is.
+// A vault is created on first use, the password stored in the settings file by older versions is moved into it. Quitting closes the application.
+func UnlockVault(window fyne.Window, s *settings.Settings, onUnlock func()) {
+	passphrase := widget.NewPasswordEntry()
+	confirm := widget.NewPasswordEntry()
+
+	create := !s.VaultExists()
+	title := "Unlock Vault"
+	items := []*widget.FormItem{widget.NewFormItem("Master Passphrase", passphrase)}
+
+	if create {
+		title = "Create Vault"
+		items = append(items, widget.NewFormItem("Confirm Passphrase", confirm))
+	}
+
+	retry := func(message string) {
+		notifications.SendErrorNotification(message)
+		UnlockVault(window, s, onUnlock)
+	}
+
+	d := dialog.NewForm(title, "Unlock", "Quit", items, func(submitted bool) {
+		if !submitted {
+			fyne.CurrentApp().Quit()
+
+			return
+		}
+
+		if create && passphrase.Text != confirm.Text {
+			retry("Passphrases do not match")
+
+			return
+		}
+
+		// Deriving the key takes a moment, keep the UI responsive.
+		go func() {
+			var err error
+
+			if create {
+				err = s.CreateVault(passphrase.Text)
+			} else {
+				err = s.UnlockVault(passphrase.Text)
+			}
+
+			if err != nil {
+				retry(fmt.Sprintf("Error unlocking vault. Error: %v", err))
+
+				return
+			}
+
+			onUnlock()
+		}()
+	}, window)
+
+	d.Resize(fyne.NewSize(400, 200))
+	d.Show()
+}
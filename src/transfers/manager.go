@@ -0,0 +1,446 @@
+package transfers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/BitlyTwiser/throw/src/pufs_client"
+	"github.com/BitlyTwiser/throw/src/tree"
+)
+
+const (
+	Upload   = "Upload"
+	Download = "Download"
+	// Re-encrypts every file under a key, progress is counted in files.
+	Rotate = "Rotate"
+	// Transfer every selected file of a folder, progress is counted in bytes over the whole folder.
+	UploadFolder   = "Upload folder"
+	DownloadFolder = "Download folder"
+	// Packs a folder into a single tar, progress is counted in bytes of the tar.
+	Pack = "Pack"
+)
+
+type State string
+
+const (
+	Queued    State = "Queued"
+	Running   State = "Running"
+	Paused    State = "Paused"
+	Cancelled State = "Cancelled"
+	Completed State = "Completed"
+	Failed    State = "Failed"
+)
+
+// Used when no concurrency limit has been set within the settings.
+const defaultConcurrentTransfers int = 3
+
+// Transfer is a snapshot of a single queued upload or download.
+type Transfer struct {
+	Id          int
+	Direction   string
+	FileName    string
+	LocalPath   string
+	State       State
+	Transferred int64
+	Total       int64
+	// Bytes per second, averaged since the transfer was last started.
+	Speed     float64
+	StartedAt time.Time
+	Err       error
+	// Key rotations only.
+	KeyId            string
+	IncludePlaintext bool
+	// Public keys an upload is encrypted for, the encryption settings are used when empty.
+	Recipients []string
+	// Folder transfers and packs only.
+	Filter tree.Filter
+	// Packs only, the compression settings are used when empty.
+	Compression string
+}
+
+// ETA returns the estimated time remaining. Zero is returned when there is not enough data to make an estimate.
+func (t Transfer) ETA() time.Duration {
+	if t.Speed <= 0 || t.Total <= 0 || t.Transferred >= t.Total {
+		return 0
+	}
+
+	return time.Duration(float64(t.Total-t.Transferred)/t.Speed) * time.Second
+}
+
+// Fraction returns the completed portion of the transfer between 0 and 1.
+func (t Transfer) Fraction() float64 {
+	if t.Total <= 0 {
+		if t.State == Completed {
+			return 1
+		}
+
+		return 0
+	}
+
+	return float64(t.Transferred) / float64(t.Total)
+}
+
+type transfer struct {
+	Transfer
+	cancel context.CancelFunc
+	// Bumped every time the transfer is started, so a stopped run can no longer touch a resumed transfer.
+	generation int
+}
+
+// TransferManager queues uploads and downloads, running a limited amount at any one time.
+// Every change in progress or state is pushed onto Updates. Sends never block, so a slow reader may miss intermediate updates; Transfers always returns the current state.
+type TransferManager struct {
+	Updates chan Transfer
+	// Parent of every transfer context, cancelling it stops all transfers.
+	ctx     context.Context
+	client  *pufs_client.IpfsClient
+	slots   chan Empty
+	mutex   sync.Mutex
+	nextId  int
+	entries []*transfer
+}
+
+type Empty struct{}
+
+func NewTransferManager(ctx context.Context, client *pufs_client.IpfsClient, limit int) *TransferManager {
+	if limit <= 0 {
+		limit = defaultConcurrentTransfers
+	}
+
+	return &TransferManager{
+		Updates: make(chan Transfer, 64),
+		ctx:     ctx,
+		client:  client,
+		slots:   make(chan Empty, limit),
+	}
+}
+
+// Upload queues the file at path to be uploaded under fileName.
+func (m *TransferManager) Upload(path, fileName string) int {
+	return m.enqueue(Upload, fileName, path)
+}
+
+// UploadTo queues the file at path to be uploaded under fileName, encrypted for the given public keys.
+func (m *TransferManager) UploadTo(path, fileName string, recipients []string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.add(&transfer{Transfer: Transfer{
+		Direction:  Upload,
+		FileName:   fileName,
+		LocalPath:  path,
+		Recipients: recipients,
+	}})
+}
+
+// UploadFolder queues the files of the folder at root selected by the filter, to be stored under name joined with their relative paths.
+// Files already stored are skipped, so a failed folder upload can be resumed.
+func (m *TransferManager) UploadFolder(root, name string, filter tree.Filter) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.add(&transfer{Transfer: Transfer{
+		Direction: UploadFolder,
+		FileName:  name,
+		LocalPath: root,
+		Filter:    filter,
+	}})
+}
+
+// Pack queues the files of the folder at root selected by the filter, to be packed into a tar stored as name along with its index.
+func (m *TransferManager) Pack(root, name string, filter tree.Filter, compression string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.add(&transfer{Transfer: Transfer{
+		Direction:   Pack,
+		FileName:    name,
+		LocalPath:   root,
+		Filter:      filter,
+		Compression: compression,
+	}})
+}
+
+// DownloadFolder queues the files stored under the folder name selected by the filter, to be downloaded into the download path.
+func (m *TransferManager) DownloadFolder(name string, filter tree.Filter) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.add(&transfer{Transfer: Transfer{
+		Direction: DownloadFolder,
+		FileName:  name,
+		Filter:    filter,
+	}})
+}
+
+// Download queues a remote file to be downloaded into the download path.
+func (m *TransferManager) Download(fileName string) int {
+	return m.enqueue(Download, fileName, "")
+}
+
+// Rotate queues a rotation of every file to the key with the given id, named keyName within the panel.
+func (m *TransferManager) Rotate(keyId, keyName string, includePlaintext bool) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.add(&transfer{Transfer: Transfer{
+		Direction:        Rotate,
+		FileName:         keyName,
+		KeyId:            keyId,
+		IncludePlaintext: includePlaintext,
+	}})
+}
+
+// Transfers returns a snapshot of every transfer known to the manager, in the order they were queued.
+func (m *TransferManager) Transfers() []Transfer {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	transfers := make([]Transfer, len(m.entries))
+
+	for i, t := range m.entries {
+		transfers[i] = t.Transfer
+	}
+
+	return transfers
+}
+
+// Pause stops a queued or running transfer. Downloads continue from the last journal checkpoint once resumed, uploads start over.
+func (m *TransferManager) Pause(id int) error {
+	return m.stop(id, Paused)
+}
+
+// Cancel stops a transfer for good.
+func (m *TransferManager) Cancel(id int) error {
+	return m.stop(id, Cancelled)
+}
+
+// Resume queues a paused or failed transfer once more.
+func (m *TransferManager) Resume(id int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	t := m.find(id)
+
+	if t == nil {
+		return fmt.Errorf("no transfer found with id: %v", id)
+	}
+
+	if t.State != Paused && t.State != Failed {
+		return fmt.Errorf("transfer %v cannot be resumed while %v", id, t.State)
+	}
+
+	m.start(t)
+
+	return nil
+}
+
+// Clear removes every transfer that is no longer active.
+func (m *TransferManager) Clear() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var active []*transfer
+
+	for _, t := range m.entries {
+		if t.State == Queued || t.State == Running || t.State == Paused {
+			active = append(active, t)
+		}
+	}
+
+	m.entries = active
+}
+
+func (m *TransferManager) enqueue(direction, fileName, path string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.add(&transfer{Transfer: Transfer{
+		Direction: direction,
+		FileName:  fileName,
+		LocalPath: path,
+	}})
+}
+
+// Must be called with the mutex held.
+func (m *TransferManager) add(t *transfer) int {
+	m.nextId++
+	t.Id = m.nextId
+
+	m.entries = append(m.entries, t)
+	m.start(t)
+
+	return t.Id
+}
+
+// Must be called with the mutex held.
+func (m *TransferManager) start(t *transfer) {
+	ctx, cancel := context.WithCancel(m.ctx)
+
+	t.cancel = cancel
+	t.generation++
+	t.State = Queued
+	t.Err = nil
+	m.publish(t)
+
+	go m.run(ctx, t, t.generation, cancel)
+}
+
+func (m *TransferManager) stop(id int, state State) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	t := m.find(id)
+
+	if t == nil {
+		return fmt.Errorf("no transfer found with id: %v", id)
+	}
+
+	if t.State == Paused && state == Cancelled {
+		t.State = Cancelled
+		m.publish(t)
+
+		return nil
+	}
+
+	if t.State != Queued && t.State != Running {
+		return fmt.Errorf("transfer %v is not active", id)
+	}
+
+	t.State = state
+	t.cancel()
+	m.publish(t)
+
+	return nil
+}
+
+func (m *TransferManager) run(ctx context.Context, t *transfer, generation int, cancel context.CancelFunc) {
+	defer cancel()
+
+	// Wait for a free slot, unless stopped while waiting.
+	select {
+	case m.slots <- Empty{}:
+	case <-ctx.Done():
+		m.mutex.Lock()
+		m.interrupted(t, generation)
+		m.mutex.Unlock()
+
+		return
+	}
+
+	defer func() { <-m.slots }()
+
+	m.mutex.Lock()
+	if ctx.Err() != nil {
+		m.interrupted(t, generation)
+		m.mutex.Unlock()
+
+		return
+	}
+	t.State = Running
+	t.StartedAt = time.Now()
+	t.Transferred = 0
+	m.publish(t)
+	m.mutex.Unlock()
+
+	ctx = pufs_client.WithProgress(ctx, func(transferred, total int64) {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		if t.generation != generation {
+			return
+		}
+
+		t.Transferred = transferred
+		t.Total = total
+
+		if elapsed := time.Since(t.StartedAt).Seconds(); elapsed > 0 {
+			t.Speed = float64(transferred) / elapsed
+		}
+
+		m.publish(t)
+	})
+
+	var err error
+
+	switch t.Direction {
+	case Upload:
+		if len(t.Recipients) > 0 {
+			ctx = pufs_client.WithRecipients(ctx, t.Recipients)
+		}
+
+		err = m.client.UploadFile(ctx, t.LocalPath, t.FileName)
+	case Download:
+		err = m.client.Download(ctx, t.FileName)
+	case Rotate:
+		err = m.client.RotateKeys(ctx, t.KeyId, t.IncludePlaintext)
+	case UploadFolder:
+		_, err = m.client.UploadTree(ctx, t.LocalPath, t.FileName, t.Filter)
+	case DownloadFolder:
+		_, err = m.client.DownloadTree(ctx, t.FileName, m.client.Settings.DownloadPath, t.Filter)
+	case Pack:
+		if t.Compression != "" {
+			ctx = pufs_client.WithCompression(ctx, t.Compression)
+		}
+
+		_, err = m.client.UploadArchive(ctx, t.LocalPath, t.FileName, t.Filter)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Pause or cancel already set the state.
+	if t.generation != generation || t.State != Running {
+		return
+	}
+
+	switch {
+	case err == nil:
+		t.State = Completed
+		t.Speed = 0
+	case ctx.Err() != nil:
+		m.interrupted(t, generation)
+
+		return
+	default:
+		log.Printf("%v of %v failed. Error: %v", t.Direction, t.FileName, err)
+		t.State = Failed
+		t.Err = err
+	}
+
+	m.publish(t)
+}
+
+// Pauses a transfer stopped by the parent context as the application closes, so it can be resumed. Pause and Cancel set the state of the transfers they stop themselves.
+// Must be called with the mutex held.
+func (m *TransferManager) interrupted(t *transfer, generation int) {
+	if t.generation != generation || (t.State != Queued && t.State != Running) {
+		return
+	}
+
+	t.State = Paused
+	t.Speed = 0
+	m.publish(t)
+}
+
+// Must be called with the mutex held.
+func (m *TransferManager) find(id int) *transfer {
+	for _, t := range m.entries {
+		if t.Id == id {
+			return t
+		}
+	}
+
+	return nil
+}
+
+// Must be called with the mutex held.
+func (m *TransferManager) publish(t *transfer) {
+	select {
+	case m.Updates <- t.Transfer:
+	default:
+	}
+}
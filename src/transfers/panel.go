@@ -0,0 +1,165 @@
+package transfers
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Panel renders every transfer with a live progress bar, speed, ETA and pause/resume/cancel controls.
+func Panel(m *TransferManager) fyne.CanvasObject {
+	var mutex sync.Mutex
+	transfers := m.Transfers()
+
+	transferList := widget.NewList(
+		func() int {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			return len(transfers)
+		},
+		func() fyne.CanvasObject {
+			fileNameLabel := widget.NewLabel("")
+			progress := widget.NewProgressBar()
+			statusLabel := widget.NewLabel("")
+
+			pauseButton := widget.NewButtonWithIcon("", theme.MediaPauseIcon(), nil)
+			resumeButton := widget.NewButtonWithIcon("", theme.MediaPlayIcon(), nil)
+			cancelButton := widget.NewButtonWithIcon("", theme.CancelIcon(), nil)
+
+			return container.NewGridWithColumns(
+				4,
+				fileNameLabel,
+				progress,
+				statusLabel,
+				container.NewHBox(pauseButton, resumeButton, cancelButton),
+			)
+		},
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			mutex.Lock()
+			if i >= len(transfers) {
+				mutex.Unlock()
+
+				return
+			}
+			t := transfers[i]
+			mutex.Unlock()
+
+			row := o.(*fyne.Container)
+
+			// Downloads are queued under the stored name, which may be encrypted.
+			name := t.FileName
+			if t.Direction == Download {
+				name = m.client.DisplayName(name)
+			}
+
+			row.Objects[0].(*widget.Label).SetText(fmt.Sprintf("%v: %v", t.Direction, name))
+			row.Objects[1].(*widget.ProgressBar).SetValue(t.Fraction())
+			row.Objects[2].(*widget.Label).SetText(transferStatus(t))
+
+			buttons := row.Objects[3].(*fyne.Container).Objects
+			pauseButton := buttons[0].(*widget.Button)
+			resumeButton := buttons[1].(*widget.Button)
+			cancelButton := buttons[2].(*widget.Button)
+
+			pauseButton.OnTapped = func() { m.reportError(m.Pause(t.Id)) }
+			resumeButton.OnTapped = func() { m.reportError(m.Resume(t.Id)) }
+			cancelButton.OnTapped = func() { m.reportError(m.Cancel(t.Id)) }
+
+			active := t.State == Queued || t.State == Running
+
+			setEnabled(pauseButton, active)
+			setEnabled(resumeButton, t.State == Paused || t.State == Failed)
+			setEnabled(cancelButton, active || t.State == Paused)
+		},
+	)
+
+	refresh := func() {
+		mutex.Lock()
+		transfers = m.Transfers()
+		mutex.Unlock()
+
+		transferList.Refresh()
+	}
+
+	// Updates drive the progress bars, the ticker keeps speed and ETA fresh when no data is moving.
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-m.Updates:
+				refresh()
+			case <-ticker.C:
+				refresh()
+			}
+		}
+	}()
+
+	clearButton := widget.NewButtonWithIcon("Clear finished", theme.ContentClearIcon(), func() {
+		m.Clear()
+		refresh()
+	})
+
+	header := container.NewBorder(nil, nil, widget.NewLabel("Transfers"), clearButton)
+
+	return container.NewBorder(header, nil, nil, nil, transferList)
+}
+
+func transferStatus(t Transfer) string {
+	switch t.State {
+	case Running:
+		if t.Direction == Rotate {
+			return fmt.Sprintf("%v/%v files", t.Transferred, t.Total)
+		}
+
+		status := fmt.Sprintf("%v/s", formatBytes(int64(t.Speed)))
+
+		if eta := t.ETA(); eta > 0 {
+			status = fmt.Sprintf("%v - ETA %v", status, eta.Round(time.Second))
+		}
+
+		return status
+	case Failed:
+		return fmt.Sprintf("Failed: %v", t.Err)
+	default:
+		return string(t.State)
+	}
+}
+
+func formatBytes(n int64) string {
+	const unit = 1 << 10
+
+	if n < unit {
+		return fmt.Sprintf("%vB", n)
+	}
+
+	div, exp := int64(unit), 0
+
+	for i := n / unit; i >= unit; i /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func setEnabled(b *widget.Button, enabled bool) {
+	if enabled {
+		b.Enable()
+	} else {
+		b.Disable()
+	}
+}
+
+func (m *TransferManager) reportError(err error) {
+	if err != nil {
+		m.client.NotifyError(err.Error())
+	}
+}
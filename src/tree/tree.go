@@ -0,0 +1,236 @@
+// Package tree selects the files of a local folder to upload, and the files of a remote folder to download.
+// Files are named by their path relative to the folder, slash separated, so the same names are used on every platform and on the server.
+package tree
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Files named IgnoreFile hold exclude patterns for the folder they are in and every folder below it.
+// One pattern per line, blank lines and lines starting with # are skipped.
+const IgnoreFile = ".throwignore"
+
+// Filter selects files by their relative path. Patterns use the syntax of path.Match.
+// Patterns holding a slash are matched against the path from the start, a leading slash is optional. Others are matched against every element of the path, so "node_modules" selects the folder and everything within it.
+// A trailing slash only matches folders.
+type Filter struct {
+	// When any are given, only files matching one of them are selected.
+	Include []string
+	// Files matching any of them are never selected, exclusions win over inclusions.
+	Exclude []string
+}
+
+// File is a regular file found within a folder.
+type File struct {
+	Path string
+	// Path relative to the folder, slash separated.
+	Name string
+	Size int64
+}
+
+// ParsePatterns splits a comma separated list of patterns, as typed into a form.
+func ParsePatterns(s string) []string {
+	var patterns []string
+
+	for _, pattern := range strings.Split(s, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	return patterns
+}
+
+// Validate reports the first malformed pattern.
+func (f Filter) Validate() error {
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := path.Match(strings.Trim(pattern, "/"), ""); err != nil {
+			return fmt.Errorf("invalid pattern %q. Error: %w", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// Match reports if the file with the given relative path is selected.
+func (f Filter) Match(name string) bool {
+	if matchAny(f.Exclude, name, false) {
+		return false
+	}
+
+	return len(f.Include) == 0 || matchAny(f.Include, name, false)
+}
+
+// Walk returns the files within the folder at root selected by the filter and ignore files, in lexical order.
+// Excluded folders are not entered. Symbolic links and other special files are skipped, ignore files are selected like any other file.
+func Walk(root string, filter Filter) ([]File, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%v is not a folder", root)
+	}
+
+	// Patterns read from ignore files, keyed by the folder they apply to.
+	ignored := make(map[string][]string)
+	var files []File
+
+	err = filepath.WalkDir(root, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+
+		if err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(rel)
+
+		if entry.IsDir() {
+			if name != "." && (matchAny(filter.Exclude, name, true) || ignoredBy(ignored, name, true)) {
+				return filepath.SkipDir
+			}
+
+			patterns, err := readIgnoreFile(filepath.Join(p, IgnoreFile))
+
+			if err != nil {
+				return err
+			}
+
+			if len(patterns) > 0 {
+				ignored[name] = patterns
+			}
+
+			return nil
+		}
+
+		if !entry.Type().IsRegular() {
+			log.Printf("Skipping %v, only regular files are uploaded", p)
+
+			return nil
+		}
+
+		if !filter.Match(name) || ignoredBy(ignored, name, false) {
+			return nil
+		}
+
+		info, err := entry.Info()
+
+		if err != nil {
+			return err
+		}
+
+		files = append(files, File{Path: p, Name: name, Size: info.Size()})
+
+		return nil
+	})
+
+	return files, err
+}
+
+// Reports if an ignore file within any folder above name excludes it.
+func ignoredBy(ignored map[string][]string, name string, dir bool) bool {
+	for folder := path.Dir(name); ; folder = path.Dir(folder) {
+		rel := name
+
+		if folder != "." {
+			rel = strings.TrimPrefix(name, folder+"/")
+		}
+
+		if matchAny(ignored[folder], rel, dir) {
+			return true
+		}
+
+		if folder == "." {
+			return false
+		}
+	}
+}
+
+func readIgnoreFile(p string) ([]string, error) {
+	file, err := os.Open(p)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if _, err := path.Match(strings.Trim(line, "/"), ""); err != nil {
+			log.Printf("Skipping invalid pattern %q within %v. Error: %v", line, p, err)
+
+			continue
+		}
+
+		patterns = append(patterns, line)
+	}
+
+	return patterns, scanner.Err()
+}
+
+func matchAny(patterns []string, name string, dir bool) bool {
+	for _, pattern := range patterns {
+		if match(pattern, name, dir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Matches a pattern against a relative path. The last element of a file path is not a folder, patterns ending in a slash skip it.
+func match(pattern, name string, dir bool) bool {
+	elements := strings.Split(name, "/")
+
+	if strings.HasSuffix(pattern, "/") && !dir {
+		elements = elements[:len(elements)-1]
+	}
+
+	anchored := strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+	pattern = strings.Trim(pattern, "/")
+
+	for i := range elements {
+		candidate := elements[i]
+
+		if anchored {
+			candidate = strings.Join(elements[:i+1], "/")
+		}
+
+		if ok, _ := path.Match(pattern, candidate); ok {
+			return true
+		}
+	}
+
+	return false
+}
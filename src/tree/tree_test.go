@@ -0,0 +1,158 @@
+package tree
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		dir     bool
+		want    bool
+	}{
+		// Patterns without a slash match any element.
+		{"node_modules", "node_modules", true, true},
+		{"node_modules", "web/node_modules/react/index.js", false, true},
+		{"*.log", "app.log", false, true},
+		{"*.log", "logs/app.log", false, true},
+		{"*.log", "app.log.gz", false, false},
+
+		// A slash anchors the pattern to the start of the path, a leading one is optional.
+		{"docs/*.md", "docs/a.md", false, true},
+		{"docs/*.md", "web/docs/a.md", false, false},
+		{"/secret.txt", "secret.txt", false, true},
+		{"/secret.txt", "keys/secret.txt", false, false},
+		{"web/dist", "web/dist/app.js", false, true},
+		{"web/dist", "web/distribution/app.js", false, false},
+
+		// A trailing slash only matches folders.
+		{"build/", "build", true, true},
+		{"build/", "build", false, false},
+		{"build/", "src/build/main.o", false, true},
+		{"build/", "src/build", false, false},
+		{"/out/", "out/a.txt", false, true},
+		{"/out/", "src/out/a.txt", false, false},
+	}
+
+	for _, test := range tests {
+		if got := match(test.pattern, test.name, test.dir); got != test.want {
+			t.Errorf("match(%q, %q, %v) = %v, want %v", test.pattern, test.name, test.dir, got, test.want)
+		}
+	}
+}
+
+// Patterns of an ignore file apply to paths relative to its folder, and only below it.
+func TestIgnoredBy(t *testing.T) {
+	ignored := map[string][]string{
+		".":   {"*.tmp"},
+		"src": {"/gen", "*.o"},
+	}
+
+	tests := []struct {
+		name string
+		dir  bool
+		want bool
+	}{
+		{"a.tmp", false, true},
+		{"src/deep/a.tmp", false, true},
+		{"src/gen", true, true},
+		{"src/gen/a.go", false, true},
+		{"src/lib/gen/a.go", false, false},
+		{"src/lib/main.o", false, true},
+		{"gen/a.go", false, false},
+		{"main.o", false, false},
+		{"src/main.go", false, false},
+	}
+
+	for _, test := range tests {
+		if got := ignoredBy(ignored, test.name, test.dir); got != test.want {
+			t.Errorf("ignoredBy(%q, %v) = %v, want %v", test.name, test.dir, got, test.want)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	f := Filter{Include: []string{"*.go", "docs/"}, Exclude: []string{"vendor", "*_test.go"}}
+
+	tests := map[string]bool{
+		"main.go":          true,
+		"src/a.go":         true,
+		"docs/readme.md":   true,
+		"README.md":        false,
+		"src/a_test.go":    false,
+		"vendor/x/main.go": false,
+	}
+
+	for name, want := range tests {
+		if got := f.Match(name); got != want {
+			t.Errorf("Match(%q) = %v, want %v", name, got, want)
+		}
+	}
+
+	if err := (Filter{Exclude: []string{"["}}).Validate(); err == nil {
+		t.Error("Validate accepted a malformed pattern")
+	}
+}
+
+func TestWalk(t *testing.T) {
+	root := t.TempDir()
+
+	files := map[string]string{
+		"a.txt":                      "a",
+		"a.tmp":                      "",
+		IgnoreFile:                   "# temporary files\n*.tmp\n\nbuild/\n[\n",
+		"build/out.bin":              "",
+		"notes/build":                "a file named like the ignored folder",
+		"node_modules/x/index.js":    "",
+		"src/" + IgnoreFile:          "/gen\n",
+		"src/main.go":                "package main",
+		"src/gen/types.go":           "",
+		"src/lib/gen/keep.go":        "",
+		"src/lib/cache.tmp":          "",
+		"other/gen/also-kept.go":     "",
+		"other/deeper/" + IgnoreFile: "*\n",
+		"other/deeper/gone.txt":      "",
+	}
+
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := Walk(root, Filter{Exclude: []string{"node_modules"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+
+	for _, f := range got {
+		names = append(names, f.Name)
+
+		if f.Size != int64(len(files[f.Name])) || f.Path != filepath.Join(root, filepath.FromSlash(f.Name)) {
+			t.Errorf("%v: path %v and size %v", f.Name, f.Path, f.Size)
+		}
+	}
+
+	want := []string{IgnoreFile, "a.txt", "notes/build", "other/gen/also-kept.go", "src/" + IgnoreFile, "src/lib/gen/keep.go", "src/main.go"}
+
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Walk = %v, want %v", names, want)
+	}
+
+	if _, err := Walk(filepath.Join(root, "a.txt"), Filter{}); err == nil {
+		t.Error("Walk accepted a file as its root")
+	}
+}
@@ -0,0 +1,141 @@
+// Package vault keeps secrets in a local file encrypted under a master passphrase.
+// The file is an envelope, the key derivation parameters and salt are stored in its header. The passphrase itself is never stored.
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/BitlyTwiser/throw/src/envelope"
+	"github.com/BitlyTwiser/throw/src/kdf"
+	"github.com/BitlyTwiser/throw/src/keyring"
+)
+
+// Returned by Unlock when the passphrase does not open the vault.
+var ErrWrongPassphrase = errors.New("wrong passphrase, or the vault is corrupt")
+
+// Secrets are everything the vault protects.
+type Secrets struct {
+	// Default encryption key.
+	Password string
+	Keyring  keyring.Keyring
+	// Credentials sent to the host with every request. A token is preferred over a username and password.
+	HostUsername string
+	HostPassword string
+	AuthToken    string
+	// X25519 private key, files shared with the public key are decrypted with it.
+	Identity string
+	// Token the local WebDAV server and gateway ask for.
+	LocalToken string
+}
+
+// Vault is an unlocked vault. The key is derived once and kept in memory for the rest of the session.
+type Vault struct {
+	path   string
+	header *envelope.Header
+	key    []byte
+}
+
+// Exists reports if a vault has been created at path.
+func Exists(path string) bool {
+	_, err := os.Stat(path)
+
+	return err == nil
+}
+
+// Create writes a new vault holding secrets to path, encrypted under passphrase.
+func Create(path, passphrase string, params kdf.Params, secrets Secrets) (*Vault, error) {
+	if passphrase == "" {
+		return nil, errors.New("the master passphrase cannot be empty")
+	}
+
+	params = params.OrDefault()
+	salt, err := kdf.NewSalt()
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := kdf.Derive(passphrase, salt, params)
+
+	if err != nil {
+		return nil, err
+	}
+
+	v := &Vault{
+		path: path,
+		header: &envelope.Header{
+			Version:   envelope.Version,
+			Cipher:    envelope.CipherAES256GCM,
+			KDF:       params.Id(),
+			KDFParams: params.Marshal(),
+			Salt:      salt,
+		},
+		key: key,
+	}
+
+	return v, v.Save(secrets)
+}
+
+// Unlock opens the vault at path, returning the secrets within.
+func Unlock(path, passphrase string) (*Vault, Secrets, error) {
+	var secrets Secrets
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, secrets, err
+	}
+
+	h, _, err := envelope.ParseHeader(data)
+
+	if err != nil {
+		return nil, secrets, err
+	}
+
+	params, err := kdf.Unmarshal(h.KDF, h.KDFParams)
+
+	if err != nil {
+		return nil, secrets, err
+	}
+
+	key, err := kdf.Derive(passphrase, h.Salt, params)
+
+	if err != nil {
+		return nil, secrets, err
+	}
+
+	plain, _, err := envelope.Open(data, func(*envelope.Header) ([]byte, error) { return key, nil })
+
+	if err != nil {
+		return nil, secrets, ErrWrongPassphrase
+	}
+
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		return nil, secrets, err
+	}
+
+	return &Vault{path: path, header: h, key: key}, secrets, nil
+}
+
+// Save replaces the content of the vault. It is written to a temporary file then renamed, a crash mid write never loses the vault.
+func (v *Vault) Save(secrets Secrets) error {
+	plain, err := json.Marshal(secrets)
+
+	if err != nil {
+		return err
+	}
+
+	data, err := envelope.Seal(plain, v.header, v.key)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(v.path+".tmp", data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(v.path+".tmp", v.path)
+}
@@ -0,0 +1,135 @@
+package vault
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/BitlyTwiser/throw/src/envelope"
+	"github.com/BitlyTwiser/throw/src/kdf"
+	"github.com/BitlyTwiser/throw/src/keyring"
+)
+
+var testSecrets = Secrets{
+	Password: "default key",
+	Keyring: keyring.Keyring{
+		Keys:   []keyring.Key{{Id: "k1", Name: "team", Password: "team key", CreatedAt: "2024-01-02T03:04:05Z"}},
+		Active: "k1",
+	},
+	HostUsername: "user",
+	HostPassword: "host password",
+	AuthToken:    "token",
+	Identity:     "identity",
+	LocalToken:   "local",
+}
+
+// Parameters cheap enough for tests, the stored header carries them so Unlock needs nothing else.
+var testParams = kdf.Params{Algorithm: kdf.Scrypt, LogN: 10, R: 8, P: 1}
+
+func TestRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vault")
+
+	if Exists(path) {
+		t.Fatal("Exists reported a vault before it was created")
+	}
+
+	v, err := Create(path, "master passphrase", testParams, testSecrets)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !Exists(path) {
+		t.Fatal("Exists did not find the created vault")
+	}
+
+	data, _ := os.ReadFile(path)
+	info, _ := os.Stat(path)
+
+	if !envelope.IsEnvelope(data) || info.Mode().Perm() != 0o600 {
+		t.Errorf("vault is not an envelope readable by its owner only, mode %v", info.Mode())
+	}
+
+	for _, secret := range []string{"master passphrase", "default key", "team key", "host password"} {
+		if bytes.Contains(data, []byte(secret)) {
+			t.Errorf("the vault file holds %q in the clear", secret)
+		}
+	}
+
+	_, secrets, err := Unlock(path, "master passphrase")
+
+	if err != nil || !reflect.DeepEqual(secrets, testSecrets) {
+		t.Fatalf("Unlock = %+v, %v", secrets, err)
+	}
+
+	changed := testSecrets
+	changed.AuthToken = "new token"
+
+	if err := v.Save(changed); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("Save left its temporary file behind, %v", err)
+	}
+
+	unlocked, secrets, err := Unlock(path, "master passphrase")
+
+	if err != nil || secrets.AuthToken != "new token" {
+		t.Fatalf("Unlock after Save = %+v, %v", secrets, err)
+	}
+
+	// A vault opened by Unlock keeps its parameters and salt when saved.
+	if err := unlocked.Save(testSecrets); err != nil {
+		t.Fatal(err)
+	}
+
+	n := headerSize(t, data)
+
+	if after, _ := os.ReadFile(path); !bytes.Equal(after[:n], data[:n]) {
+		t.Error("Save changed the header of the vault")
+	}
+}
+
+func headerSize(t *testing.T, data []byte) int {
+	_, n, err := envelope.ParseHeader(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return n
+}
+
+func TestUnlockRejects(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vault")
+
+	if _, err := Create(path, "master passphrase", testParams, testSecrets); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := Unlock(path, "wrong passphrase"); err != ErrWrongPassphrase {
+		t.Errorf("Unlock with the wrong passphrase = %v, want ErrWrongPassphrase", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	data[len(data)-1] ^= 1
+
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := Unlock(path, "master passphrase"); err != ErrWrongPassphrase {
+		t.Errorf("Unlock of a changed vault = %v, want ErrWrongPassphrase", err)
+	}
+
+	if _, _, err := Unlock(filepath.Join(t.TempDir(), "missing"), "master passphrase"); !os.IsNotExist(err) {
+		t.Errorf("Unlock of a missing vault = %v", err)
+	}
+
+	if _, err := Create(filepath.Join(t.TempDir(), "vault"), "", testParams, testSecrets); err == nil {
+		t.Error("Create accepted an empty passphrase")
+	}
+}
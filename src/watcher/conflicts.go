@@ -0,0 +1,129 @@
+package watcher
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// How conflicting changes to both sides of a file are resolved.
+const (
+	// The remote copy is saved next to the local file under a conflict name, both end up on either side.
+	KeepBoth     = "keep-both"
+	PreferLocal  = "prefer-local"
+	PreferRemote = "prefer-remote"
+	// Nothing is done until the user picks a side within the conflicts view.
+	Ask = "ask"
+)
+
+var Strategies = []string{KeepBoth, PreferLocal, PreferRemote, Ask}
+
+// Changes seen on one side of a file since the last sync.
+const (
+	changeNone     = ""
+	ChangeCreated  = "created"
+	ChangeModified = "modified"
+	ChangeDeleted  = "deleted"
+)
+
+// A Conflict is a file changed on both sides since the last sync.
+type Conflict struct {
+	Path   string
+	Local  string
+	Remote string
+	// Stored name of the remote copy, empty when it was deleted.
+	RemoteName string
+	DetectedAt time.Time
+}
+
+func (c Conflict) String() string {
+	return fmt.Sprintf("%v: %v locally, %v remotely", c.Path, c.Local, c.Remote)
+}
+
+type resolution struct {
+	path     string
+	strategy string
+}
+
+// Conflicts returns the conflicts waiting for the user, ordered by path.
+func (w *Watcher) Conflicts() []Conflict {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	var conflicts []Conflict
+
+	for _, c := range w.state.Conflicts {
+		conflicts = append(conflicts, c)
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Path < conflicts[j].Path })
+
+	return conflicts
+}
+
+// Resolve settles a conflict using the given strategy, the files are synced in the background.
+func (w *Watcher) Resolve(path, strategy string) error {
+	if strategy == Ask || !validStrategy(strategy) {
+		return fmt.Errorf("%v cannot resolve a conflict", strategy)
+	}
+
+	w.mutex.Lock()
+	_, ok := w.state.Conflicts[path]
+	w.mutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%v has no conflict", path)
+	}
+
+	select {
+	case w.resolutions <- resolution{path: path, strategy: strategy}:
+		return nil
+	default:
+		return fmt.Errorf("too many conflicts are being resolved, try %v again", path)
+	}
+}
+
+func validStrategy(strategy string) bool {
+	for _, s := range Strategies {
+		if s == strategy {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Name of the copy of a remote file kept next to a conflicting local file.
+func conflictPath(rel string, at time.Time) string {
+	extension := path.Ext(rel)
+
+	return fmt.Sprintf("%v (conflict %v)%v", strings.TrimSuffix(rel, extension), at.Format("2006-01-02 150405"), extension)
+}
+
+func (w *Watcher) setConflict(c Conflict) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.state.Conflicts[c.Path] = c
+	w.publish(c)
+}
+
+func (w *Watcher) clearConflict(rel string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if c, ok := w.state.Conflicts[rel]; ok {
+		delete(w.state.Conflicts, rel)
+		w.publish(c)
+	}
+}
+
+// Must be called with the mutex held.
+func (w *Watcher) publish(c Conflict) {
+	select {
+	case w.Updates <- c:
+	default:
+	}
+}
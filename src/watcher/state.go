@@ -0,0 +1,144 @@
+package watcher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// State maps the files of the watched folder to the names they are stored under.
+// Both sides are recorded as they were at the last sync, telling local edits, remote edits and conflicts apart.
+type State struct {
+	Root string
+	// Keyed by the path relative to Root.
+	Files map[string]Entry
+	// Conflicts waiting for the user to pick a side, keyed like Files.
+	Conflicts map[string]Conflict
+}
+
+type Entry struct {
+	Remote string
+	// Size and modification time at the last sync, a file is only hashed again once either changed.
+	Size    int64
+	ModTime time.Time
+	// SHA-256 of the content at the last sync.
+	Checksum string
+	// Version of the stored file at the last sync, empty until the server has listed it.
+	RemoteVersion string
+}
+
+func statePath() (string, error) {
+	cache, err := os.UserCacheDir()
+
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(cache, "throw")
+
+	return filepath.Join(dir, "watch.json"), os.MkdirAll(dir, 0700)
+}
+
+// LoadState returns the state of the folder at root. Watching another folder starts over with an empty state.
+func LoadState(root string) (*State, error) {
+	state := &State{Root: root, Files: make(map[string]Entry), Conflicts: make(map[string]Conflict)}
+	path, err := statePath()
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+
+	if err != nil && os.IsNotExist(err) {
+		return state, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	saved := &State{}
+
+	if err := json.Unmarshal(data, saved); err != nil {
+		return nil, err
+	}
+
+	if saved.Root != root || saved.Files == nil {
+		return state, nil
+	}
+
+	if saved.Conflicts == nil {
+		saved.Conflicts = make(map[string]Conflict)
+	}
+
+	return saved, nil
+}
+
+// Save writes the state to a temporary file then renames, a crash mid write will never leave a torn state behind.
+func (s *State) Save() error {
+	path, err := statePath()
+
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(s, "", "")
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// Path returns the local path stored under a remote name.
+func (s *State) Path(remote string) (string, bool) {
+	for rel, entry := range s.Files {
+		if entry.Remote == remote {
+			return rel, true
+		}
+	}
+
+	return "", false
+}
+
+// Below returns the path itself and every path below it, in case it was a folder.
+func (s *State) Below(rel string) []string {
+	var paths []string
+
+	for r := range s.Files {
+		if r == rel || strings.HasPrefix(r, rel+"/") {
+			paths = append(paths, r)
+		}
+	}
+
+	return paths
+}
+
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+
+	h := sha256.New()
+
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,470 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BitlyTwiser/throw/src/filename"
+	"github.com/BitlyTwiser/throw/src/pufs_client"
+)
+
+// Syncs a path the file watcher reported. A removed folder syncs every file that was below it.
+func (w *Watcher) syncPath(ctx context.Context, path string) error {
+	rel, err := w.relativePath(path)
+
+	if err != nil {
+		return err
+	}
+
+	if _, known := w.state.Files[rel]; !known {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			for _, r := range w.state.Below(rel) {
+				if err := w.reconcile(ctx, r, w.strategy); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+	}
+
+	return w.reconcile(ctx, rel, w.strategy)
+}
+
+// Lists the server, then syncs every file known on either side.
+func (w *Watcher) listRemote(ctx context.Context) {
+	if err := w.refreshRemote(ctx); err != nil {
+		log.Printf("Error listing remote files. Error: %v", err)
+
+		return
+	}
+
+	paths := make(map[string]bool)
+
+	for rel := range w.state.Files {
+		paths[rel] = true
+	}
+
+	for name := range w.remote {
+		if _, known := w.state.Path(name); known {
+			continue
+		}
+
+		if rel, ok := w.remotePath(name); ok {
+			paths[rel] = true
+		}
+	}
+
+	var sorted []string
+
+	for rel := range paths {
+		sorted = append(sorted, rel)
+	}
+
+	sort.Strings(sorted)
+
+	for _, rel := range sorted {
+		// Files still being written are synced once they are quiet.
+		if w.pending(w.localPath(rel)) {
+			continue
+		}
+
+		w.report(rel, w.reconcile(ctx, rel, w.strategy))
+	}
+}
+
+func (w *Watcher) refreshRemote(ctx context.Context) error {
+	files, err := w.client.RemoteFiles(ctx)
+
+	if err != nil {
+		w.remote = nil
+
+		return err
+	}
+
+	w.remote = make(map[string]pufs_client.RemoteFile)
+
+	for _, f := range files {
+		w.remote[f.Name] = f
+	}
+
+	return nil
+}
+
+func (w *Watcher) pending(path string) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	_, ok := w.timers[path]
+
+	return ok
+}
+
+// Returns the local path of a stored file not yet synced. Names that cannot be decrypted, would leave the folder or are temporary files are skipped.
+func (w *Watcher) remotePath(stored string) (string, bool) {
+	name := w.client.DisplayName(stored)
+
+	if filename.IsEncrypted(stored) && name == filename.Placeholder(stored) {
+		return "", false
+	}
+
+	rel := path.Clean(strings.ReplaceAll(name, "\\", "/"))
+
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, "/") || strings.HasPrefix(rel, "../") || Ignored(path.Base(rel)) {
+		return "", false
+	}
+
+	// Another stored file already syncs to this path.
+	if entry, known := w.state.Files[rel]; known && entry.Remote != stored {
+		log.Printf("Not syncing %v, %v is synced from %v", stored, rel, entry.Remote)
+
+		return "", false
+	}
+
+	return rel, true
+}
+
+// Returns the stored copy of a path. Upload mode never lists the server, the stored copy of a synced file is assumed to exist.
+func (w *Watcher) remoteFor(rel string, entry Entry, known bool) (pufs_client.RemoteFile, bool) {
+	if w.remote == nil {
+		return pufs_client.RemoteFile{Name: entry.Remote}, known
+	}
+
+	if known {
+		f, ok := w.remote[entry.Remote]
+
+		return f, ok
+	}
+
+	for name, f := range w.remote {
+		if r, ok := w.remotePath(name); ok && r == rel {
+			if _, mapped := w.state.Path(name); !mapped {
+				return f, true
+			}
+		}
+	}
+
+	return pufs_client.RemoteFile{}, false
+}
+
+// Compares both sides of a path against the state at the last sync and applies the changes.
+// Strategy decides what happens when both sides changed.
+func (w *Watcher) reconcile(ctx context.Context, rel, strategy string) error {
+	entry, known := w.state.Files[rel]
+	local := w.localPath(rel)
+
+	info, err := os.Stat(local)
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	localExists := err == nil
+
+	if localExists && !info.Mode().IsRegular() {
+		return nil
+	}
+
+	var checksum string
+	localChange := changeNone
+
+	switch {
+	case !known && localExists:
+		localChange = ChangeCreated
+	case known && !localExists:
+		localChange = ChangeDeleted
+	case known && (entry.Size != info.Size() || !entry.ModTime.Equal(info.ModTime())):
+		if checksum, err = fileChecksum(local); err != nil {
+			return err
+		}
+
+		if checksum != entry.Checksum {
+			localChange = ChangeModified
+		} else {
+			// Touched but not changed.
+			entry.ModTime = info.ModTime()
+			w.state.Files[rel] = entry
+
+			if err := w.state.Save(); err != nil {
+				return err
+			}
+		}
+	}
+
+	remote, remoteExists := w.remoteFor(rel, entry, known)
+	remoteChange := changeNone
+
+	if w.remote != nil {
+		switch {
+		case !known && remoteExists:
+			remoteChange = ChangeCreated
+		case known && !remoteExists:
+			remoteChange = ChangeDeleted
+		case known && entry.RemoteVersion == "":
+			// Uploaded before the server was listed, the listed copy is the one uploaded.
+			entry.RemoteVersion = remote.Version()
+			w.state.Files[rel] = entry
+
+			if err := w.state.Save(); err != nil {
+				return err
+			}
+		case known && remote.Version() != entry.RemoteVersion:
+			remoteChange = ChangeModified
+		}
+	}
+
+	switch {
+	case localChange == changeNone && remoteChange == changeNone:
+		w.clearConflict(rel)
+
+		return nil
+	case remoteChange == changeNone:
+		return w.applyLocal(ctx, rel, remote, remoteExists)
+	case localChange == changeNone:
+		return w.applyRemote(ctx, rel, remote, remoteExists)
+	}
+
+	// Both sides changed.
+	if !localExists && !remoteExists {
+		delete(w.state.Files, rel)
+		w.clearConflict(rel)
+
+		return w.state.Save()
+	}
+
+	if localExists && remoteExists {
+		if checksum == "" {
+			if checksum, err = fileChecksum(local); err != nil {
+				return err
+			}
+		}
+
+		// The same content was written to both sides.
+		if m := w.client.GetFileMetadata(remote.Name); m != nil && m.Checksum == checksum && m.IpfsHash == remote.IpfsHash {
+			w.state.Files[rel] = Entry{Remote: remote.Name, Size: info.Size(), ModTime: info.ModTime(), Checksum: checksum, RemoteVersion: remote.Version()}
+			w.clearConflict(rel)
+
+			return w.state.Save()
+		}
+	}
+
+	switch strategy {
+	case PreferLocal:
+		w.clearConflict(rel)
+
+		return w.applyLocal(ctx, rel, remote, remoteExists)
+	case PreferRemote:
+		w.clearConflict(rel)
+
+		return w.applyRemote(ctx, rel, remote, remoteExists)
+	case KeepBoth:
+		w.clearConflict(rel)
+
+		// The remote copy is kept under a conflict name, it is uploaded as a new file once the watcher sees it.
+		if localExists && remoteExists {
+			if err := w.download(ctx, remote, conflictPath(rel, time.Now())); err != nil {
+				return err
+			}
+		}
+
+		// A file deleted on one side and changed on the other is kept.
+		if localExists {
+			return w.applyLocal(ctx, rel, remote, remoteExists)
+		}
+
+		return w.applyRemote(ctx, rel, remote, remoteExists)
+	}
+
+	w.mutex.Lock()
+	_, asked := w.state.Conflicts[rel]
+	w.mutex.Unlock()
+
+	if asked {
+		return nil
+	}
+
+	conflict := Conflict{Path: rel, Local: localChange, Remote: remoteChange, DetectedAt: time.Now()}
+
+	if remoteExists {
+		conflict.RemoteName = remote.Name
+	}
+
+	w.setConflict(conflict)
+	w.client.NotifyError(fmt.Sprintf("Sync conflict, %v. Pick a side within the conflicts view", conflict))
+
+	return w.state.Save()
+}
+
+// Makes the stored copy match the local file. pufs cannot replace a file, the stored copy is deleted and uploaded again under the same name.
+// The local file is read and checked against the upload policy first, the stored copy is only deleted once the upload can go ahead.
+func (w *Watcher) applyLocal(ctx context.Context, rel string, remote pufs_client.RemoteFile, remoteExists bool) error {
+	local := w.localPath(rel)
+	info, err := os.Stat(local)
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	name := rel
+
+	if remoteExists {
+		name = remote.Name
+	}
+
+	var checksum string
+
+	if err == nil {
+		if checksum, err = fileChecksum(local); err != nil {
+			return err
+		}
+
+		if _, err = w.client.CheckPolicy(ctx, local, rel); err != nil {
+			return err
+		}
+	}
+
+	if remoteExists {
+		deleteCtx := ctx
+
+		// Replaced rather than deleted, links to it keep working once it is uploaded again.
+		if info != nil {
+			deleteCtx = pufs_client.WithReplace(ctx)
+		}
+
+		if err := w.client.DeleteFile(deleteCtx, remote.Name, false); err != nil {
+			return err
+		}
+
+		if w.remote != nil {
+			delete(w.remote, remote.Name)
+		}
+	}
+
+	delete(w.state.Files, rel)
+
+	if err := w.state.Save(); err != nil {
+		return err
+	}
+
+	if info == nil {
+		log.Printf("Deleted %v, it was removed locally", rel)
+
+		return nil
+	}
+
+	uploadCtx := ctx
+
+	if remoteExists {
+		uploadCtx = pufs_client.WithExactName(ctx)
+	}
+
+	var stored string
+	uploadCtx = pufs_client.WithStoredName(uploadCtx, func(name string) { stored = name })
+
+	log.Printf("Uploading %v", rel)
+
+	if err := w.client.UploadFile(uploadCtx, local, name); err != nil {
+		return err
+	}
+
+	entry := Entry{Remote: stored, Size: info.Size(), ModTime: info.ModTime(), Checksum: checksum}
+
+	// The new version is recorded, so it is not mistaken for a remote edit. Left empty, it is taken from the next listing.
+	if w.mode == ModeTwoWay {
+		if err := w.refreshRemote(ctx); err != nil {
+			log.Printf("Error listing remote files. Error: %v", err)
+		} else if f, ok := w.remote[stored]; ok {
+			entry.RemoteVersion = f.Version()
+		}
+	}
+
+	w.state.Files[rel] = entry
+
+	return w.state.Save()
+}
+
+// Makes the local file match the stored copy.
+func (w *Watcher) applyRemote(ctx context.Context, rel string, remote pufs_client.RemoteFile, remoteExists bool) error {
+	if !remoteExists {
+		delete(w.state.Files, rel)
+
+		if err := w.state.Save(); err != nil {
+			return err
+		}
+
+		log.Printf("Deleting %v, it was deleted remotely", rel)
+
+		if err := os.Remove(w.localPath(rel)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return nil
+	}
+
+	log.Printf("Downloading %v", rel)
+
+	if err := w.download(ctx, remote, rel); err != nil {
+		return err
+	}
+
+	local := w.localPath(rel)
+	info, err := os.Stat(local)
+
+	if err != nil {
+		return err
+	}
+
+	checksum, err := fileChecksum(local)
+
+	if err != nil {
+		return err
+	}
+
+	w.state.Files[rel] = Entry{Remote: remote.Name, Size: info.Size(), ModTime: info.ModTime(), Checksum: checksum, RemoteVersion: remote.Version()}
+
+	return w.state.Save()
+}
+
+// Downloads a stored file to a path within the folder. The download lands in a hidden folder first, so a partial file never shows up as a local change.
+func (w *Watcher) download(ctx context.Context, remote pufs_client.RemoteFile, rel string) error {
+	target := w.localPath(rel)
+	dir := filepath.Dir(target)
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	temp, err := os.MkdirTemp(dir, tempPrefix)
+
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(temp)
+
+	if err := w.client.DownloadTo(ctx, remote.Name, temp); err != nil {
+		return err
+	}
+
+	files, err := os.ReadDir(temp)
+
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if f.Type().IsRegular() && !Ignored(f.Name()) {
+			return os.Rename(filepath.Join(temp, f.Name()), target)
+		}
+	}
+
+	return errors.New("downloaded file not found")
+}
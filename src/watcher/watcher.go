@@ -0,0 +1,317 @@
+// Package watcher keeps a local folder in sync with pufs.
+// New and changed files are uploaded once they stop changing, files deleted locally are deleted from the server.
+// In two-way mode files uploaded or deleted by other clients are applied to the folder as well, changes to both sides of a file are resolved by the conflict strategy.
+// The state of every file at its last sync is persisted, so changes made while throw was not running are picked up on the next start.
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BitlyTwiser/throw/src/pufs_client"
+	"github.com/fsnotify/fsnotify"
+)
+
+// Bursts of events for the same path are handled once the path has been quiet this long.
+const DefaultDebounce = 2 * time.Second
+
+// The server event stream only announces uploads, deletes by other clients are found by listing the server this often.
+const DefaultPollInterval = 30 * time.Second
+
+const (
+	// Local changes are uploaded, the server is never read back.
+	ModeUpload = "upload"
+	// Changes on either side are applied to the other.
+	ModeTwoWay = "two-way"
+)
+
+var Modes = []string{ModeUpload, ModeTwoWay}
+
+// Downloads land in a hidden folder next to their target before they are moved into place.
+const tempPrefix = ".throw-sync-"
+
+// Editor swap files, lock files and partial downloads never leave the machine.
+var ignoredPatterns = []string{
+	"*.swp", "*.swx", "*.swo", "*~", "4913",
+	"*.tmp", "*.temp", "*.part", "*.partial", "*.crdownload", "*.download",
+	"*.lock", ".~lock.*", "~$*", ".#*", "#*#",
+	".DS_Store", "Thumbs.db", "desktop.ini", tempPrefix + "*",
+}
+
+type Watcher struct {
+	// Conflicts found or resolved are pushed onto Updates. Sends never block, Conflicts always returns the current state.
+	Updates  chan Conflict
+	client   *pufs_client.IpfsClient
+	root     string
+	mode     string
+	strategy string
+	debounce time.Duration
+	state    *State
+	// Files held by the server at the last listing, keyed by stored name. Nil until the server has been listed, and always in upload mode.
+	remote map[string]pufs_client.RemoteFile
+	// Guards the timers and the conflicts within the state, everything else is only touched by Run.
+	mutex       sync.Mutex
+	timers      map[string]*time.Timer
+	ready       chan string
+	resolutions chan resolution
+}
+
+// New returns a watcher of the folder at root. Run starts it.
+func New(client *pufs_client.IpfsClient, root string, debounce time.Duration) (*Watcher, error) {
+	root, err := filepath.Abs(root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if info, err := os.Stat(root); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("%v is not a folder", root)
+	}
+
+	// Downloads would be uploaded again as new files.
+	if download, err := filepath.Abs(client.Settings.DownloadPath); err == nil && client.Settings.DownloadPath != "" {
+		if rel, err := filepath.Rel(root, download); err == nil && !strings.HasPrefix(rel, "..") {
+			return nil, fmt.Errorf("the download path %v is within the watched folder %v", download, root)
+		}
+	}
+
+	state, err := LoadState(root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mode := client.Settings.SyncMode
+	if mode == "" {
+		mode = ModeUpload
+	}
+
+	strategy := client.Settings.ConflictStrategy
+	if strategy == "" {
+		strategy = KeepBoth
+	}
+
+	if !validStrategy(strategy) {
+		return nil, fmt.Errorf("unknown conflict strategy: %v", strategy)
+	}
+
+	return &Watcher{
+		Updates:     make(chan Conflict, 16),
+		client:      client,
+		root:        root,
+		mode:        mode,
+		strategy:    strategy,
+		debounce:    debounce,
+		state:       state,
+		timers:      make(map[string]*time.Timer),
+		ready:       make(chan string, 100),
+		resolutions: make(chan resolution, 16),
+	}, nil
+}
+
+// Mode returns the sync mode the watcher runs in.
+func (w *Watcher) Mode() string {
+	return w.mode
+}
+
+// Ignored reports if a file name is a temporary or lock file.
+func Ignored(name string) bool {
+	for _, pattern := range ignoredPatterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Run watches the folder until ctx is cancelled. Files changed since the last run are synced first.
+func (w *Watcher) Run(ctx context.Context) error {
+	fsw, err := fsnotify.NewWatcher()
+
+	if err != nil {
+		return err
+	}
+
+	defer fsw.Close()
+
+	// fsnotify does not watch sub folders, every folder is added on its own.
+	if err := w.addFolders(ctx, fsw, w.root); err != nil {
+		return err
+	}
+
+	for rel := range w.state.Files {
+		w.schedule(ctx, w.localPath(rel))
+	}
+
+	// Nil channels never fire, upload mode does not look at the server.
+	var poll <-chan time.Time
+	var remoteChanges <-chan string
+
+	if w.mode == ModeTwoWay {
+		w.listRemote(ctx)
+
+		ticker := time.NewTicker(DefaultPollInterval)
+		defer ticker.Stop()
+
+		poll = ticker.C
+		remoteChanges = w.client.RemoteChanges()
+	}
+
+	log.Printf("Watching %v, mode: %v", w.root, w.mode)
+
+	for {
+		select {
+		case <-ctx.Done():
+			w.stopTimers()
+
+			return nil
+		case event, ok := <-fsw.Events:
+			if !ok {
+				return errors.New("file watcher closed")
+			}
+
+			w.handle(ctx, fsw, event)
+		case err, ok := <-fsw.Errors:
+			if !ok {
+				return errors.New("file watcher closed")
+			}
+
+			log.Printf("Error watching %v. Error: %v", w.root, err)
+		case path := <-w.ready:
+			w.report(path, w.syncPath(ctx, path))
+		case <-poll:
+			w.listRemote(ctx)
+		case <-remoteChanges:
+			// The server announces every file after each change, a single listing covers all of them.
+			drain(remoteChanges)
+			w.listRemote(ctx)
+		case r := <-w.resolutions:
+			w.report(r.path, w.reconcile(ctx, r.path, r.strategy))
+		}
+	}
+}
+
+func drain(changes <-chan string) {
+	for {
+		select {
+		case <-changes:
+		default:
+			return
+		}
+	}
+}
+
+func (w *Watcher) report(path string, err error) {
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("Error syncing %v. Error: %v", path, err)
+		w.client.NotifyError(fmt.Sprintf("Error syncing %v. Error: %v", filepath.Base(path), err))
+	}
+}
+
+func (w *Watcher) handle(ctx context.Context, fsw *fsnotify.Watcher, event fsnotify.Event) {
+	if Ignored(filepath.Base(event.Name)) {
+		return
+	}
+
+	// A folder created or moved into the watched folder brings its files along.
+	if event.Op&fsnotify.Create != 0 {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			if err := w.addFolders(ctx, fsw, event.Name); err != nil {
+				log.Printf("Error watching %v. Error: %v", event.Name, err)
+			}
+
+			return
+		}
+	}
+
+	if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Remove|fsnotify.Rename) != 0 {
+		w.schedule(ctx, event.Name)
+	}
+}
+
+// Watches root and every folder below it, scheduling every file found.
+func (w *Watcher) addFolders(ctx context.Context, fsw *fsnotify.Watcher, root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Printf("Error walking %v. Error: %v", path, err)
+
+			return nil
+		}
+
+		if info.IsDir() {
+			if path != root && Ignored(info.Name()) {
+				return filepath.SkipDir
+			}
+
+			return fsw.Add(path)
+		}
+
+		if !Ignored(info.Name()) {
+			w.schedule(ctx, path)
+		}
+
+		return nil
+	})
+}
+
+// Restarts the quiet period of a path, it is synced once no event arrived for the debounce duration.
+func (w *Watcher) schedule(ctx context.Context, path string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if timer, ok := w.timers[path]; ok {
+		timer.Reset(w.debounce)
+
+		return
+	}
+
+	w.timers[path] = time.AfterFunc(w.debounce, func() {
+		w.mutex.Lock()
+		delete(w.timers, path)
+		w.mutex.Unlock()
+
+		select {
+		case w.ready <- path:
+		case <-ctx.Done():
+		}
+	})
+}
+
+func (w *Watcher) stopTimers() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for path, timer := range w.timers {
+		timer.Stop()
+		delete(w.timers, path)
+	}
+}
+
+// Relative paths use forward slashes, they double as the name a file is uploaded under.
+func (w *Watcher) relativePath(path string) (string, error) {
+	rel, err := filepath.Rel(w.root, path)
+
+	if err != nil {
+		return "", err
+	}
+
+	if rel == "." || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("%v is outside of the watched folder", path)
+	}
+
+	return filepath.ToSlash(rel), nil
+}
+
+func (w *Watcher) localPath(rel string) string {
+	return filepath.Join(w.root, filepath.FromSlash(rel))
+}